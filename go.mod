@@ -3,13 +3,16 @@ module github.com/hashmap-kz/streamcrypt
 go 1.25.0
 
 require (
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/klauspost/compress v1.18.6
+	github.com/klauspost/reedsolomon v1.14.2
 	github.com/stretchr/testify v1.11.1
 	golang.org/x/crypto v0.50.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/klauspost/cpuid/v2 v2.3.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/sys v0.43.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
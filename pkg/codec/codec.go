@@ -3,10 +3,12 @@ package codec
 import "io"
 
 const (
-	GzipFileExt  = ".gz"
-	GzipCompName = "gzip"
-	ZstdFileExt  = ".zst"
-	ZstdCompName = "zstd"
+	GzipFileExt     = ".gz"
+	GzipCompName    = "gzip"
+	ZstdFileExt     = ".zst"
+	ZstdCompName    = "zstd"
+	DeflateFileExt  = ".deflate"
+	DeflateCompName = "deflate"
 )
 
 type Flusher interface {
@@ -38,6 +40,8 @@ func GetDecompressor(c Compressor) Decompressor {
 		return &GzipDecompressor{}
 	case ZstdFileExt:
 		return &ZstdDecompressor{}
+	case DeflateFileExt:
+		return &DeflateDecompressor{}
 	default:
 		return nil
 	}
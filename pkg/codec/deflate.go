@@ -0,0 +1,56 @@
+package codec
+
+import (
+	"compress/flate"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- Deflate Compressor ---
+
+// DeflateCompressor writes raw deflate (RFC 1951) without a gzip or zlib wrapper, as used
+// inside ZIP entries.
+type DeflateCompressor struct{}
+
+var _ Compressor = &DeflateCompressor{}
+
+func (DeflateCompressor) FileExtension() string {
+	return DeflateFileExt
+}
+
+func (DeflateCompressor) Algorithms() []policy.Algorithm {
+	return []policy.Algorithm{{Kind: policy.Compression, Name: DeflateCompName}}
+}
+
+func (c DeflateCompressor) NewWriter(w io.Writer) (WriteFlushCloser, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	return flate.NewWriter(w, flate.DefaultCompression)
+}
+
+func (DeflateCompressor) Name() string {
+	return DeflateCompName
+}
+
+// --- Deflate Decompressor ---
+
+type DeflateDecompressor struct{}
+
+var _ Decompressor = &DeflateDecompressor{}
+
+func (DeflateDecompressor) FileExtension() string {
+	return DeflateFileExt
+}
+
+func (DeflateDecompressor) Algorithms() []policy.Algorithm {
+	return DeflateCompressor{}.Algorithms()
+}
+
+func (d DeflateDecompressor) Decompress(r io.Reader) (io.ReadCloser, error) {
+	if err := policy.Check(d); err != nil {
+		return nil, err
+	}
+	return flate.NewReader(r), nil
+}
@@ -0,0 +1,37 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeflateCompressor_RoundTrip(t *testing.T) {
+	original := bytes.Repeat([]byte("this is a test of raw deflate compression "), 100)
+
+	var buf bytes.Buffer
+	writer, err := DeflateCompressor{}.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = writer.Write(original)
+	require.NoError(t, err)
+	assert.NoError(t, writer.Flush())
+	assert.NoError(t, writer.Close())
+	assert.Less(t, buf.Len(), len(original))
+
+	reader, err := DeflateDecompressor{}.Decompress(&buf)
+	require.NoError(t, err)
+	defer reader.Close()
+	result, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, original, result)
+}
+
+func TestDeflateCompressor_Metadata(t *testing.T) {
+	assert.Equal(t, ".deflate", DeflateCompressor{}.FileExtension())
+	assert.Equal(t, "deflate", DeflateCompressor{}.Name())
+	assert.Equal(t, ".deflate", DeflateDecompressor{}.FileExtension())
+	assert.IsType(t, &DeflateDecompressor{}, GetDecompressor(DeflateCompressor{}))
+}
@@ -3,6 +3,8 @@ package codec
 import (
 	"compress/gzip"
 	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
 )
 
 // --- Gzip Compressor ---
@@ -15,7 +17,14 @@ func (GzipCompressor) FileExtension() string {
 	return GzipFileExt
 }
 
-func (GzipCompressor) NewWriter(w io.Writer) (WriteFlushCloser, error) {
+func (GzipCompressor) Algorithms() []policy.Algorithm {
+	return []policy.Algorithm{{Kind: policy.Compression, Name: GzipCompName}}
+}
+
+func (c GzipCompressor) NewWriter(w io.Writer) (WriteFlushCloser, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
 	gw := gzip.NewWriter(w)
 	return &gzipWrapper{Writer: gw}, nil
 }
@@ -46,6 +55,13 @@ func (GzipDecompressor) FileExtension() string {
 	return GzipFileExt
 }
 
-func (GzipDecompressor) Decompress(r io.Reader) (io.ReadCloser, error) {
+func (GzipDecompressor) Algorithms() []policy.Algorithm {
+	return GzipCompressor{}.Algorithms()
+}
+
+func (d GzipDecompressor) Decompress(r io.Reader) (io.ReadCloser, error) {
+	if err := policy.Check(d); err != nil {
+		return nil, err
+	}
 	return gzip.NewReader(r)
 }
@@ -7,6 +7,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
 )
 
 func TestGzipCompressor_RoundTrip(t *testing.T) {
@@ -42,3 +44,18 @@ func TestGzipCompressor_Metadata(t *testing.T) {
 	decomp := GzipDecompressor{}
 	assert.Equal(t, ".gz", decomp.FileExtension())
 }
+
+func TestGzip_Policy(t *testing.T) {
+	t.Cleanup(func() { policy.SetDefault(nil) })
+
+	// Compression is not restricted by FIPS, but a policy can restrict it.
+	policy.SetDefault(policy.FIPS())
+	_, err := GzipCompressor{}.NewWriter(io.Discard)
+	require.NoError(t, err)
+
+	policy.SetDefault(&policy.Policy{Name: "zstd-only", Allowed: map[policy.Kind][]string{policy.Compression: {ZstdCompName}}})
+	_, err = GzipCompressor{}.NewWriter(io.Discard)
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+	_, err = GzipDecompressor{}.Decompress(bytes.NewReader(nil))
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+}
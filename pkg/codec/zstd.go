@@ -4,6 +4,8 @@ import (
 	"io"
 
 	"github.com/klauspost/compress/zstd"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
 )
 
 // --- Zstd Compressor ---
@@ -16,7 +18,14 @@ func (ZstdCompressor) FileExtension() string {
 	return ZstdFileExt
 }
 
-func (ZstdCompressor) NewWriter(w io.Writer) (WriteFlushCloser, error) {
+func (ZstdCompressor) Algorithms() []policy.Algorithm {
+	return []policy.Algorithm{{Kind: policy.Compression, Name: ZstdCompName}}
+}
+
+func (c ZstdCompressor) NewWriter(w io.Writer) (WriteFlushCloser, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
 	zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedDefault))
 	if err != nil {
 		return nil, err
@@ -38,7 +47,14 @@ func (ZstdDecompressor) FileExtension() string {
 	return ZstdFileExt
 }
 
-func (ZstdDecompressor) Decompress(r io.Reader) (io.ReadCloser, error) {
+func (ZstdDecompressor) Algorithms() []policy.Algorithm {
+	return ZstdCompressor{}.Algorithms()
+}
+
+func (d ZstdDecompressor) Decompress(r io.Reader) (io.ReadCloser, error) {
+	if err := policy.Check(d); err != nil {
+		return nil, err
+	}
 	decoder, err := zstd.NewReader(r)
 	if err != nil {
 		return nil, err
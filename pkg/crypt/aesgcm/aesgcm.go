@@ -1,32 +1,67 @@
 package aesgcm
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
-
-	"golang.org/x/crypto/argon2"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
 )
 
 // --- Constants ---
 
 const (
-	chunkSize    = 64 * 1024
-	nonceSize    = 12 // AES-GCM requires a 12-byte (96-bit) nonce for optimal performance.
-	saltSize     = 16 // A 128-bit salt is standard in key derivation (like Argon2, PBKDF2, scrypt).
-	keySize      = 32 // AES-256 requires a 256-bit key = 32 bytes.
-	headerPrefix = "AEADv1"
+	chunkSize      = chunked.ChunkSize
+	saltSize       = 16 // A 128-bit salt is standard in key derivation (like Argon2, PBKDF2, scrypt).
+	keySize        = 32 // AES-256 requires a 256-bit key = 32 bytes.
+	headerPrefix   = "AEADv1"
+	headerPrefixV2 = "AEADv2"
+
+	// AEADv2 streams are written only when a feature needs them (flags below):
+	//
+	//	"AEADv2" || flags(1) || [digest algorithm(1)] || [kdf parameters] || salt(16) ||
+	//	[key check(16) || context check(16)] || [metadata record] || chunks...
+	//
+	// The stream key is HKDF-SHA256(KDF(password, salt), header), which binds the header to every chunk.
+	// The KDF is Argon2id with DefaultArgon2Params unless the header records another (see kdf.go).
+	// Associated data is mixed into the stream key; the two checks tell a wrong password from a wrong context.
+	// The last chunk is marked final, so truncation is detected. Flushable streams length-prefix every chunk.
+	flagMetadata     byte = 1 << 0
+	flagTrailer      byte = 1 << 1
+	flagPadding      byte = 1 << 2
+	flagContext      byte = 1 << 3
+	flagFlush        byte = 1 << 4
+	flagKDF          byte = 1 << 5
+	knownFlags            = flagMetadata | flagTrailer | flagPadding | flagContext | flagFlush | flagKDF
+	maxMetadataSize       = 1 << 20
+	checkSize             = 16
+	v2KeyInfo             = "streamcrypt aes-256-gcm v2 stream key"
+	keyCheckInfo          = "streamcrypt aes-256-gcm key check"
+	contextCheckInfo      = "streamcrypt aes-256-gcm context check"
 )
 
 // --- Key Derivation ---
 
 func GeneratePBEKey(password string, salt []byte) []byte {
-	return argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, keySize)
+	p := []byte(password)
+	defer clear(p)
+	return DerivePBEKey(p, salt)
+}
+
+// DerivePBEKey is GeneratePBEKey for a password held in a []byte, which the caller can wipe.
+func DerivePBEKey(password, salt []byte) []byte {
+	return DefaultArgon2Params.deriveKey(password, salt)
 }
 
 func GenerateRandomNBytes(n int) ([]byte, error) {
@@ -35,13 +70,51 @@ func GenerateRandomNBytes(n int) ([]byte, error) {
 	return b, err
 }
 
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
 // --- Chunked GCM Crypter ---
 
 type ChunkedGCMCrypter struct {
 	Password string
+
+	// SecretKey, when set, holds the password instead of Password and is zeroed by Destroy.
+	// Unlike a string, it can be wiped once the crypter is no longer needed.
+	SecretKey *crypt.SecretKey
+
+	// Digest, when set, records the plaintext digest and length in an authenticated trailer.
+	// The writer and reader then implement crypt.DigestWriter and crypt.DigestReader.
+	Digest crypt.DigestAlgorithm
+
+	// Padding, when set, pads the plaintext (including the trailer) to hide its exact length,
+	// e.g. crypt.PadmePadding{} or crypt.BucketPadding{Size: 1 << 20}. The metadata record is not padded.
+	Padding crypt.Padding
+
+	// AssociatedData, when set, binds streams to a context such as the object path;
+	// see crypt.ContextCrypter.
+	AssociatedData []byte
+
+	// Flushable, when set, writes variable-length chunks, and the writer implements
+	// codec.Flusher: Flush seals the buffered plaintext right away, for live streams.
+	Flushable bool
+
+	// KDF, when set, replaces Argon2id with DefaultArgon2Params for new streams and is recorded
+	// in the header, e.g. parameters from Calibrate, ScryptParams or PBKDF2Params. Decryption
+	// always uses the KDF of the stream.
+	KDF KDF
 }
 
-var _ crypt.Crypter = &ChunkedGCMCrypter{}
+var (
+	_ crypt.MetadataCrypter = &ChunkedGCMCrypter{}
+	_ crypt.ContextCrypter  = &ChunkedGCMCrypter{}
+	_ crypt.Destroyer       = &ChunkedGCMCrypter{}
+	_ policy.Describer      = &ChunkedGCMCrypter{}
+)
 
 func NewChunkedGCMCrypter(password string) crypt.Crypter {
 	return &ChunkedGCMCrypter{
@@ -49,6 +122,40 @@ func NewChunkedGCMCrypter(password string) crypt.Crypter {
 	}
 }
 
+// NewChunkedGCMCrypterFromBytes copies password into a crypt.SecretKey, so the caller can
+// wipe password afterwards.
+func NewChunkedGCMCrypterFromBytes(password []byte) crypt.Crypter {
+	return &ChunkedGCMCrypter{
+		SecretKey: crypt.NewSecretKey(password),
+	}
+}
+
+// Destroy zeroes SecretKey and drops Password; the crypter cannot be used afterwards.
+// A Password string cannot be wiped, only released to the garbage collector.
+func (c *ChunkedGCMCrypter) Destroy() {
+	if c.SecretKey == nil {
+		c.SecretKey = &crypt.SecretKey{} // so later uses fail instead of using an empty password
+	}
+	c.SecretKey.Destroy()
+	c.Password = ""
+}
+
+// pbeKey derives the password key from SecretKey, or from Password when SecretKey is nil.
+func (c *ChunkedGCMCrypter) pbeKey(kdf KDF, salt []byte) ([]byte, error) {
+	if c.SecretKey == nil {
+		password := []byte(c.Password)
+		defer clear(password)
+		return kdf.DeriveKey(password, salt)
+	}
+	var key []byte
+	err := c.SecretKey.Use(func(password []byte) error {
+		var err error
+		key, err = kdf.DeriveKey(password, salt)
+		return err
+	})
+	return key, err
+}
+
 func (c *ChunkedGCMCrypter) FileExtension() string {
 	return ".aes"
 }
@@ -57,148 +164,362 @@ func (c *ChunkedGCMCrypter) Name() string {
 	return "aes-256-gcm"
 }
 
+// Algorithms describes new streams; Decrypt checks the algorithms recorded in each stream instead.
+func (c *ChunkedGCMCrypter) Algorithms() []policy.Algorithm {
+	kdf := c.KDF
+	if kdf == nil {
+		kdf = DefaultArgon2Params
+	}
+	return streamAlgorithms(kdf, c.Digest)
+}
+
+func streamAlgorithms(kdf KDF, digest crypt.DigestAlgorithm) []policy.Algorithm {
+	algs := []policy.Algorithm{
+		{Kind: policy.Cipher, Name: "aes-256-gcm", KeyBits: keySize * 8},
+		{Kind: policy.KDF, Name: kdf.Name()},
+	}
+	if digest != crypt.DigestNone {
+		algs = append(algs, policy.Algorithm{Kind: policy.Digest, Name: digest.String()})
+	}
+	return algs
+}
+
 func (c *ChunkedGCMCrypter) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	return c.EncryptWithMetadata(w, nil)
+}
+
+func (c *ChunkedGCMCrypter) EncryptWithContext(w io.Writer, associatedData []byte) (io.WriteCloser, error) {
+	return c.withAssociatedData(associatedData).Encrypt(w)
+}
+
+func (c *ChunkedGCMCrypter) DecryptWithContext(r io.Reader, associatedData []byte) (io.Reader, error) {
+	return c.withAssociatedData(associatedData).Decrypt(r)
+}
+
+func (c *ChunkedGCMCrypter) withAssociatedData(associatedData []byte) *ChunkedGCMCrypter {
+	bound := *c
+	bound.AssociatedData = associatedData
+	return &bound
+}
+
+func (c *ChunkedGCMCrypter) EncryptWithMetadata(w io.Writer, md *crypt.Metadata) (io.WriteCloser, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
 	salt, err := GenerateRandomNBytes(saltSize)
 	if err != nil {
 		return nil, err
 	}
-	key := GeneratePBEKey(c.Password, salt)
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
+	var flags byte
+	if md != nil {
+		flags |= flagMetadata
+	}
+	if c.Digest != crypt.DigestNone {
+		flags |= flagTrailer
+	}
+	if c.Padding != nil {
+		flags |= flagPadding
+	}
+	if len(c.AssociatedData) > 0 {
+		flags |= flagContext
 	}
-	aead, err := cipher.NewGCM(block)
+	if c.Flushable {
+		flags |= flagFlush
+	}
+	if c.KDF != nil {
+		flags |= flagKDF
+	}
+	s, err := c.newStream(flags, c.Digest, c.KDF, salt)
 	if err != nil {
 		return nil, err
 	}
 
-	if _, err := w.Write([]byte(headerPrefix)); err != nil {
+	// The header goes out in one write: an empty write to an io.Pipe blocks until the next read.
+	if _, err := w.Write(append(bytes.Clone(s.header), s.checks...)); err != nil {
 		return nil, err
 	}
-	if _, err := w.Write(salt); err != nil {
-		return nil, err
+	if md != nil {
+		if err := s.writeMetadata(w, md); err != nil {
+			return nil, err
+		}
 	}
 
-	return &gcmChunkedWriter{
-		aead:     aead,
-		w:        w,
-		buf:      make([]byte, 0, chunkSize),
-		chunkNum: 0,
-	}, nil
+	if s.flags == 0 {
+		return chunked.NewWriter(w, s.aead), nil
+	}
+	var cw io.WriteCloser
+	var chunks chunked.FlushWriteCloser
+	if s.flags&flagFlush != 0 {
+		chunks = chunked.NewFlushWriter(w, s.aead)
+		cw = chunks
+	} else {
+		cw = chunked.NewFinalWriter(w, s.aead)
+	}
+	if s.flags&flagPadding != 0 {
+		cw = newPadWriter(cw, c.Padding)
+	}
+	if s.flags&flagTrailer != 0 {
+		dw, err := newDigestWriter(cw, s.digest)
+		if err != nil {
+			return nil, err
+		}
+		if chunks != nil {
+			return &flushDigestWriter{digestWriter: dw, chunks: chunks}, nil
+		}
+		return dw, nil
+	}
+	if chunks != nil {
+		return &flushWriter{WriteCloser: cw, chunks: chunks}, nil
+	}
+	return cw, nil
 }
 
-type gcmChunkedWriter struct {
-	aead     cipher.AEAD
-	w        io.Writer
-	buf      []byte
-	chunkNum uint64
+func (c *ChunkedGCMCrypter) Decrypt(r io.Reader) (io.Reader, error) {
+	dec, _, err := c.DecryptWithMetadata(r)
+	return dec, err
 }
 
-func (g *gcmChunkedWriter) Write(p []byte) (int, error) {
-	total := 0
-	for len(p) > 0 {
-		space := chunkSize - len(g.buf)
-		if space > len(p) {
-			space = len(p)
+func (c *ChunkedGCMCrypter) DecryptWithMetadata(r io.Reader) (io.Reader, *crypt.Metadata, error) {
+	s, err := c.readHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var md *crypt.Metadata
+	if s.flags&flagMetadata != 0 {
+		if md, err = s.readMetadata(r); err != nil {
+			return nil, nil, err
 		}
-		g.buf = append(g.buf, p[:space]...)
-		p = p[space:]
-		total += space
+	}
+	if s.flags == 0 {
+		return chunked.NewReader(r, s.aead), md, nil
+	}
+	var cr io.Reader
+	if s.flags&flagFlush != 0 {
+		cr = chunked.NewFlushReader(r, s.aead)
+	} else {
+		cr = chunked.NewFinalReader(r, s.aead)
+	}
+	if s.flags&flagPadding != 0 {
+		cr = newPadReader(cr)
+	}
+	if s.flags&flagTrailer != 0 {
+		dr, err := newDigestReader(cr, s.digest)
+		return dr, md, err
+	}
+	return cr, md, nil
+}
+
+func (c *ChunkedGCMCrypter) ReadMetadata(r io.Reader) (*crypt.Metadata, error) {
+	s, err := c.readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if s.flags&flagMetadata == 0 {
+		return nil, nil
+	}
+	return s.readMetadata(r)
+}
 
-		if len(g.buf) == chunkSize {
-			if err := g.flush(); err != nil {
-				return total, err
+// --- Stream Header ---
+
+// stream holds the parsed header of one stream and the AEAD that seals its records and chunks.
+type stream struct {
+	header []byte
+	checks []byte
+	flags  byte
+	digest crypt.DigestAlgorithm
+	aead   cipher.AEAD
+}
+
+// newStream builds the header and stream key; streams without flags keep the AEADv1 format.
+func (c *ChunkedGCMCrypter) newStream(flags byte, digest crypt.DigestAlgorithm, kdf KDF, salt []byte) (*stream, error) {
+	var header []byte
+	if flags == 0 {
+		header = append([]byte(headerPrefix), salt...)
+	} else {
+		header = append([]byte(headerPrefixV2), flags)
+		if flags&flagTrailer != 0 {
+			if _, err := digest.New(); err != nil {
+				return nil, err
+			}
+			header = append(header, byte(digest))
+		}
+		if flags&flagKDF != 0 {
+			if err := kdf.validate(); err != nil {
+				return nil, err
 			}
+			header = append(header, kdf.marshal()...)
 		}
+		header = append(header, salt...)
 	}
-	return total, nil
-}
 
-func (g *gcmChunkedWriter) Close() error {
-	if len(g.buf) > 0 {
-		if err := g.flush(); err != nil {
-			return err
+	s := &stream{header: header, flags: flags, digest: digest}
+	if flags&flagKDF == 0 {
+		kdf = DefaultArgon2Params
+	}
+	key, err := c.pbeKey(kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+	// Derived keys are wiped as soon as the cipher is set up.
+	defer clear(key)
+	if flags != 0 {
+		info := v2KeyInfo
+		if flags&flagContext != 0 {
+			info += " context:" + string(c.AssociatedData)
+		}
+		pbeKey := key
+		if key, err = hkdf.Key(sha256.New, pbeKey, header, info, keySize); err != nil {
+			return nil, err
+		}
+		defer clear(key)
+		if flags&flagContext != 0 {
+			if s.checks, err = contextChecks(pbeKey, key, header); err != nil {
+				return nil, err
+			}
 		}
 	}
-	return nil
-}
 
-func (g *gcmChunkedWriter) flush() error {
-	nonce := make([]byte, nonceSize)
-	binary.BigEndian.PutUint64(nonce[4:], g.chunkNum)
-	ciphertext := g.aead.Seal(nil, nonce, g.buf, nil)
+	if s.aead, err = newGCM(key); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
 
-	if _, err := g.w.Write(nonce); err != nil {
-		return err
+// contextChecks returns a key check, which depends on the password only,
+// and a context check, which also depends on the associated data.
+func contextChecks(pbeKey, streamKey, header []byte) ([]byte, error) {
+	keyCheck, err := hkdf.Key(sha256.New, pbeKey, header, keyCheckInfo, checkSize)
+	if err != nil {
+		return nil, err
 	}
-	if _, err := g.w.Write(ciphertext); err != nil {
-		return err
+	contextCheck, err := hkdf.Key(sha256.New, streamKey, nil, contextCheckInfo, checkSize)
+	if err != nil {
+		return nil, err
 	}
-
-	g.chunkNum++
-	g.buf = g.buf[:0]
-	return nil
+	return append(keyCheck, contextCheck...), nil
 }
 
-func (c *ChunkedGCMCrypter) Decrypt(r io.Reader) (io.Reader, error) {
-	header := make([]byte, len(headerPrefix)+saltSize)
-	if _, err := io.ReadFull(r, header); err != nil {
+func (c *ChunkedGCMCrypter) readHeader(r io.Reader) (*stream, error) {
+	prefix := make([]byte, len(headerPrefix))
+	if _, err := io.ReadFull(r, prefix); err != nil {
 		return nil, err
 	}
-	if string(header[:len(headerPrefix)]) != headerPrefix {
+
+	var flags byte
+	var digest crypt.DigestAlgorithm
+	var kdf KDF
+	switch string(prefix) {
+	case headerPrefix:
+	case headerPrefixV2:
+		var b [1]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		flags = b[0]
+		if flags == 0 || flags&^knownFlags != 0 {
+			return nil, fmt.Errorf("unsupported header flags: %#x", flags)
+		}
+		if flags&flagTrailer != 0 {
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return nil, err
+			}
+			digest = crypt.DigestAlgorithm(b[0])
+		}
+		if flags&flagKDF != 0 {
+			var err error
+			if kdf, err = readKDF(r); err != nil {
+				return nil, err
+			}
+		}
+	default:
 		return nil, errors.New("invalid file header")
 	}
-	salt := header[len(headerPrefix):]
-	key := GeneratePBEKey(c.Password, salt)
+	if kdf == nil {
+		kdf = DefaultArgon2Params
+	}
+	if err := policy.CheckAlgorithms(streamAlgorithms(kdf, digest)...); err != nil {
+		return nil, err
+	}
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, err
+	}
+	if flags&flagContext == 0 {
+		if len(c.AssociatedData) > 0 {
+			return nil, fmt.Errorf("%w: the stream is not bound to any", crypt.ErrContextMismatch)
+		}
+		return c.newStream(flags, digest, kdf, salt)
+	}
+
+	checks := make([]byte, 2*checkSize)
+	if _, err := io.ReadFull(r, checks); err != nil {
 		return nil, err
 	}
-	aead, err := cipher.NewGCM(block)
+	s, err := c.newStream(flags, digest, kdf, salt)
 	if err != nil {
 		return nil, err
 	}
-
-	return &gcmChunkedReader{
-		aead:     aead,
-		r:        r,
-		chunkNum: 0,
-		buf:      nil,
-	}, nil
+	if !hmac.Equal(s.checks[:checkSize], checks[:checkSize]) {
+		return nil, errors.New("wrong password or corrupted header")
+	}
+	if !hmac.Equal(s.checks[checkSize:], checks[checkSize:]) {
+		return nil, crypt.ErrContextMismatch
+	}
+	return s, nil
 }
 
-type gcmChunkedReader struct {
-	aead     cipher.AEAD
-	r        io.Reader
-	chunkNum uint64
-	buf      []byte
+// --- Metadata Record ---
+//
+// The metadata record is length(4) || AES-GCM(JSON) with the header as associated data.
+// Its nonce starts with 0x01, so it never collides with the chunk nonces, which start with zeros.
+
+func metadataNonce() []byte {
+	nonce := make([]byte, chunked.NonceSize)
+	nonce[0] = 1
+	return nonce
 }
 
-func (g *gcmChunkedReader) Read(p []byte) (int, error) {
-	if len(g.buf) == 0 {
-		nonce := make([]byte, nonceSize)
-		if _, err := io.ReadFull(g.r, nonce); err != nil {
-			return 0, err
-		}
+func (s *stream) writeMetadata(w io.Writer, md *crypt.Metadata) error {
+	plain, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+	sealed := s.aead.Seal(nil, metadataNonce(), plain, s.header)
+	if len(sealed) > maxMetadataSize {
+		return fmt.Errorf("metadata too large: the sealed record is %d bytes, the limit is %d", len(sealed), maxMetadataSize)
+	}
 
-		ciphertext := make([]byte, chunkSize+g.aead.Overhead())
-		n, err := io.ReadFull(g.r, ciphertext)
-		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
-			return 0, err
-		}
-		ciphertext = ciphertext[:n]
+	record := binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))
+	if _, err := w.Write(append(record, sealed...)); err != nil {
+		return err
+	}
+	return nil
+}
 
-		plaintext, err := g.aead.Open(nil, nonce, ciphertext, nil)
-		if err != nil {
-			return 0, errors.New("decryption failed: tampering or corruption detected")
-		}
-		g.buf = plaintext
-		g.chunkNum++
+func (s *stream) readMetadata(r io.Reader) (*crypt.Metadata, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > maxMetadataSize {
+		return nil, errors.New("invalid metadata record size")
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return nil, err
 	}
 
-	n := copy(p, g.buf)
-	g.buf = g.buf[n:]
-	return n, nil
+	plain, err := s.aead.Open(nil, metadataNonce(), sealed, s.header)
+	if err != nil {
+		return nil, errors.New("metadata decryption failed: wrong password, tampering or corruption detected")
+	}
+	var md crypt.Metadata
+	if err := json.Unmarshal(plain, &md); err != nil {
+		return nil, fmt.Errorf("decode metadata: %w", err)
+	}
+	return &md, nil
 }
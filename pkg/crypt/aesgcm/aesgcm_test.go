@@ -12,6 +12,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
 )
 
 func TestChunkedGCMCrypto_SaltReadFailure(t *testing.T) {
@@ -243,6 +245,36 @@ func TestChunkedGCMCrypto_EncryptDecryptRoundtrip(t *testing.T) {
 	require.Equal(t, data, result)
 }
 
+func TestChunkedGCMCrypto_PasswordBytes(t *testing.T) {
+	password := []byte("s3cr3t")
+	crypter := NewChunkedGCMCrypterFromBytes(password).(*ChunkedGCMCrypter)
+	clear(password) // the constructor keeps its own copy
+
+	var buf bytes.Buffer
+	w, err := crypter.Encrypt(&buf)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	// Interchangeable with the string password.
+	r, err := NewChunkedGCMCrypter("s3cr3t").Decrypt(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	result, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(result))
+
+	crypter.Destroy()
+	_, err = crypter.Decrypt(bytes.NewReader(buf.Bytes()))
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+
+	withString := NewChunkedGCMCrypter("s3cr3t").(*ChunkedGCMCrypter)
+	withString.Destroy()
+	assert.Empty(t, withString.Password)
+	_, err = withString.Encrypt(io.Discard)
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed, "a destroyed crypter must not fall back to an empty password")
+}
+
 func TestChunkedGCMCrypto_DecryptCorrupted(t *testing.T) {
 	data := bytes.Repeat([]byte("x"), 10000)
 	var buf bytes.Buffer
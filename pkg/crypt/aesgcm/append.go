@@ -0,0 +1,156 @@
+package aesgcm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+)
+
+// --- Append ---
+
+// Append reopens the stream stored in f, so more plaintext can be written after it. The
+// trailing partial chunk is authenticated, decrypted and sealed again in front of the new
+// data, and the chunk counter carries on: the file still decrypts as one continuous stream.
+//
+// f is read and written at explicit offsets, so it must not be opened with O_APPEND. The last
+// chunk is rewritten in place; until Close returns, a crash leaves the file undecryptable.
+// Re-sealed chunks get a new append epoch in their nonce, so no nonce is ever reused. Streams
+// with a digest trailer or padding cover the whole plaintext and cannot be appended to.
+func (c *ChunkedGCMCrypter) Append(f io.ReadWriteSeeker) (io.WriteCloser, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	s, err := c.readHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	if s.flags&(flagTrailer|flagPadding) != 0 {
+		return nil, errors.New("cannot append to a stream with a digest trailer or padding")
+	}
+	if s.flags&flagMetadata != 0 {
+		if _, err := s.readMetadata(f); err != nil {
+			return nil, err
+		}
+	}
+	dataStart, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	framing := chunked.Fixed
+	switch {
+	case s.flags&flagFlush != 0:
+		framing = chunked.Flushable
+	case s.flags != 0:
+		framing = chunked.FixedFinal
+	}
+
+	var last lastChunk
+	if framing == chunked.Flushable {
+		last, err = findLastPrefixedChunk(f, dataStart, size)
+	} else {
+		last, err = findLastFixedChunk(s, framing, dataStart, size)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := chunked.Resume{ChunkNum: last.num}
+	offset := size
+	if last.length > 0 {
+		final := last.partial && framing != chunked.Fixed
+		pending, epoch, err := readChunk(f, s, last.offset+last.prefix, last.length, last.num, final)
+		if err != nil {
+			return nil, err
+		}
+		res.Epoch = epoch
+		if last.partial {
+			// The partial chunk is cut off and sealed again under a new epoch.
+			if epoch == chunked.MaxEpoch {
+				return nil, fmt.Errorf("stream was appended to too many times (%d)", epoch)
+			}
+			res = chunked.Resume{Epoch: epoch + 1, ChunkNum: last.num, Pending: pending}
+			offset = last.offset
+		} else {
+			// Nothing to re-seal: the last full chunk is only checked and its epoch kept.
+			res.ChunkNum++
+		}
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return chunked.ResumeWriter(f, s.aead, framing, res), nil
+}
+
+// lastChunk locates the last chunk of a stream; length is zero if there is none.
+type lastChunk struct {
+	num     uint64
+	offset  int64 // start of the frame, including its length prefix
+	prefix  int64 // size of the length prefix
+	length  int64 // frame length without the prefix
+	partial bool  // the chunk is final or short, and must be sealed again
+}
+
+// findLastFixedChunk relies on every chunk but the last filling a whole frame. A v2
+// stream always ends with a short final chunk; a v1 stream may end on a frame boundary.
+func findLastFixedChunk(s *stream, framing chunked.Framing, dataStart, size int64) (lastChunk, error) {
+	frameSize := int64(chunked.NonceSize + chunkSize + s.aead.Overhead())
+	full, tail := (size-dataStart)/frameSize, (size-dataStart)%frameSize
+	switch {
+	case tail != 0:
+		return lastChunk{num: uint64(full), offset: dataStart + full*frameSize, length: tail, partial: true}, nil
+	case framing != chunked.Fixed:
+		return lastChunk{}, chunked.ErrTruncated
+	case full == 0:
+		return lastChunk{}, nil
+	default:
+		return lastChunk{num: uint64(full - 1), offset: dataStart + (full-1)*frameSize, length: frameSize}, nil
+	}
+}
+
+// findLastPrefixedChunk walks the length prefixes of a flushable stream up to its final chunk.
+func findLastPrefixedChunk(f io.ReadSeeker, dataStart, size int64) (lastChunk, error) {
+	var length [4]byte
+	last := lastChunk{offset: dataStart, prefix: int64(len(length)), partial: true}
+	for {
+		if _, err := f.Seek(last.offset, io.SeekStart); err != nil {
+			return lastChunk{}, err
+		}
+		if _, err := io.ReadFull(f, length[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return lastChunk{}, chunked.ErrTruncated
+			}
+			return lastChunk{}, err
+		}
+		last.length = int64(binary.BigEndian.Uint32(length[:]))
+		end := last.offset + last.prefix + last.length
+		switch {
+		case end == size:
+			return last, nil
+		case end > size:
+			return lastChunk{}, chunked.ErrTruncated
+		}
+		last.offset = end
+		last.num++
+	}
+}
+
+// readChunk reads the frame of chunk n at offset and authenticates it.
+func readChunk(f io.ReadSeeker, s *stream, offset, length int64, n uint64, final bool) ([]byte, uint16, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(f, frame); err != nil {
+		return nil, 0, err
+	}
+	return chunked.OpenChunk(s.aead, frame, n, final)
+}
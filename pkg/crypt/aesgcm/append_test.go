@@ -0,0 +1,114 @@
+package aesgcm
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+func appendTo(t *testing.T, c *ChunkedGCMCrypter, path string, plain []byte) error {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer f.Close()
+	w, err := c.Append(f)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(plain); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func writeEncrypted(t *testing.T, encrypted []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "archive.aes")
+	require.NoError(t, os.WriteFile(path, encrypted, 0o600))
+	return path
+}
+
+func TestChunkedGCMCrypto_Append(t *testing.T) {
+	crypters := map[string]*ChunkedGCMCrypter{
+		"v1":      {Password: "append"},
+		"context": {Password: "append", AssociatedData: []byte("wal/000000010000000000000001")},
+	}
+	sizes := []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 7}
+	for name, c := range crypters {
+		for _, size := range sizes {
+			first := bytes.Repeat([]byte{0xa1}, size)
+			path := writeEncrypted(t, encryptWithMetadata(t, c, first, nil))
+
+			want := bytes.Clone(first)
+			for i, n := range []int{0, 10, chunkSize + 3, 2 * chunkSize} {
+				more := bytes.Repeat([]byte{byte(i)}, n)
+				require.NoError(t, appendTo(t, c, path, more), "%s size %d append %d", name, size, i)
+				want = append(want, more...)
+			}
+
+			encrypted, err := os.ReadFile(path)
+			require.NoError(t, err)
+			got, err := io.ReadAll(mustDecrypt(t, c, encrypted))
+			require.NoError(t, err, "%s size %d", name, size)
+			assert.True(t, bytes.Equal(want, got), "%s size %d", name, size)
+		}
+	}
+}
+
+func TestChunkedGCMCrypto_Append_KeepsMetadata(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "append"}
+	md := testMetadata()
+	path := writeEncrypted(t, encryptWithMetadata(t, c, []byte("line 1\n"), md))
+	require.NoError(t, appendTo(t, c, path, []byte("line 2\n")))
+
+	encrypted, err := os.ReadFile(path)
+	require.NoError(t, err)
+	r, got, err := c.DecryptWithMetadata(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	assert.Equal(t, md, got)
+	decrypted, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "line 1\nline 2\n", string(decrypted))
+}
+
+func TestChunkedGCMCrypto_Append_NeverReusesNonce(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "append"}
+	encrypted := encryptWithMetadata(t, c, []byte("partial"), nil)
+	path := writeEncrypted(t, encrypted)
+	require.NoError(t, appendTo(t, c, path, []byte(" and more")))
+
+	appended, err := os.ReadFile(path)
+	require.NoError(t, err)
+	headerLen := len(headerPrefix) + saltSize
+	oldNonce := encrypted[headerLen : headerLen+12]
+	newNonce := appended[headerLen : headerLen+12]
+	assert.NotEqual(t, oldNonce, newNonce, "the re-sealed chunk must use a fresh nonce")
+}
+
+func TestChunkedGCMCrypto_Append_Rejects(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "append"}
+	plain := bytes.Repeat([]byte{3}, chunkSize+100)
+
+	path := writeEncrypted(t, encryptWithMetadata(t, c, plain, nil))
+	wrong := &ChunkedGCMCrypter{Password: "wrong"}
+	require.ErrorContains(t, appendTo(t, wrong, path, []byte("x")), "decryption failed")
+
+	encrypted := encryptWithMetadata(t, c, plain, nil)
+	encrypted[len(encrypted)-1] ^= 1
+	require.ErrorContains(t, appendTo(t, c, writeEncrypted(t, encrypted), []byte("x")), "decryption failed")
+
+	withDigest := &ChunkedGCMCrypter{Password: "append", Digest: crypt.DigestSHA256}
+	path = writeEncrypted(t, encryptWithMetadata(t, withDigest, plain, nil))
+	require.ErrorContains(t, appendTo(t, withDigest, path, []byte("x")), "digest trailer or padding")
+
+	// A v2 stream that lost its final chunk is truncated, not a place to continue.
+	v2 := encryptWithMetadata(t, c, plain, testMetadata())
+	require.ErrorContains(t, appendTo(t, c, writeEncrypted(t, v2[:len(v2)-100-12-16]), []byte("x")), "truncated")
+}
@@ -0,0 +1,73 @@
+package aesgcm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+func encryptWithContext(t *testing.T, c *ChunkedGCMCrypter, plain, associatedData []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := c.EncryptWithContext(&buf, associatedData)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestChunkedGCMCrypto_Context_Roundtrip(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "context"}
+	plain := bytes.Repeat([]byte("bound "), 30000)
+	encrypted := encryptWithContext(t, c, plain, []byte("bucket/a.aes"))
+	assert.Nil(t, c.AssociatedData, "the crypter itself must not change")
+
+	r, err := c.DecryptWithContext(bytes.NewReader(encrypted), []byte("bucket/a.aes"))
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+}
+
+func TestChunkedGCMCrypto_Context_Mismatch(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "context"}
+	a := encryptWithContext(t, c, []byte("object a"), []byte("bucket/a.aes"))
+	b := encryptWithContext(t, c, []byte("object b"), []byte("bucket/b.aes"))
+
+	// Swapped objects.
+	_, err := c.DecryptWithContext(bytes.NewReader(b), []byte("bucket/a.aes"))
+	require.ErrorIs(t, err, crypt.ErrContextMismatch)
+	_, err = c.DecryptWithContext(bytes.NewReader(a), []byte("bucket/b.aes"))
+	require.ErrorIs(t, err, crypt.ErrContextMismatch)
+
+	// No context on either side.
+	_, err = c.Decrypt(bytes.NewReader(a))
+	require.ErrorIs(t, err, crypt.ErrContextMismatch)
+	plain := encryptWithMetadata(t, c, []byte("unbound"), nil)
+	_, err = c.DecryptWithContext(bytes.NewReader(plain), []byte("bucket/a.aes"))
+	require.ErrorIs(t, err, crypt.ErrContextMismatch)
+
+	// A wrong password is reported as such.
+	_, err = (&ChunkedGCMCrypter{Password: "other"}).DecryptWithContext(bytes.NewReader(a), []byte("bucket/a.aes"))
+	require.ErrorContains(t, err, "wrong password")
+}
+
+func TestChunkedGCMCrypto_Context_WithMetadataAndDigest(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "context", Digest: crypt.DigestSHA256, AssociatedData: []byte("tenant-1")}
+	encrypted := encryptWithMetadata(t, c, []byte("everything"), testMetadata())
+
+	md, err := c.ReadMetadata(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	assert.Equal(t, testMetadata(), md)
+
+	other := *c
+	other.AssociatedData = []byte("tenant-2")
+	_, err = other.ReadMetadata(bytes.NewReader(encrypted))
+	require.ErrorIs(t, err, crypt.ErrContextMismatch)
+}
@@ -0,0 +1,36 @@
+package aesgcm
+
+import (
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+)
+
+// --- Flushable Streams ---
+//
+// Digest and padding writers pass data straight through, so flushing the chunk writer
+// at the bottom of the stack seals everything written so far.
+
+var (
+	_ codec.WriteFlushCloser = &flushWriter{}
+	_ codec.WriteFlushCloser = &flushDigestWriter{}
+)
+
+type flushWriter struct {
+	io.WriteCloser
+	chunks codec.Flusher
+}
+
+func (f *flushWriter) Flush() error {
+	return f.chunks.Flush()
+}
+
+// flushDigestWriter keeps the crypt.DigestWriter methods of a flushable stream with a trailer.
+type flushDigestWriter struct {
+	*digestWriter
+	chunks codec.Flusher
+}
+
+func (f *flushDigestWriter) Flush() error {
+	return f.chunks.Flush()
+}
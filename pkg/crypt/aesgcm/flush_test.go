@@ -0,0 +1,118 @@
+package aesgcm
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+)
+
+func TestChunkedGCMCrypto_Flush_SealsBufferedData(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "flush", Flushable: true}
+	var buf bytes.Buffer
+	w, err := c.Encrypt(&buf)
+	require.NoError(t, err)
+	fw, ok := w.(codec.Flusher)
+	require.True(t, ok, "a flushable stream's writer must implement codec.Flusher")
+
+	_, err = w.Write([]byte("first line\n"))
+	require.NoError(t, err)
+	require.NoError(t, fw.Flush())
+	require.NoError(t, fw.Flush(), "flushing with nothing buffered is a no-op")
+
+	// Everything written so far can be read back before the stream is closed.
+	r, err := c.Decrypt(bytes.NewReader(bytes.Clone(buf.Bytes())))
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.ErrorIs(t, err, chunked.ErrTruncated)
+	assert.Equal(t, "first line\n", string(got))
+
+	_, err = w.Write([]byte("second line\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	got, err = io.ReadAll(mustDecrypt(t, c, buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "first line\nsecond line\n", string(got))
+}
+
+func TestChunkedGCMCrypto_Flush_WithEverything(t *testing.T) {
+	c := &ChunkedGCMCrypter{
+		Password:       "flush",
+		Digest:         crypt.DigestSHA256,
+		Padding:        crypt.BucketPadding{Size: 4096},
+		AssociatedData: []byte("logs/app.log"),
+		Flushable:      true,
+	}
+	var buf bytes.Buffer
+	w, err := c.EncryptWithMetadata(&buf, testMetadata())
+	require.NoError(t, err)
+
+	var want []byte
+	for i, size := range []int{1, chunkSize - 1, 3, 2*chunkSize + 5, 0, 100} {
+		part := bytes.Repeat([]byte{byte(i)}, size)
+		_, err := w.Write(part)
+		require.NoError(t, err)
+		require.NoError(t, w.(codec.Flusher).Flush())
+		want = append(want, part...)
+	}
+	require.NoError(t, w.Close())
+	digest, err := w.(crypt.DigestWriter).Digest()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(want)), digest.Length)
+
+	r, md, err := c.DecryptWithMetadata(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, testMetadata(), md)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(want, got))
+}
+
+func TestChunkedGCMCrypto_Flush_DetectsTampering(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "flush", Flushable: true}
+	var buf bytes.Buffer
+	w, err := c.Encrypt(&buf)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("short"))
+	require.NoError(t, err)
+	require.NoError(t, w.(codec.Flusher).Flush())
+	flushed := buf.Len()
+	require.NoError(t, w.Close())
+	encrypted := buf.Bytes()
+
+	read := func(b []byte) error {
+		_, err := io.ReadAll(mustDecrypt(t, c, b))
+		return err
+	}
+	require.ErrorIs(t, read(encrypted[:flushed]), chunked.ErrTruncated)
+	require.ErrorIs(t, read(append(bytes.Clone(encrypted), 0)), chunked.ErrTrailingData)
+
+	headerLen := len(headerPrefixV2) + 1 + saltSize
+	oversized := bytes.Clone(encrypted)
+	oversized[headerLen] = 0xff // length prefix of the first chunk
+	require.ErrorContains(t, read(oversized), "decryption failed")
+
+	_, err = c.DecryptSalvage(bytes.NewReader(encrypted), crypt.SalvageOptions{})
+	require.ErrorContains(t, err, "not supported for flushable streams")
+}
+
+func TestChunkedGCMCrypto_Flush_Append(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "flush", Flushable: true}
+	path := writeEncrypted(t, encryptWithMetadata(t, c, []byte("one\n"), nil))
+	require.NoError(t, appendTo(t, c, path, bytes.Repeat([]byte("two\n"), chunkSize)))
+	require.NoError(t, appendTo(t, c, path, []byte("three\n")))
+
+	encrypted, err := os.ReadFile(path)
+	require.NoError(t, err)
+	got, err := io.ReadAll(mustDecrypt(t, c, encrypted))
+	require.NoError(t, err)
+	want := append(append([]byte("one\n"), bytes.Repeat([]byte("two\n"), chunkSize)...), "three\n"...)
+	assert.True(t, bytes.Equal(want, got))
+}
@@ -0,0 +1,259 @@
+package aesgcm
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// --- Password KDFs ---
+//
+// Streams with a custom KDF record its algorithm and parameters in the header, after the flags:
+//
+//	kdf algorithm(1) || parameters
+//	argon2id:           time(4) || memory(4) || threads(1)
+//	scrypt:             log2 N(1) || r(4) || p(4)
+//	pbkdf2-hmac-sha256: iterations(4)
+//
+// The parameters are part of the header, so changing them changes the stream key as well as
+// the cost. Decryption refuses parameters beyond the limits below, so a crafted header cannot
+// make the reader allocate or compute without bound.
+
+const (
+	kdfArgon2id byte = 1
+	kdfScrypt   byte = 2
+	kdfPBKDF2   byte = 3
+
+	argon2ParamsSize = 4 + 4 + 1
+	scryptParamsSize = 1 + 4 + 4
+	pbkdf2ParamsSize = 4
+
+	MinArgon2Memory = 8 * 1024    // KiB; the lower bound used by Calibrate
+	MaxArgon2Memory = 1024 * 1024 // KiB, i.e. 1 GiB
+	MaxArgon2Time   = 16
+
+	MaxScryptMemory = 1 << 30 // bytes: 128 * r * (N + p + 2)
+	MaxScryptP      = 16
+
+	MinPBKDF2Iterations = 1000
+	MaxPBKDF2Iterations = 10_000_000
+
+	maxCalibrateThreads = 4
+)
+
+// KDF derives the password key of a stream. Argon2Params, ScryptParams and PBKDF2Params record
+// themselves in the stream header, so Decrypt picks the algorithm and cost automatically.
+type KDF interface {
+	// DeriveKey returns a 32-byte key; it does not retain password.
+	DeriveKey(password, salt []byte) ([]byte, error)
+	// Name identifies the algorithm, e.g. to a policy.Policy.
+	Name() string
+
+	validate() error
+	marshal() []byte
+}
+
+var (
+	_ KDF = Argon2Params{}
+	_ KDF = ScryptParams{}
+	_ KDF = PBKDF2Params{}
+)
+
+// readKDF reads the algorithm and parameters recorded in a header.
+func readKDF(r io.Reader) (KDF, error) {
+	var alg [1]byte
+	if _, err := io.ReadFull(r, alg[:]); err != nil {
+		return nil, err
+	}
+	var size int
+	switch alg[0] {
+	case kdfArgon2id:
+		size = argon2ParamsSize
+	case kdfScrypt:
+		size = scryptParamsSize
+	case kdfPBKDF2:
+		size = pbkdf2ParamsSize
+	default:
+		return nil, fmt.Errorf("unsupported kdf: %#x", alg[0])
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	var kdf KDF
+	switch alg[0] {
+	case kdfArgon2id:
+		kdf = Argon2Params{
+			Time:    binary.BigEndian.Uint32(b),
+			Memory:  binary.BigEndian.Uint32(b[4:]),
+			Threads: b[8],
+		}
+	case kdfScrypt:
+		kdf = ScryptParams{
+			LogN: b[0],
+			R:    binary.BigEndian.Uint32(b[1:]),
+			P:    binary.BigEndian.Uint32(b[5:]),
+		}
+	default:
+		kdf = PBKDF2Params{Iterations: binary.BigEndian.Uint32(b)}
+	}
+	if err := kdf.validate(); err != nil {
+		return nil, err
+	}
+	return kdf, nil
+}
+
+// --- Argon2id ---
+
+// Argon2Params are the Argon2id cost parameters. Memory is in KiB.
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// DefaultArgon2Params is the cost of streams without recorded KDF parameters.
+var DefaultArgon2Params = Argon2Params{Time: 1, Memory: 64 * 1024, Threads: 4}
+
+func (Argon2Params) Name() string { return "argon2id" }
+
+func (p Argon2Params) DeriveKey(password, salt []byte) ([]byte, error) {
+	return p.deriveKey(password, salt), nil
+}
+
+func (p Argon2Params) deriveKey(password, salt []byte) []byte {
+	return argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, keySize)
+}
+
+func (p Argon2Params) validate() error {
+	switch {
+	case p.Time < 1 || p.Time > MaxArgon2Time:
+		return fmt.Errorf("invalid argon2 time: %d, want 1 to %d", p.Time, MaxArgon2Time)
+	case p.Threads < 1:
+		return errors.New("invalid argon2 threads: 0")
+	case p.Memory < 8*uint32(p.Threads) || p.Memory > MaxArgon2Memory:
+		return fmt.Errorf("invalid argon2 memory: %d KiB, want %d to %d", p.Memory, 8*uint32(p.Threads), MaxArgon2Memory)
+	}
+	return nil
+}
+
+func (p Argon2Params) marshal() []byte {
+	b := []byte{kdfArgon2id}
+	b = binary.BigEndian.AppendUint32(b, p.Time)
+	b = binary.BigEndian.AppendUint32(b, p.Memory)
+	return append(b, p.Threads)
+}
+
+// --- scrypt ---
+
+// ScryptParams are the scrypt cost parameters, with N = 2^LogN. The memory cost is 128 * R * N bytes.
+type ScryptParams struct {
+	LogN uint8
+	R    uint32
+	P    uint32
+}
+
+// DefaultScryptParams are the interactive-use parameters recommended by the scrypt paper (32 MiB).
+var DefaultScryptParams = ScryptParams{LogN: 15, R: 8, P: 1}
+
+func (ScryptParams) Name() string { return "scrypt" }
+
+func (p ScryptParams) DeriveKey(password, salt []byte) ([]byte, error) {
+	return scrypt.Key(password, salt, 1<<p.LogN, int(p.R), int(p.P), keySize)
+}
+
+func (p ScryptParams) validate() error {
+	switch {
+	case p.LogN < 1 || p.LogN > 30:
+		return fmt.Errorf("invalid scrypt log2 N: %d, want 1 to 30", p.LogN)
+	case p.R < 1 || p.P < 1 || p.P > MaxScryptP:
+		return fmt.Errorf("invalid scrypt r=%d p=%d, want r >= 1 and p from 1 to %d", p.R, p.P, MaxScryptP)
+	case uint64(p.R) > MaxScryptMemory/128/(uint64(1)<<p.LogN+uint64(p.P)+2):
+		// scrypt allocates 128*r*N bytes for V, 128*r*p for B and 256*r for XY; this is
+		// 128*r*(N+p+2) > MaxScryptMemory, without overflow.
+		return fmt.Errorf("invalid scrypt parameters: r=%d, N=2^%d and p=%d need more than %d bytes of memory", p.R, p.LogN, p.P, uint64(MaxScryptMemory))
+	}
+	return nil
+}
+
+func (p ScryptParams) marshal() []byte {
+	b := []byte{kdfScrypt, p.LogN}
+	b = binary.BigEndian.AppendUint32(b, p.R)
+	return binary.BigEndian.AppendUint32(b, p.P)
+}
+
+// --- PBKDF2 ---
+
+// PBKDF2Params select PBKDF2-HMAC-SHA256, for deployments limited to FIPS-approved primitives.
+type PBKDF2Params struct {
+	Iterations uint32
+}
+
+// DefaultPBKDF2Params follow the OWASP recommendation for PBKDF2-HMAC-SHA256.
+var DefaultPBKDF2Params = PBKDF2Params{Iterations: 600_000}
+
+func (PBKDF2Params) Name() string { return "pbkdf2-hmac-sha256" }
+
+func (p PBKDF2Params) DeriveKey(password, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(password, salt, int(p.Iterations), keySize, sha256.New), nil
+}
+
+func (p PBKDF2Params) validate() error {
+	if p.Iterations < MinPBKDF2Iterations || p.Iterations > MaxPBKDF2Iterations {
+		return fmt.Errorf("invalid pbkdf2 iterations: %d, want %d to %d", p.Iterations, MinPBKDF2Iterations, MaxPBKDF2Iterations)
+	}
+	return nil
+}
+
+func (p PBKDF2Params) marshal() []byte {
+	return binary.BigEndian.AppendUint32([]byte{kdfPBKDF2}, p.Iterations)
+}
+
+// --- Calibration ---
+
+// Calibrate benchmarks Argon2id on this machine and returns parameters whose derivation takes
+// about target. It uses up to maxMemory KiB (at least MinArgon2Memory), halving it while one
+// pass is slower than target, then adds passes up to MaxArgon2Time to fill the remaining time.
+//
+// Calibration runs several derivations and takes a few times target. Decrypting on a slower
+// machine takes correspondingly longer.
+func Calibrate(target time.Duration, maxMemory uint32) (Argon2Params, error) {
+	if target <= 0 {
+		return Argon2Params{}, fmt.Errorf("invalid calibration target: %s", target)
+	}
+	if maxMemory < MinArgon2Memory {
+		return Argon2Params{}, fmt.Errorf("max memory too low: %d KiB, want at least %d", maxMemory, MinArgon2Memory)
+	}
+	p := Argon2Params{
+		Time:    1,
+		Memory:  min(maxMemory, MaxArgon2Memory),
+		Threads: uint8(min(runtime.NumCPU(), maxCalibrateThreads)),
+	}
+
+	password, salt := make([]byte, keySize), make([]byte, saltSize)
+	measure := func() time.Duration {
+		start := time.Now()
+		p.deriveKey(password, salt)
+		return max(time.Since(start), time.Microsecond)
+	}
+
+	elapsed := measure()
+	for elapsed > target && p.Memory/2 >= MinArgon2Memory {
+		p.Memory /= 2
+		elapsed = measure()
+	}
+	if elapsed < target {
+		// The cost grows linearly with the number of passes.
+		p.Time = uint32(min(int64(target/elapsed), MaxArgon2Time))
+	}
+	return p, nil
+}
@@ -0,0 +1,203 @@
+package aesgcm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+var testArgon2Params = Argon2Params{Time: 2, Memory: 8 * 1024, Threads: 1}
+
+func TestArgon2Params_RecordedInHeader(t *testing.T) {
+	params := testArgon2Params
+	plain := bytes.Repeat([]byte("calibrated "), 10000)
+	var buf bytes.Buffer
+	w, err := (&ChunkedGCMCrypter{Password: "pw", KDF: &params}).Encrypt(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	encrypted := buf.Bytes()
+	require.Equal(t, headerPrefixV2, string(encrypted[:len(headerPrefixV2)]))
+	require.Equal(t, flagKDF, encrypted[len(headerPrefixV2)])
+	assert.Equal(t, params.marshal(), encrypted[len(headerPrefixV2)+1:][:1+argon2ParamsSize])
+
+	// The reader takes the parameters from the header.
+	r, err := NewChunkedGCMCrypter("pw").Decrypt(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+
+	// Cheaper parameters in the header change the key, not just the cost.
+	tampered := bytes.Clone(encrypted)
+	tampered[len(headerPrefixV2)+1+4] = 1 // time = 1
+	r, err = NewChunkedGCMCrypter("pw").Decrypt(bytes.NewReader(tampered))
+	if err == nil {
+		_, err = io.ReadAll(r)
+	}
+	require.ErrorContains(t, err, "decryption failed")
+}
+
+func TestArgon2Params_Limits(t *testing.T) {
+	for _, params := range []Argon2Params{
+		{Time: 0, Memory: 8 * 1024, Threads: 1},
+		{Time: MaxArgon2Time + 1, Memory: 8 * 1024, Threads: 1},
+		{Time: 1, Memory: 8 * 1024, Threads: 0},
+		{Time: 1, Memory: 7, Threads: 1},
+		{Time: 1, Memory: MaxArgon2Memory + 1, Threads: 1},
+	} {
+		_, err := (&ChunkedGCMCrypter{Password: "pw", KDF: &params}).Encrypt(io.Discard)
+		require.ErrorContains(t, err, "invalid argon2", "%+v", params)
+	}
+
+	// A crafted header cannot ask the reader for unbounded memory.
+	header := append([]byte(headerPrefixV2), flagKDF)
+	header = append(header, Argon2Params{Time: 1, Memory: 1 << 31, Threads: 1}.marshal()...)
+	header = append(header, make([]byte, saltSize)...)
+	_, err := NewChunkedGCMCrypter("pw").Decrypt(bytes.NewReader(header))
+	require.ErrorContains(t, err, "invalid argon2 memory")
+
+	// The limits stay within what a reader can afford: 1 GiB and 16 passes.
+	for params, want := range map[Argon2Params]string{
+		{Time: 1, Memory: 2 * 1024 * 1024, Threads: 1}: "invalid argon2 memory",
+		{Time: 17, Memory: 8 * 1024, Threads: 1}:       "invalid argon2 time",
+	} {
+		header := append([]byte(headerPrefixV2), flagKDF)
+		header = append(header, params.marshal()...)
+		header = append(header, make([]byte, saltSize)...)
+		_, err := NewChunkedGCMCrypter("pw").Decrypt(bytes.NewReader(header))
+		require.ErrorContains(t, err, want, "%+v", params)
+	}
+	require.NoError(t, Argon2Params{Time: MaxArgon2Time, Memory: MaxArgon2Memory, Threads: 4}.validate())
+}
+
+func TestCalibrate(t *testing.T) {
+	_, err := Calibrate(0, MinArgon2Memory)
+	require.Error(t, err)
+	_, err = Calibrate(time.Second, MinArgon2Memory-1)
+	require.ErrorContains(t, err, "max memory too low")
+
+	params, err := Calibrate(20*time.Millisecond, 16*1024)
+	require.NoError(t, err)
+	require.NoError(t, params.validate())
+	assert.LessOrEqual(t, params.Memory, uint32(16*1024))
+	assert.GreaterOrEqual(t, params.Memory, uint32(MinArgon2Memory))
+	assert.GreaterOrEqual(t, params.Threads, uint8(1))
+	assert.LessOrEqual(t, params.Threads, uint8(maxCalibrateThreads))
+}
+
+func TestKDF_KnownAnswers(t *testing.T) {
+	// RFC 7914, section 12 (the first 32 bytes of the 64-byte output).
+	key, err := ScryptParams{LogN: 10, R: 8, P: 16}.DeriveKey([]byte("password"), []byte("NaCl"))
+	require.NoError(t, err)
+	assert.Equal(t, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162", hex.EncodeToString(key))
+
+	// The RFC 6070 inputs, with HMAC-SHA256 instead of HMAC-SHA1.
+	key, err = PBKDF2Params{Iterations: 4096}.DeriveKey([]byte("password"), []byte("salt"))
+	require.NoError(t, err)
+	assert.Equal(t, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a", hex.EncodeToString(key))
+}
+
+func TestKDF_DecryptPicksTheStreamKDF(t *testing.T) {
+	plain := []byte("exchanged with other tools")
+	for name, kdf := range map[string]KDF{
+		"argon2id": testArgon2Params,
+		"scrypt":   ScryptParams{LogN: 10, R: 8, P: 1},
+		"pbkdf2":   PBKDF2Params{Iterations: MinPBKDF2Iterations},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := (&ChunkedGCMCrypter{Password: "pw", KDF: kdf}).Encrypt(&buf)
+			require.NoError(t, err)
+			_, err = w.Write(plain)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			assert.Equal(t, kdf.marshal(), buf.Bytes()[len(headerPrefixV2)+1:][:len(kdf.marshal())])
+
+			// The reader takes the KDF from the header, whatever its own KDF field says.
+			for _, reader := range []*ChunkedGCMCrypter{{Password: "pw"}, {Password: "pw", KDF: DefaultPBKDF2Params}} {
+				r, err := reader.Decrypt(bytes.NewReader(buf.Bytes()))
+				require.NoError(t, err)
+				got, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.Equal(t, plain, got)
+			}
+		})
+	}
+}
+
+func TestKDF_Limits(t *testing.T) {
+	for _, kdf := range []KDF{
+		ScryptParams{LogN: 0, R: 8, P: 1},
+		ScryptParams{LogN: 10, R: 0, P: 1},
+		ScryptParams{LogN: 10, R: 8, P: MaxScryptP + 1},
+		ScryptParams{LogN: 30, R: 8, P: 1},
+		ScryptParams{LogN: 21, R: 8, P: 1}, // 2 GiB
+		ScryptParams{LogN: 10, R: 1 << 31, P: 1},
+		PBKDF2Params{Iterations: MinPBKDF2Iterations - 1},
+		PBKDF2Params{Iterations: MaxPBKDF2Iterations + 1},
+	} {
+		_, err := (&ChunkedGCMCrypter{Password: "pw", KDF: kdf}).Encrypt(io.Discard)
+		require.ErrorContains(t, err, "invalid", "%+v", kdf)
+	}
+
+	require.NoError(t, ScryptParams{LogN: 19, R: 8, P: 1}.validate()) // 512 MiB
+	require.Error(t, ScryptParams{LogN: 20, R: 8, P: 1}.validate())   // 1 GiB and the buffers
+
+	// B takes 128 * r * p bytes whatever N is: this header would cost 8 GiB.
+	header := append([]byte(headerPrefixV2), flagKDF)
+	header = append(header, ScryptParams{LogN: 1, R: 1 << 22, P: 16}.marshal()...)
+	header = append(header, make([]byte, saltSize)...)
+	_, err := NewChunkedGCMCrypter("pw").Decrypt(bytes.NewReader(header))
+	require.ErrorContains(t, err, "invalid scrypt parameters")
+
+	header = append([]byte(headerPrefixV2), flagKDF, 0x7f)
+	_, err = NewChunkedGCMCrypter("pw").Decrypt(bytes.NewReader(header))
+	require.ErrorContains(t, err, "unsupported kdf")
+}
+
+func TestKDF_Policy(t *testing.T) {
+	t.Cleanup(func() { policy.SetDefault(nil) })
+	plain := []byte("approved primitives only")
+	encrypt := func(c *ChunkedGCMCrypter) ([]byte, error) {
+		var buf bytes.Buffer
+		w, err := c.Encrypt(&buf)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(plain); err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		return buf.Bytes(), err
+	}
+	argon2Stream, err := encrypt(&ChunkedGCMCrypter{Password: "pw", KDF: testArgon2Params})
+	require.NoError(t, err)
+
+	policy.SetDefault(policy.FIPS())
+	_, err = encrypt(NewChunkedGCMCrypter("pw").(*ChunkedGCMCrypter))
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+	require.ErrorContains(t, err, "kdf argon2id")
+
+	fips := &ChunkedGCMCrypter{Password: "pw", KDF: PBKDF2Params{Iterations: MinPBKDF2Iterations}}
+	pbkdf2Stream, err := encrypt(fips)
+	require.NoError(t, err)
+	r, err := fips.Decrypt(bytes.NewReader(pbkdf2Stream))
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+
+	// The KDF recorded in the stream is checked, whatever the reader is configured with.
+	_, err = fips.Decrypt(bytes.NewReader(argon2Stream))
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+}
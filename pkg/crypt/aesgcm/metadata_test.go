@@ -0,0 +1,115 @@
+package aesgcm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+func encryptWithMetadata(t *testing.T, c *ChunkedGCMCrypter, plain []byte, md *crypt.Metadata) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := c.EncryptWithMetadata(&buf, md)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func testMetadata() *crypt.Metadata {
+	return &crypt.Metadata{
+		Name:        "base/16384/2619",
+		ModTime:     time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC),
+		Mode:        0o640,
+		ContentType: "application/octet-stream",
+		Extra:       map[string]string{"backup-id": "20250301T123000"},
+	}
+}
+
+func TestChunkedGCMCrypto_Metadata_Roundtrip(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "metadata"}
+	plain := bytes.Repeat([]byte("payload"), 20000)
+	md := testMetadata()
+	encrypted := encryptWithMetadata(t, c, plain, md)
+	assert.True(t, bytes.HasPrefix(encrypted, []byte(headerPrefixV2)))
+
+	got, err := c.ReadMetadata(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	assert.Equal(t, md, got)
+
+	r, got, err := c.DecryptWithMetadata(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	assert.Equal(t, md, got)
+	decrypted, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+
+	// Plain Decrypt skips the metadata record.
+	r, err = c.Decrypt(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	decrypted, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+}
+
+func TestChunkedGCMCrypto_Metadata_ReadsOnlyHeaderAndFirstRecord(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "metadata"}
+	encrypted := encryptWithMetadata(t, c, bytes.Repeat([]byte{1}, 3*chunkSize), testMetadata())
+
+	r := bytes.NewReader(encrypted)
+	_, err := c.ReadMetadata(r)
+	require.NoError(t, err)
+	consumed := len(encrypted) - r.Len()
+	assert.Less(t, consumed, 1024, "ReadMetadata must not read past the metadata record")
+}
+
+func TestChunkedGCMCrypto_Metadata_Absent(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "metadata"}
+	encrypted := encryptWithMetadata(t, c, []byte("no metadata"), nil)
+	assert.True(t, bytes.HasPrefix(encrypted, []byte(headerPrefix)), "streams without metadata keep the v1 format")
+
+	md, err := c.ReadMetadata(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	assert.Nil(t, md)
+}
+
+func TestChunkedGCMCrypto_Metadata_Tampering(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "metadata"}
+	encrypted := encryptWithMetadata(t, c, []byte("data"), testMetadata())
+	headerLen := len(headerPrefixV2) + 1 + saltSize
+
+	tampered := bytes.Clone(encrypted)
+	tampered[headerLen+10] ^= 1
+	_, err := c.ReadMetadata(bytes.NewReader(tampered))
+	require.ErrorContains(t, err, "metadata decryption failed")
+
+	_, err = (&ChunkedGCMCrypter{Password: "wrong"}).ReadMetadata(bytes.NewReader(encrypted))
+	require.ErrorContains(t, err, "metadata decryption failed")
+
+	// A v2 header without flags is never written, and unknown flags are rejected.
+	stripped := bytes.Clone(encrypted)
+	stripped[len(headerPrefixV2)] = 0
+	_, err = c.Decrypt(bytes.NewReader(stripped))
+	require.ErrorContains(t, err, "unsupported header flags")
+
+	unknown := bytes.Clone(encrypted)
+	unknown[len(headerPrefixV2)] |= 0x80
+	_, err = c.Decrypt(bytes.NewReader(unknown))
+	require.ErrorContains(t, err, "unsupported header flags")
+}
+
+func TestChunkedGCMCrypto_Metadata_TooLarge(t *testing.T) {
+	md := &crypt.Metadata{Name: strings.Repeat("a", maxMetadataSize-20)}
+	sealed := len(`{"name":""}`) + maxMetadataSize - 20 + 16
+	_, err := (&ChunkedGCMCrypter{Password: "metadata"}).EncryptWithMetadata(io.Discard, md)
+	require.ErrorContains(t, err, fmt.Sprintf("the sealed record is %d bytes, the limit is %d", sealed, maxMetadataSize))
+}
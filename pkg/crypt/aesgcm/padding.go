@@ -0,0 +1,142 @@
+package aesgcm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// --- Length-Hiding Padding ---
+//
+// Padding is 0x80 followed by zero bytes (ISO/IEC 7816-4), appended to the plaintext before the
+// final chunk is sealed, so it is authenticated like the data. The reader holds back only a trailing
+// 0x80 and a count of the zeros after it, so stripping needs constant memory.
+
+const paddingMarker = 0x80
+
+type padWriter struct {
+	w      io.WriteCloser
+	policy crypt.Padding
+	length int64
+	closed bool
+}
+
+func newPadWriter(w io.WriteCloser, policy crypt.Padding) *padWriter {
+	return &padWriter{w: w, policy: policy}
+}
+
+func (p *padWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.length += int64(n)
+	return n, err
+}
+
+func (p *padWriter) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	total := p.length + 1
+	padded := p.policy.PaddedLength(total)
+	if padded < total {
+		return fmt.Errorf("invalid padding: policy pads %d bytes to %d", total, padded)
+	}
+	zeros := padded - total
+	if _, err := p.w.Write([]byte{paddingMarker}); err != nil {
+		return err
+	}
+	block := make([]byte, min(zeros, chunkSize))
+	for zeros > 0 {
+		n := min(zeros, int64(len(block)))
+		if _, err := p.w.Write(block[:n]); err != nil {
+			return err
+		}
+		zeros -= n
+	}
+	return p.w.Close()
+}
+
+type padReader struct {
+	r   io.Reader
+	buf []byte
+	out []byte
+	eof bool
+
+	// held is a trailing 0x80 followed by heldZeros zeros, which may be the padding.
+	held      bool
+	heldZeros int64
+	// When more data follows, the held bytes are returned before out.
+	releaseMarker bool
+	releaseZeros  int64
+}
+
+func newPadReader(r io.Reader) *padReader {
+	return &padReader{r: r, buf: make([]byte, chunkSize)}
+}
+
+func (p *padReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for {
+		switch {
+		case p.releaseMarker:
+			b[0] = paddingMarker
+			p.releaseMarker = false
+			return 1, nil
+		case p.releaseZeros > 0:
+			n := int(min(int64(len(b)), p.releaseZeros))
+			clear(b[:n])
+			p.releaseZeros -= int64(n)
+			return n, nil
+		case len(p.out) > 0:
+			n := copy(b, p.out)
+			p.out = p.out[n:]
+			return n, nil
+		case p.eof:
+			if !p.held {
+				return 0, errors.New("invalid padding")
+			}
+			return 0, io.EOF
+		}
+
+		n, err := p.r.Read(p.buf)
+		if n > 0 {
+			p.process(p.buf[:n])
+		}
+		if errors.Is(err, io.EOF) {
+			p.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (p *padReader) process(b []byte) {
+	i := len(b) - 1
+	for i >= 0 && b[i] == 0 {
+		i--
+	}
+	if i < 0 {
+		if p.held {
+			p.heldZeros += int64(len(b))
+		} else {
+			p.out = b
+		}
+		return
+	}
+
+	if p.held {
+		p.releaseMarker, p.releaseZeros = true, p.heldZeros
+		p.held, p.heldZeros = false, 0
+	}
+	if b[i] == paddingMarker {
+		p.out = b[:i]
+		p.held, p.heldZeros = true, int64(len(b)-i-1)
+	} else {
+		p.out = b
+	}
+}
@@ -0,0 +1,86 @@
+package aesgcm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+func roundtrip(t *testing.T, c *ChunkedGCMCrypter, plain []byte) []byte {
+	t.Helper()
+	encrypted := encryptWithMetadata(t, c, plain, nil)
+	r, err := c.Decrypt(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+	return encrypted
+}
+
+func TestChunkedGCMCrypto_Padding_Roundtrip(t *testing.T) {
+	inputs := [][]byte{
+		{},
+		{0},
+		{paddingMarker},
+		{paddingMarker, 0, 0},
+		append(bytes.Repeat([]byte{paddingMarker, 0}, 5), 0),
+		append(append(bytes.Repeat([]byte{7}, chunkSize-1), paddingMarker), make([]byte, 2*chunkSize)...),
+		make([]byte, 3*chunkSize+1),
+	}
+	policies := []crypt.Padding{crypt.PadmePadding{}, crypt.BucketPadding{Size: 4096}, crypt.BucketPadding{Size: chunkSize + 5}}
+	for _, policy := range policies {
+		for _, plain := range inputs {
+			roundtrip(t, &ChunkedGCMCrypter{Password: "pad", Padding: policy}, plain)
+			roundtrip(t, &ChunkedGCMCrypter{Password: "pad", Padding: policy, Digest: crypt.DigestSHA256}, plain)
+		}
+	}
+}
+
+func TestChunkedGCMCrypto_Padding_HidesLength(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "pad", Padding: crypt.BucketPadding{Size: 4096}}
+	sizes := map[int]bool{}
+	for _, n := range []int{0, 1, 100, 2000, 4095} {
+		sizes[len(roundtrip(t, c, bytes.Repeat([]byte{1}, n)))] = true
+	}
+	assert.Len(t, sizes, 1, "all inputs below the bucket size must encrypt to the same length")
+
+	c.Padding = crypt.PadmePadding{}
+	a := roundtrip(t, c, bytes.Repeat([]byte{1}, 1000000))
+	b := roundtrip(t, c, bytes.Repeat([]byte{1}, 1001000))
+	assert.Equal(t, len(a), len(b))
+}
+
+// shrinkingPadding is a broken policy that returns less than its input.
+type shrinkingPadding struct{}
+
+func (shrinkingPadding) PaddedLength(n int64) int64 { return n - 1 }
+
+func TestChunkedGCMCrypto_Padding_InvalidPolicy(t *testing.T) {
+	w, err := (&ChunkedGCMCrypter{Password: "pad", Padding: shrinkingPadding{}}).Encrypt(io.Discard)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NotPanics(t, func() {
+		require.ErrorContains(t, w.Close(), "invalid padding")
+	})
+}
+
+func TestPadReader_SmallReads(t *testing.T) {
+	padded := append([]byte{1, paddingMarker, 0, 0, 2, 0}, paddingMarker, 0, 0, 0)
+	got, err := io.ReadAll(iotest.OneByteReader(newPadReader(iotest.OneByteReader(bytes.NewReader(padded)))))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, paddingMarker, 0, 0, 2, 0}, got)
+}
+
+func TestPadReader_InvalidPadding(t *testing.T) {
+	for _, padded := range [][]byte{nil, {1, 2, 3}, {paddingMarker, 0, 1}} {
+		_, err := io.ReadAll(newPadReader(bytes.NewReader(padded)))
+		require.ErrorContains(t, err, "invalid padding")
+	}
+}
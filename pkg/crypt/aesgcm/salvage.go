@@ -0,0 +1,65 @@
+package aesgcm
+
+import (
+	"errors"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+)
+
+// --- Salvage ---
+
+// DecryptSalvage decrypts as much of a damaged stream as can be authenticated, skipping
+// corrupted chunks instead of failing. The header must be intact. Damaged ranges are
+// plaintext offsets; the returned reader's Report is complete once it returns io.EOF.
+//
+// Recovered data is authentic chunk by chunk, but the stream as a whole is not: use it
+// only to rescue what is left, never in place of Decrypt. Flushable streams are not supported.
+func (c *ChunkedGCMCrypter) DecryptSalvage(r io.Reader, opts crypt.SalvageOptions) (crypt.SalvageReader, error) {
+	s, err := c.readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if s.flags&flagFlush != 0 {
+		// Lost plaintext ranges cannot be told from a damaged length prefix.
+		return nil, errors.New("salvage is not supported for flushable streams")
+	}
+	if s.flags&flagMetadata != 0 {
+		// A damaged metadata record is skipped; its length prefix tells how far.
+		if _, err := s.readMetadata(r); err != nil && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+			return nil, err
+		}
+	}
+
+	chunks := chunked.NewSalvageReader(r, s.aead, s.flags != 0, opts)
+	var dec io.Reader = chunks
+	if s.flags&flagPadding != 0 {
+		dec = newPadReader(dec)
+	}
+	if s.flags&flagTrailer != 0 {
+		if dec, err = newDigestReader(dec, s.digest); err != nil {
+			return nil, err
+		}
+	}
+	return &salvageReader{r: dec, chunks: chunks}, nil
+}
+
+type salvageReader struct {
+	r      io.Reader
+	chunks *chunked.SalvageReader
+}
+
+// Read passes data through. Once the chunks are exhausted, padding and digest errors are
+// expected consequences of the reported damage and end the stream instead.
+func (s *salvageReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) && s.chunks.Done() && !s.chunks.Report().OK() {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func (s *salvageReader) Report() *crypt.DamageReport {
+	return s.chunks.Report()
+}
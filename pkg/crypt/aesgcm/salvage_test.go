@@ -0,0 +1,83 @@
+package aesgcm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+)
+
+const frameSize = chunked.NonceSize + chunkSize + 16
+
+func salvageAll(t *testing.T, c *ChunkedGCMCrypter, encrypted []byte, zeroFill bool) ([]byte, *crypt.DamageReport) {
+	t.Helper()
+	r, err := c.DecryptSalvage(bytes.NewReader(encrypted), crypt.SalvageOptions{ZeroFill: zeroFill})
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return got, r.Report()
+}
+
+func TestChunkedGCMCrypto_Salvage_V1(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "salvage"}
+	plain := bytes.Repeat([]byte("0123456789abcdef"), 3*chunkSize/16+100)
+	encrypted := encryptWithMetadata(t, c, plain, nil)
+	headerLen := len(headerPrefix) + saltSize
+	encrypted[headerLen+frameSize+1000] ^= 1
+
+	_, err := io.ReadAll(mustDecrypt(t, c, encrypted))
+	require.Error(t, err, "regular decryption must still fail")
+
+	got, report := salvageAll(t, c, encrypted, true)
+	want := bytes.Clone(plain)
+	clear(want[chunkSize : 2*chunkSize])
+	assert.True(t, bytes.Equal(want, got))
+	assert.Equal(t, []crypt.ByteRange{{Offset: chunkSize, Length: chunkSize}}, report.Damaged)
+	assert.False(t, report.Truncated)
+}
+
+func TestChunkedGCMCrypto_Salvage_V2WithEverything(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "salvage", Digest: crypt.DigestSHA256, Padding: crypt.BucketPadding{Size: 4096}}
+	plain := bytes.Repeat([]byte{0x5a}, 4*chunkSize+123)
+	encrypted := encryptWithMetadata(t, c, plain, testMetadata())
+
+	got, report := salvageAll(t, c, encrypted, false)
+	assert.True(t, bytes.Equal(plain, got))
+	assert.True(t, report.OK())
+
+	// Damage the metadata record and chunk 2.
+	headerLen := len(headerPrefixV2) + 2 + saltSize
+	metadataLen := 4 + int(binary.BigEndian.Uint32(encrypted[headerLen:]))
+	encrypted[headerLen+10] ^= 1
+	encrypted[headerLen+metadataLen+2*frameSize+50] ^= 1
+
+	got, report = salvageAll(t, c, encrypted, false)
+	assert.True(t, bytes.Equal(append(bytes.Clone(plain[:2*chunkSize]), plain[3*chunkSize:]...), got))
+	assert.Equal(t, []crypt.ByteRange{{Offset: 2 * chunkSize, Length: chunkSize}}, report.Damaged)
+	assert.False(t, report.Truncated)
+}
+
+func TestChunkedGCMCrypto_Salvage_Truncated(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "salvage", Digest: crypt.DigestBLAKE2b256}
+	plain := bytes.Repeat([]byte{1}, 3*chunkSize)
+	encrypted := encryptWithMetadata(t, c, plain, nil)
+	headerLen := len(headerPrefixV2) + 2 + saltSize
+
+	got, report := salvageAll(t, c, encrypted[:headerLen+2*frameSize], false)
+	assert.LessOrEqual(t, len(got), 2*chunkSize)
+	assert.True(t, bytes.Equal(plain[:len(got)], got))
+	assert.True(t, report.Truncated)
+}
+
+func mustDecrypt(t *testing.T, c *ChunkedGCMCrypter, encrypted []byte) io.Reader {
+	t.Helper()
+	r, err := c.Decrypt(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	return r
+}
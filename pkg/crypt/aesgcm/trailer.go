@@ -0,0 +1,145 @@
+package aesgcm
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// --- Digest Trailer ---
+//
+// The trailer is length(8) || digest, appended to the plaintext before the final chunk is sealed.
+// The reader holds back the trailer-sized tail of the plaintext until the final chunk is reached.
+
+const lengthSize = 8
+
+var ErrDigestMismatch = errors.New("plaintext digest mismatch: tampering or corruption detected")
+
+func trailerSize(h hash.Hash) int {
+	return lengthSize + h.Size()
+}
+
+type digestWriter struct {
+	w      io.WriteCloser
+	alg    crypt.DigestAlgorithm
+	hash   hash.Hash
+	length int64
+	digest *crypt.Digest
+}
+
+var _ crypt.DigestWriter = &digestWriter{}
+
+func newDigestWriter(w io.WriteCloser, alg crypt.DigestAlgorithm) (*digestWriter, error) {
+	h, err := alg.New()
+	if err != nil {
+		return nil, err
+	}
+	return &digestWriter{w: w, alg: alg, hash: h}, nil
+}
+
+func (d *digestWriter) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	d.hash.Write(p[:n])
+	d.length += int64(n)
+	return n, err
+}
+
+func (d *digestWriter) Close() error {
+	if d.digest != nil {
+		return nil
+	}
+	digest := &crypt.Digest{Algorithm: d.alg, Sum: d.hash.Sum(nil), Length: d.length}
+	trailer := binary.BigEndian.AppendUint64(nil, uint64(digest.Length))
+	trailer = append(trailer, digest.Sum...)
+	if _, err := d.w.Write(trailer); err != nil {
+		return err
+	}
+	if err := d.w.Close(); err != nil {
+		return err
+	}
+	d.digest = digest
+	return nil
+}
+
+func (d *digestWriter) Digest() (*crypt.Digest, error) {
+	if d.digest == nil {
+		return nil, errors.New("digest is available after Close")
+	}
+	return d.digest, nil
+}
+
+type digestReader struct {
+	r      io.Reader
+	alg    crypt.DigestAlgorithm
+	hash   hash.Hash
+	length int64
+	buf    []byte // unread bytes are buf[off:]
+	off    int
+	eof    bool
+	digest *crypt.Digest
+}
+
+var _ crypt.DigestReader = &digestReader{}
+
+func newDigestReader(r io.Reader, alg crypt.DigestAlgorithm) (*digestReader, error) {
+	h, err := alg.New()
+	if err != nil {
+		return nil, err
+	}
+	return &digestReader{r: r, alg: alg, hash: h, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	tail := trailerSize(d.hash)
+	for len(d.buf)-d.off <= tail {
+		// Compact only when refilling, and then at most the trailer is left to move.
+		d.buf = d.buf[:copy(d.buf, d.buf[d.off:])]
+		d.off = 0
+		if d.eof {
+			return 0, d.finish()
+		}
+		if len(d.buf) == cap(d.buf) {
+			d.buf = append(d.buf, make([]byte, chunkSize)...)[:len(d.buf)]
+		}
+		n, err := d.r.Read(d.buf[len(d.buf):cap(d.buf)])
+		d.buf = d.buf[:len(d.buf)+n]
+		if errors.Is(err, io.EOF) {
+			d.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.buf[d.off:len(d.buf)-tail])
+	d.hash.Write(p[:n])
+	d.length += int64(n)
+	d.off += n
+	return n, nil
+}
+
+func (d *digestReader) finish() error {
+	if d.digest != nil {
+		return io.EOF
+	}
+	if len(d.buf) != trailerSize(d.hash) {
+		return errors.New("missing digest trailer")
+	}
+	length := binary.BigEndian.Uint64(d.buf[:lengthSize])
+	sum := d.hash.Sum(nil)
+	if length != uint64(d.length) || subtle.ConstantTimeCompare(sum, d.buf[lengthSize:]) != 1 {
+		return ErrDigestMismatch
+	}
+	d.digest = &crypt.Digest{Algorithm: d.alg, Sum: sum, Length: d.length}
+	return io.EOF
+}
+
+func (d *digestReader) Digest() (*crypt.Digest, error) {
+	if d.digest == nil {
+		return nil, errors.New("digest is available after reading to EOF")
+	}
+	return d.digest, nil
+}
@@ -0,0 +1,136 @@
+package aesgcm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+)
+
+func TestChunkedGCMCrypto_DigestTrailer(t *testing.T) {
+	sizes := []int{0, 1, lengthSize + sha256.Size, chunkSize - 1, chunkSize, 3*chunkSize + 123}
+	for _, alg := range []crypt.DigestAlgorithm{crypt.DigestSHA256, crypt.DigestBLAKE2b256} {
+		for _, size := range sizes {
+			c := &ChunkedGCMCrypter{Password: "digest", Digest: alg}
+			plain := make([]byte, size)
+			for i := range plain {
+				plain[i] = byte(i * 7)
+			}
+			want := sha256.Sum256(plain)
+			if alg == crypt.DigestBLAKE2b256 {
+				want = blake2b.Sum256(plain)
+			}
+
+			var buf bytes.Buffer
+			w, err := c.Encrypt(&buf)
+			require.NoError(t, err)
+			_, err = w.Write(plain)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			written, err := w.(crypt.DigestWriter).Digest()
+			require.NoError(t, err)
+			assert.Equal(t, want[:], written.Sum)
+			assert.Equal(t, int64(size), written.Length)
+
+			r, err := c.Decrypt(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+			dr := r.(crypt.DigestReader)
+			_, err = dr.Digest()
+			require.Error(t, err, "digest must not be available before EOF")
+
+			got, err := io.ReadAll(r)
+			require.NoError(t, err, "%s size %d", alg, size)
+			assert.Equal(t, plain, got)
+			verified, err := dr.Digest()
+			require.NoError(t, err)
+			assert.Equal(t, written, verified)
+		}
+	}
+}
+
+func TestChunkedGCMCrypto_DigestTrailer_WithMetadata(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "digest", Digest: crypt.DigestSHA256}
+	encrypted := encryptWithMetadata(t, c, []byte("both"), testMetadata())
+
+	md, err := c.ReadMetadata(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	assert.Equal(t, testMetadata(), md)
+
+	r, err := c.Decrypt(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("both"), got)
+}
+
+func TestChunkedGCMCrypto_DigestTrailer_Truncation(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "digest", Digest: crypt.DigestSHA256}
+	encrypted := encryptWithMetadata(t, c, bytes.Repeat([]byte{9}, 2*chunkSize+10), nil)
+	frame := chunked.NonceSize + chunkSize + 16
+
+	// Cut right after the first full chunk: the missing final chunk must be noticed.
+	headerLen := len(headerPrefixV2) + 2 + saltSize
+	r, err := c.Decrypt(bytes.NewReader(encrypted[:headerLen+frame]))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, chunked.ErrTruncated)
+
+	r, err = c.Decrypt(bytes.NewReader(append(bytes.Clone(encrypted), 0)))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.Error(t, err)
+}
+
+func TestDigestReader_Mismatch(t *testing.T) {
+	plain := []byte("data whose trailer lies")
+	var buf bytes.Buffer
+	buf.Write(plain)
+	buf.Write([]byte{0, 0, 0, 0, 0, 0, 0, byte(len(plain))})
+	buf.Write(make([]byte, sha256.Size))
+
+	r, err := newDigestReader(&buf, crypt.DigestSHA256)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, ErrDigestMismatch)
+
+	r, err = newDigestReader(bytes.NewReader([]byte("short")), crypt.DigestSHA256)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.ErrorContains(t, err, "missing digest trailer")
+}
+
+func TestDigestReader_SmallReads(t *testing.T) {
+	plain := bytes.Repeat([]byte("small reads "), chunkSize/4)
+	sum := sha256.Sum256(plain)
+	var buf bytes.Buffer
+	buf.Write(plain)
+	buf.Write(binary.BigEndian.AppendUint64(nil, uint64(len(plain))))
+	buf.Write(sum[:])
+
+	for _, src := range []io.Reader{bytes.NewReader(buf.Bytes()), iotest.OneByteReader(bytes.NewReader(buf.Bytes()))} {
+		r, err := newDigestReader(src, crypt.DigestSHA256)
+		require.NoError(t, err)
+		got, err := io.ReadAll(iotest.OneByteReader(r))
+		require.NoError(t, err)
+		assert.Equal(t, plain, got)
+		digest, err := r.Digest()
+		require.NoError(t, err)
+		assert.Equal(t, sum[:], digest.Sum)
+	}
+}
+
+func TestChunkedGCMCrypto_UnsupportedDigest(t *testing.T) {
+	c := &ChunkedGCMCrypter{Password: "digest", Digest: 99}
+	_, err := c.Encrypt(io.Discard)
+	require.ErrorContains(t, err, "unsupported digest algorithm")
+}
@@ -0,0 +1,136 @@
+package aesgcmsiv
+
+import (
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- Constants ---
+
+const (
+	saltSize     = 16
+	keySize      = 32 // AES-256-GCM-SIV requires a 256-bit key = 32 bytes.
+	headerPrefix = "SIVv2\x00"
+	hkdfInfo     = "streamcrypt aes-256-gcm-siv stream key"
+)
+
+// --- Chunked GCM-SIV Crypter ---
+
+// ChunkedGCMSIVCrypter encrypts with AES-256-GCM-SIV using the chunk framing of aesgcm v2 streams:
+// chunks carry their counter and the last one is marked final, so reordering and truncation are detected.
+//
+// It takes a raw 32-byte key instead of a password. Every stream gets a random salt, and the
+// stream key is derived from Key and salt with HKDF-SHA256. Because GCM-SIV is nonce-misuse
+// resistant, a repeated salt (and thus repeated nonces) only reveals whether two chunks at the
+// same position are identical, instead of breaking confidentiality and authenticity as it would with GCM.
+type ChunkedGCMSIVCrypter struct {
+	Key []byte
+
+	// SecretKey, when set, is used instead of Key.
+	SecretKey *crypt.SecretKey
+}
+
+var (
+	_ crypt.Crypter    = &ChunkedGCMSIVCrypter{}
+	_ crypt.Destroyer  = &ChunkedGCMSIVCrypter{}
+	_ policy.Describer = &ChunkedGCMSIVCrypter{}
+)
+
+// NewChunkedGCMSIVCrypter copies key into a crypt.SecretKey, so the caller can wipe key afterwards.
+func NewChunkedGCMSIVCrypter(key []byte) crypt.Crypter {
+	return &ChunkedGCMSIVCrypter{
+		SecretKey: crypt.NewSecretKey(key),
+	}
+}
+
+func (c *ChunkedGCMSIVCrypter) Destroy() {
+	if c.SecretKey == nil {
+		c.SecretKey = &crypt.SecretKey{}
+	}
+	c.SecretKey.Destroy()
+	clear(c.Key)
+	c.Key = nil
+}
+
+func (c *ChunkedGCMSIVCrypter) FileExtension() string {
+	return ".siv"
+}
+
+func (c *ChunkedGCMSIVCrypter) Name() string {
+	return "aes-256-gcm-siv"
+}
+
+func (c *ChunkedGCMSIVCrypter) Algorithms() []policy.Algorithm {
+	return []policy.Algorithm{
+		{Kind: policy.Cipher, Name: "aes-256-gcm-siv", KeyBits: keySize * 8},
+		{Kind: policy.KDF, Name: "hkdf-sha256"},
+	}
+}
+
+func (c *ChunkedGCMSIVCrypter) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := c.newAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write([]byte(headerPrefix)); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+
+	return chunked.NewFinalWriter(w, aead), nil
+}
+
+func (c *ChunkedGCMSIVCrypter) Decrypt(r io.Reader) (io.Reader, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(headerPrefix)+saltSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(headerPrefix)]) != headerPrefix {
+		return nil, errors.New("invalid file header")
+	}
+	aead, err := c.newAEAD(header[len(headerPrefix):])
+	if err != nil {
+		return nil, err
+	}
+
+	return chunked.NewFinalReader(r, aead), nil
+}
+
+func (c *ChunkedGCMSIVCrypter) newAEAD(salt []byte) (cipher.AEAD, error) {
+	var aead cipher.AEAD
+	err := crypt.UseKey(c.SecretKey, c.Key, func(mainKey []byte) error {
+		if len(mainKey) != keySize {
+			return fmt.Errorf("invalid key size: got %d bytes, want %d", len(mainKey), keySize)
+		}
+		key, err := hkdf.Key(sha256.New, mainKey, salt, hkdfInfo, keySize)
+		if err != nil {
+			return err
+		}
+		defer clear(key)
+		aead, err = NewGCMSIV(key)
+		return err
+	})
+	return aead, err
+}
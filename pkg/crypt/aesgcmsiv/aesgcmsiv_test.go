@@ -0,0 +1,137 @@
+package aesgcmsiv
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, keySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func encryptAll(t *testing.T, crypter *ChunkedGCMSIVCrypter, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := crypter.Encrypt(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestChunkedGCMSIVCrypto_EncryptDecryptRoundtrip(t *testing.T) {
+	crypter := &ChunkedGCMSIVCrypter{Key: newTestKey(t)}
+
+	for _, size := range []int{0, 1, chunked.ChunkSize - 1, chunked.ChunkSize, 3*chunked.ChunkSize + 17} {
+		data := make([]byte, size)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+
+		encrypted := encryptAll(t, crypter, data)
+		assert.True(t, bytes.HasPrefix(encrypted, []byte(headerPrefix)))
+
+		r, err := crypter.Decrypt(bytes.NewReader(encrypted))
+		require.NoError(t, err)
+		result, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, data, result, "size=%d", size)
+	}
+}
+
+func TestChunkedGCMSIVCrypto_WrongKey(t *testing.T) {
+	encrypted := encryptAll(t, &ChunkedGCMSIVCrypter{Key: newTestKey(t)}, []byte("secret payload"))
+
+	r, err := NewChunkedGCMSIVCrypter(newTestKey(t)).Decrypt(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.ErrorContains(t, err, "decryption failed")
+}
+
+func TestChunkedGCMSIVCrypto_DecryptCorrupted(t *testing.T) {
+	crypter := NewChunkedGCMSIVCrypter(newTestKey(t))
+	var buf bytes.Buffer
+	w, err := crypter.Encrypt(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(bytes.Repeat([]byte("x"), 10000))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	encrypted := buf.Bytes()
+	encrypted[len(encrypted)-10] ^= 0xFF
+
+	r, err := crypter.Decrypt(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.ErrorContains(t, err, "decryption failed")
+}
+
+func TestChunkedGCMSIVCrypto_DetectsReorderingAndTruncation(t *testing.T) {
+	crypter := NewChunkedGCMSIVCrypter(newTestKey(t))
+	encrypted := encryptAll(t, crypter.(*ChunkedGCMSIVCrypter), bytes.Repeat([]byte{7}, 3*chunked.ChunkSize+100))
+	headerSize := len(headerPrefix) + saltSize
+	frame := chunked.NonceSize + chunked.ChunkSize + 16
+	decrypt := func(data []byte) error {
+		r, err := crypter.Decrypt(bytes.NewReader(data))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		return err
+	}
+
+	body := encrypted[headerSize:]
+	swapped := append(bytes.Clone(encrypted[:headerSize]), body[frame:2*frame]...)
+	swapped = append(swapped, body[:frame]...)
+	swapped = append(swapped, body[2*frame:]...)
+	require.Error(t, decrypt(swapped))
+
+	require.ErrorIs(t, decrypt(encrypted[:headerSize+3*frame]), chunked.ErrTruncated)
+	require.ErrorIs(t, decrypt(encrypted[:headerSize]), chunked.ErrTruncated)
+}
+
+func TestChunkedGCMSIVCrypto_InvalidHeader(t *testing.T) {
+	crypter := NewChunkedGCMSIVCrypter(newTestKey(t))
+	data := append([]byte("AEADv1"), make([]byte, 100)...) // aesgcm stream, not GCM-SIV
+	r, err := crypter.Decrypt(bytes.NewReader(data))
+	assert.Nil(t, r)
+	require.ErrorContains(t, err, "invalid file header")
+}
+
+func TestChunkedGCMSIVCrypto_InvalidKeySize(t *testing.T) {
+	crypter := NewChunkedGCMSIVCrypter([]byte("too-short"))
+	_, err := crypter.Encrypt(io.Discard)
+	require.ErrorContains(t, err, "invalid key size")
+}
+
+func TestChunkedGCMSIVCrypto_Destroy(t *testing.T) {
+	key := newTestKey(t)
+	crypter := NewChunkedGCMSIVCrypter(key).(*ChunkedGCMSIVCrypter)
+	clear(key) // the constructor keeps its own copy
+
+	encrypted := encryptAll(t, crypter, []byte("secret payload"))
+	crypter.Destroy()
+	_, err := crypter.Decrypt(bytes.NewReader(encrypted))
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+
+	withKey := &ChunkedGCMSIVCrypter{Key: newTestKey(t)}
+	withKey.Destroy()
+	_, err = withKey.Encrypt(io.Discard)
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+}
+
+func TestChunkedGCMSIVCrypto_Metadata(t *testing.T) {
+	crypter := NewChunkedGCMSIVCrypter(newTestKey(t))
+	assert.Equal(t, ".siv", crypter.FileExtension())
+	assert.Equal(t, "aes-256-gcm-siv", crypter.Name())
+}
@@ -0,0 +1,157 @@
+package aesgcmsiv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// --- AES-GCM-SIV AEAD (RFC 8452) ---
+
+const (
+	sivNonceSize = 12
+	sivTagSize   = 16
+	maxPlaintext = 1 << 36 // RFC 8452: plaintexts are limited to 2^36 bytes.
+)
+
+var errOpen = errors.New("aesgcmsiv: message authentication failed")
+
+type gcmSIV struct {
+	keyGen cipher.Block // key-generating key, used only to derive per-nonce keys
+	keyLen int
+}
+
+var _ cipher.AEAD = &gcmSIV{}
+
+// NewGCMSIV returns AEAD_AES_128_GCM_SIV or AEAD_AES_256_GCM_SIV depending on the length of key.
+func NewGCMSIV(key []byte) (cipher.AEAD, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, fmt.Errorf("aesgcmsiv: invalid key size %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &gcmSIV{keyGen: block, keyLen: len(key)}, nil
+}
+
+func (g *gcmSIV) NonceSize() int {
+	return sivNonceSize
+}
+
+func (g *gcmSIV) Overhead() int {
+	return sivTagSize
+}
+
+func (g *gcmSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != sivNonceSize {
+		panic("aesgcmsiv: incorrect nonce length given to GCM-SIV")
+	}
+	if uint64(len(plaintext)) > maxPlaintext || uint64(len(additionalData)) > maxPlaintext {
+		panic("aesgcmsiv: message too large for GCM-SIV")
+	}
+
+	authKey, encBlock := g.deriveKeys(nonce)
+	var tag [sivTagSize]byte
+	computeTag(tag[:], authKey, encBlock, nonce, plaintext, additionalData)
+
+	ret, out := sliceForAppend(dst, len(plaintext)+sivTagSize)
+	ctr(encBlock, tag[:], out[:len(plaintext)], plaintext)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+func (g *gcmSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != sivNonceSize {
+		panic("aesgcmsiv: incorrect nonce length given to GCM-SIV")
+	}
+	if len(ciphertext) < sivTagSize || uint64(len(ciphertext)) > maxPlaintext+sivTagSize {
+		return nil, errOpen
+	}
+
+	var tag [sivTagSize]byte
+	copy(tag[:], ciphertext[len(ciphertext)-sivTagSize:])
+	ciphertext = ciphertext[:len(ciphertext)-sivTagSize]
+
+	authKey, encBlock := g.deriveKeys(nonce)
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	ctr(encBlock, tag[:], out, ciphertext)
+
+	var expected [sivTagSize]byte
+	computeTag(expected[:], authKey, encBlock, nonce, out, additionalData)
+	if subtle.ConstantTimeCompare(expected[:], tag[:]) != 1 {
+		clear(out)
+		return nil, errOpen
+	}
+	return ret, nil
+}
+
+// deriveKeys derives the per-nonce message-authentication and message-encryption keys.
+func (g *gcmSIV) deriveKeys(nonce []byte) ([]byte, cipher.Block) {
+	blocks := 2 + g.keyLen/8
+	keys := make([]byte, 0, blocks*8)
+
+	var in, out [aes.BlockSize]byte
+	copy(in[4:], nonce)
+	for i := 0; i < blocks; i++ {
+		binary.LittleEndian.PutUint32(in[:4], uint32(i))
+		g.keyGen.Encrypt(out[:], in[:])
+		keys = append(keys, out[:8]...)
+	}
+
+	encBlock, err := aes.NewCipher(keys[16:])
+	if err != nil {
+		panic(err) // unreachable: the derived key is always 16 or 32 bytes
+	}
+	return keys[:16], encBlock
+}
+
+func computeTag(tag, authKey []byte, encBlock cipher.Block, nonce, plaintext, additionalData []byte) {
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additionalData))*8)
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext))*8)
+
+	p := newPolyval(authKey)
+	p.update(additionalData)
+	p.update(plaintext)
+	p.update(lengths[:])
+
+	var s [16]byte
+	p.sum(s[:])
+	for i := 0; i < sivNonceSize; i++ {
+		s[i] ^= nonce[i]
+	}
+	s[15] &= 0x7f
+	encBlock.Encrypt(tag, s[:])
+}
+
+// ctr is AES-CTR with the tag as initial counter block and a 32-bit little-endian counter.
+func ctr(encBlock cipher.Block, tag, dst, src []byte) {
+	var counter, keystream [aes.BlockSize]byte
+	copy(counter[:], tag)
+	counter[15] |= 0x80
+	c := binary.LittleEndian.Uint32(counter[:4])
+
+	for len(src) > 0 {
+		binary.LittleEndian.PutUint32(counter[:4], c)
+		encBlock.Encrypt(keystream[:], counter[:])
+		n := subtle.XORBytes(dst, src, keystream[:])
+		dst = dst[n:]
+		src = src[n:]
+		c++
+	}
+}
+
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return head, tail
+}
@@ -0,0 +1,165 @@
+package aesgcmsiv
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test vectors from RFC 8452, Appendix C.
+var rfc8452Vectors = []struct {
+	name      string
+	key       string
+	nonce     string
+	plaintext string
+	aad       string
+	result    string
+}{
+	// C.1. AEAD_AES_128_GCM_SIV
+	{
+		name:   "aes128/empty",
+		key:    "01000000000000000000000000000000",
+		nonce:  "030000000000000000000000",
+		result: "dc20e2d83f25705bb49e439eca56de25",
+	},
+	{
+		name:      "aes128/8-bytes",
+		key:       "01000000000000000000000000000000",
+		nonce:     "030000000000000000000000",
+		plaintext: "0100000000000000",
+		result:    "b5d839330ac7b786578782fff6013b815b287c22493a364c",
+	},
+	{
+		name:      "aes128/16-bytes",
+		key:       "01000000000000000000000000000000",
+		nonce:     "030000000000000000000000",
+		plaintext: "01000000000000000000000000000000",
+		result:    "743f7c8077ab25f8624e2e948579cf77303aaf90f6fe21199c6068577437a0c4",
+	},
+	{
+		name:      "aes128/with-aad",
+		key:       "01000000000000000000000000000000",
+		nonce:     "030000000000000000000000",
+		plaintext: "0200000000000000",
+		aad:       "01",
+		result:    "1e6daba35669f4273b0a1a2560969cdf790d99759abd1508",
+	},
+	// C.2. AEAD_AES_256_GCM_SIV
+	{
+		name:   "aes256/empty",
+		key:    "0100000000000000000000000000000000000000000000000000000000000000",
+		nonce:  "030000000000000000000000",
+		result: "07f5f4169bbf55a8400cd47ea6fd400f",
+	},
+	{
+		name:      "aes256/8-bytes",
+		key:       "0100000000000000000000000000000000000000000000000000000000000000",
+		nonce:     "030000000000000000000000",
+		plaintext: "0100000000000000",
+		result:    "c2ef328e5c71c83b843122130f7364b761e0b97427e3df28",
+	},
+	{
+		name:      "aes256/12-bytes",
+		key:       "0100000000000000000000000000000000000000000000000000000000000000",
+		nonce:     "030000000000000000000000",
+		plaintext: "010000000000000000000000",
+		result:    "9aab2aeb3faa0a34aea8e2b18ca50da9ae6559e48fd10f6e5c9ca17e",
+	},
+	{
+		name:      "aes256/16-bytes",
+		key:       "0100000000000000000000000000000000000000000000000000000000000000",
+		nonce:     "030000000000000000000000",
+		plaintext: "01000000000000000000000000000000",
+		result:    "85a01b63025ba19b7fd3ddfc033b3e76c9eac6fa700942702e90862383c6c366",
+	},
+	{
+		name:      "aes256/with-aad",
+		key:       "0100000000000000000000000000000000000000000000000000000000000000",
+		nonce:     "030000000000000000000000",
+		plaintext: "0200000000000000",
+		aad:       "01",
+		result:    "1de22967237a813291213f267e3b452f02d01ae33e4ec854",
+	},
+	// C.3. Counter wrap tests
+	{
+		name:      "aes256/counter-wrap",
+		key:       "0000000000000000000000000000000000000000000000000000000000000000",
+		nonce:     "000000000000000000000000",
+		plaintext: "000000000000000000000000000000004db923dc793ee6497c76dcc03a98e108",
+		result:    "f3f80f2cf0cb2dd9c5984fcda908456cc537703b5ba70324a6793a7bf218d3eaffffffff000000000000000000000000",
+	},
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestGCMSIV_RFC8452Vectors(t *testing.T) {
+	for _, tc := range rfc8452Vectors {
+		t.Run(tc.name, func(t *testing.T) {
+			aead, err := NewGCMSIV(mustHex(t, tc.key))
+			require.NoError(t, err)
+
+			nonce := mustHex(t, tc.nonce)
+			plaintext := mustHex(t, tc.plaintext)
+			aad := mustHex(t, tc.aad)
+			result := mustHex(t, tc.result)
+
+			assert.Equal(t, result, aead.Seal(nil, nonce, plaintext, aad))
+
+			opened, err := aead.Open(nil, nonce, result, aad)
+			require.NoError(t, err)
+			assert.Equal(t, hex.EncodeToString(plaintext), hex.EncodeToString(opened))
+		})
+	}
+}
+
+func TestPolyval_RFC8452Vectors(t *testing.T) {
+	// POLYVAL inputs and outputs from the worked examples of RFC 8452, Appendix C.
+	cases := []struct{ key, input, hash string }{
+		{"d9b360279694941ac5dbc6987ada7377", "00000000000000000000000000000000", "00000000000000000000000000000000"},
+		{"d9b360279694941ac5dbc6987ada7377", "01000000000000000000000000000000000000000000000040", "eb93b7740962c5e49d2a90a7dc5cec74"},
+		{"d9b360279694941ac5dbc6987ada7377", "010000000000000000000000000000000200000000000000000000000000000000000000000000000001", "ce6edc9a50b36d9a98986bbf6a261c3b"},
+		{"25629347589242761d31f826ba4b757b", "4f4f95668c83dfb6401762bb2d01a262d1a24ddd2721d006bbe45f20d3c9f362", "f7a3b47b846119fae5b7866cf5e5b77e"},
+	}
+	for _, tc := range cases {
+		p := newPolyval(mustHex(t, tc.key))
+		p.update(mustHex(t, tc.input))
+		sum := make([]byte, 16)
+		p.sum(sum)
+		assert.Equal(t, tc.hash, hex.EncodeToString(sum))
+	}
+}
+
+func TestGCMSIV_OpenRejectsTampering(t *testing.T) {
+	aead, err := NewGCMSIV(make([]byte, 32))
+	require.NoError(t, err)
+	nonce := make([]byte, 12)
+
+	sealed := aead.Seal(nil, nonce, []byte("attack at dawn"), []byte("ad"))
+
+	for i := range sealed {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 0x01
+		_, err := aead.Open(nil, nonce, tampered, []byte("ad"))
+		assert.Error(t, err, "byte %d", i)
+	}
+
+	_, err = aead.Open(nil, nonce, sealed, []byte("other-ad"))
+	assert.Error(t, err)
+
+	_, err = aead.Open(nil, nonce, sealed[:15], nil)
+	assert.Error(t, err)
+}
+
+func TestGCMSIV_InvalidKeySize(t *testing.T) {
+	for _, n := range []int{0, 15, 24, 33} {
+		_, err := NewGCMSIV(make([]byte, n))
+		assert.Error(t, err, "key size %d", n)
+	}
+}
@@ -0,0 +1,119 @@
+package aesgcmsiv
+
+import "encoding/binary"
+
+// --- POLYVAL ---
+//
+// POLYVAL is computed through its relation with GHASH (RFC 8452, Appendix A):
+//
+//	POLYVAL(H, X_1, ..., X_n) =
+//	    ByteReverse(GHASH(mulX_GHASH(ByteReverse(H)), ByteReverse(X_1), ..., ByteReverse(X_n)))
+//
+// GHASH itself uses the 4-bit table method.
+
+type fieldElement struct {
+	low, high uint64
+}
+
+type polyval struct {
+	productTable [16]fieldElement
+	s            fieldElement
+}
+
+var reductionTable = []uint16{
+	0x0000, 0x1c20, 0x3840, 0x2460, 0x7080, 0x6ca0, 0x48c0, 0x54e0,
+	0xe100, 0xfd20, 0xd940, 0xc560, 0x9180, 0x8da0, 0xa9c0, 0xb5e0,
+}
+
+func newPolyval(key []byte) *polyval {
+	var rev [16]byte
+	byteReverse(rev[:], key)
+
+	h := fieldElement{
+		low:  binary.BigEndian.Uint64(rev[:8]),
+		high: binary.BigEndian.Uint64(rev[8:]),
+	}
+	h = double(&h) // mulX_GHASH
+
+	p := &polyval{}
+	p.productTable[reverseBits(1)] = h
+	for i := 2; i < 16; i += 2 {
+		p.productTable[reverseBits(i)] = double(&p.productTable[reverseBits(i/2)])
+		p.productTable[reverseBits(i+1)] = add(&p.productTable[reverseBits(i)], &h)
+	}
+	return p
+}
+
+// update absorbs data, which is zero-padded to a multiple of the block size.
+func (p *polyval) update(data []byte) {
+	var block [16]byte
+	for len(data) > 0 {
+		n := copy(block[:], data)
+		clear(block[n:])
+		data = data[n:]
+
+		var rev [16]byte
+		byteReverse(rev[:], block[:])
+		p.s.low ^= binary.BigEndian.Uint64(rev[:8])
+		p.s.high ^= binary.BigEndian.Uint64(rev[8:])
+		p.mul(&p.s)
+	}
+}
+
+func (p *polyval) sum(out []byte) {
+	var rev [16]byte
+	binary.BigEndian.PutUint64(rev[:8], p.s.low)
+	binary.BigEndian.PutUint64(rev[8:], p.s.high)
+	byteReverse(out, rev[:])
+}
+
+func (p *polyval) mul(y *fieldElement) {
+	var z fieldElement
+	for i := 0; i < 2; i++ {
+		word := y.high
+		if i == 1 {
+			word = y.low
+		}
+		for j := 0; j < 64; j += 4 {
+			msw := z.high & 0xf
+			z.high >>= 4
+			z.high |= z.low << 60
+			z.low >>= 4
+			z.low ^= uint64(reductionTable[msw]) << 48
+
+			t := &p.productTable[word&0xf]
+			z.low ^= t.low
+			z.high ^= t.high
+			word >>= 4
+		}
+	}
+	*y = z
+}
+
+func double(x *fieldElement) fieldElement {
+	var d fieldElement
+	msbSet := x.high&1 == 1
+	d.high = x.high >> 1
+	d.high |= x.low << 63
+	d.low = x.low >> 1
+	if msbSet {
+		d.low ^= 0xe100000000000000
+	}
+	return d
+}
+
+func add(x, y *fieldElement) fieldElement {
+	return fieldElement{x.low ^ y.low, x.high ^ y.high}
+}
+
+func reverseBits(i int) int {
+	i = ((i << 2) & 0xc) | ((i >> 2) & 0x3)
+	i = ((i << 1) & 0xa) | ((i >> 1) & 0x5)
+	return i
+}
+
+func byteReverse(dst, src []byte) {
+	for i := range src {
+		dst[len(src)-1-i] = src[i]
+	}
+}
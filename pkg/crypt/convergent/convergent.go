@@ -0,0 +1,311 @@
+// Package convergent implements deterministic (convergent) encryption for deduplicating storage:
+// the key is derived from a keyed hash of the plaintext, so identical content encrypted under the
+// same tenant secret produces identical ciphertext.
+//
+// Equality leak: this is deliberate, and it is the only property given up. Anyone who sees two
+// ciphertexts learns whether their plaintexts are equal, and anyone holding the tenant secret can
+// confirm a guess of a file's complete content by encrypting the guess and comparing. Without the
+// secret, ciphertexts reveal nothing else. Use a separate secret per tenant so that equality is
+// never visible across tenants, and use the randomized crypters for data where even equality is
+// sensitive.
+//
+// The plaintext has to be read twice, so Encrypt spools it in memory up to MemoryLimit and in a
+// temporary file beyond that. The file holds the plaintext sealed with AES-256-GCM under a random
+// key that only the writer knows, so no plaintext reaches the disk; Close and every failing
+// Write remove it, and a writer that is never closed leaves only that ciphertext behind. Format:
+//
+//	"CONVv1" || ID(32) || chunks...
+//	ID         = HMAC-SHA256(HKDF(secret, "streamcrypt convergent id key"), plaintext)
+//	stream key = HKDF-SHA256(secret, ID, "streamcrypt convergent stream key")
+//
+// Chunks use the final-chunk framing of the AEAD crypters with AES-256-GCM and counter nonces.
+package convergent
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- Constants ---
+
+const (
+	headerPrefix = "CONVv1"
+	idSize       = sha256.Size
+	keySize      = 32
+	minSecret    = 32
+
+	DefaultMemoryLimit = 8 << 20
+
+	idKeyInfo     = "streamcrypt convergent id key"
+	streamKeyInfo = "streamcrypt convergent stream key"
+)
+
+// --- Convergent Crypter ---
+
+// Crypter encrypts deterministically under a tenant Secret of at least 32 bytes.
+// Plaintext beyond MemoryLimit bytes (DefaultMemoryLimit if zero) is spooled, encrypted, to a
+// temporary file in SpoolDir (os.TempDir if empty) while encrypting.
+type Crypter struct {
+	Secret      []byte
+	SpoolDir    string
+	MemoryLimit int64
+
+	// SecretKey, when set, is used instead of Secret.
+	SecretKey *crypt.SecretKey
+}
+
+var (
+	_ crypt.Crypter    = &Crypter{}
+	_ crypt.Destroyer  = &Crypter{}
+	_ policy.Describer = &Crypter{}
+)
+
+// NewCrypter copies secret into a crypt.SecretKey, so the caller can wipe secret afterwards.
+func NewCrypter(secret []byte) crypt.Crypter {
+	return &Crypter{
+		SecretKey:   crypt.NewSecretKey(secret),
+		MemoryLimit: DefaultMemoryLimit,
+	}
+}
+
+func (c *Crypter) Destroy() {
+	if c.SecretKey == nil {
+		c.SecretKey = &crypt.SecretKey{}
+	}
+	c.SecretKey.Destroy()
+	clear(c.Secret)
+	c.Secret = nil
+}
+
+func (c *Crypter) FileExtension() string {
+	return ".caes"
+}
+
+func (c *Crypter) Name() string {
+	return "aes-256-gcm-convergent"
+}
+
+func (c *Crypter) newIDHash() (hash.Hash, error) {
+	var h hash.Hash
+	err := crypt.UseKey(c.SecretKey, c.Secret, func(secret []byte) error {
+		if len(secret) < minSecret {
+			return fmt.Errorf("secret too short: got %d bytes, want at least %d", len(secret), minSecret)
+		}
+		idKey, err := hkdf.Key(sha256.New, secret, nil, idKeyInfo, keySize)
+		if err != nil {
+			return err
+		}
+		defer clear(idKey)
+		h = hmac.New(sha256.New, idKey)
+		return nil
+	})
+	return h, err
+}
+
+func (c *Crypter) newAEAD(id []byte) (cipher.AEAD, error) {
+	var block cipher.Block
+	err := crypt.UseKey(c.SecretKey, c.Secret, func(secret []byte) error {
+		key, err := hkdf.Key(sha256.New, secret, id, streamKeyInfo, keySize)
+		if err != nil {
+			return err
+		}
+		defer clear(key)
+		block, err = aes.NewCipher(key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *Crypter) Algorithms() []policy.Algorithm {
+	return []policy.Algorithm{
+		{Kind: policy.Cipher, Name: "aes-256-gcm", KeyBits: keySize * 8},
+		{Kind: policy.KDF, Name: "hkdf-sha256"},
+		{Kind: policy.MAC, Name: "hmac-sha256", KeyBits: keySize * 8},
+	}
+}
+
+func (c *Crypter) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	h, err := c.newIDHash()
+	if err != nil {
+		return nil, err
+	}
+	return &spoolWriter{c: c, w: w, hash: h}, nil
+}
+
+// spoolWriter hashes and spools the plaintext; Close derives the key and encrypts the spool.
+type spoolWriter struct {
+	c      *Crypter
+	w      io.Writer
+	hash   hash.Hash
+	mem    bytes.Buffer
+	file   *os.File
+	aead   cipher.AEAD    // seals the spool file under a random key
+	spool  io.WriteCloser // writes to file through aead
+	err    error
+	closed bool
+}
+
+func (s *spoolWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("write after close")
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.hash.Write(p)
+	limit := s.c.MemoryLimit
+	if limit <= 0 {
+		limit = DefaultMemoryLimit
+	}
+	if s.file == nil && int64(s.mem.Len()+len(p)) > limit {
+		if err := s.spill(); err != nil {
+			return 0, s.fail(err)
+		}
+	}
+	if s.file != nil {
+		n, err := s.spool.Write(p)
+		if err != nil {
+			return n, s.fail(err)
+		}
+		return n, nil
+	}
+	return s.mem.Write(p)
+}
+
+// spill moves the spool from memory to a temporary file, sealed under a random key.
+func (s *spoolWriter) spill() error {
+	key := make([]byte, keySize)
+	defer clear(key)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	if s.aead, err = cipher.NewGCM(block); err != nil {
+		return err
+	}
+	if s.file, err = os.CreateTemp(s.c.SpoolDir, "streamcrypt-convergent-*"); err != nil {
+		return err
+	}
+	s.spool = chunked.NewFinalWriter(s.file, s.aead)
+	spooled := s.mem.Bytes()
+	_, err = s.spool.Write(spooled)
+	clear(spooled)
+	s.mem = bytes.Buffer{}
+	return err
+}
+
+// fail removes the spool file, if any; later writes and Close return err.
+func (s *spoolWriter) fail(err error) error {
+	s.err = err
+	if s.file != nil {
+		return errors.Join(err, s.removeFile())
+	}
+	return err
+}
+
+func (s *spoolWriter) removeFile() error {
+	f := s.file
+	s.file = nil
+	return errors.Join(f.Close(), os.Remove(f.Name()))
+}
+
+func (s *spoolWriter) Close() (err error) {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.err != nil {
+		return s.err
+	}
+
+	var spool io.Reader = &s.mem
+	if s.file != nil {
+		defer func() {
+			err = errors.Join(err, s.removeFile())
+		}()
+		if err := s.spool.Close(); err != nil {
+			return err
+		}
+		if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		spool = chunked.NewFinalReader(s.file, s.aead)
+	}
+
+	id := s.hash.Sum(nil)
+	aead, err := s.c.newAEAD(id)
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(append([]byte(headerPrefix), id...)); err != nil {
+		return err
+	}
+	cw := chunked.NewFinalWriter(s.w, aead)
+	if _, err := io.Copy(cw, spool); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+func (c *Crypter) Decrypt(r io.Reader) (io.Reader, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	h, err := c.newIDHash()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(headerPrefix)+idSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(headerPrefix)]) != headerPrefix {
+		return nil, errors.New("invalid file header")
+	}
+	id := header[len(headerPrefix):]
+
+	aead, err := c.newAEAD(id)
+	if err != nil {
+		return nil, err
+	}
+	return &verifyingReader{r: chunked.NewFinalReader(r, aead), hash: h, id: id}, nil
+}
+
+// verifyingReader checks at EOF that the plaintext hashes to the ID it was encrypted under.
+type verifyingReader struct {
+	r    io.Reader
+	hash hash.Hash
+	id   []byte
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	if errors.Is(err, io.EOF) && !hmac.Equal(v.hash.Sum(nil), v.id) {
+		return n, errors.New("convergence ID mismatch: wrong secret or tampering detected")
+	}
+	return n, err
+}
@@ -0,0 +1,184 @@
+package convergent
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+	"github.com/hashmap-kz/streamcrypt/pkg/pipe"
+)
+
+var (
+	tenantA = bytes.Repeat([]byte{0xa}, 32)
+	tenantB = bytes.Repeat([]byte{0xb}, 32)
+)
+
+func encrypt(t *testing.T, c crypt.Crypter, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := c.Encrypt(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func decrypt(c crypt.Crypter, encrypted []byte) ([]byte, error) {
+	r, err := c.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestConvergent_Deterministic(t *testing.T) {
+	plain := bytes.Repeat([]byte("dedup me "), 20000)
+	a1 := encrypt(t, NewCrypter(tenantA), plain)
+	a2 := encrypt(t, NewCrypter(tenantA), plain)
+	assert.Equal(t, a1, a2, "identical content under one secret must encrypt identically")
+
+	b := encrypt(t, NewCrypter(tenantB), plain)
+	assert.NotEqual(t, a1[:len(headerPrefix)+idSize], b[:len(headerPrefix)+idSize], "tenants must not see each other's duplicates")
+
+	other := encrypt(t, NewCrypter(tenantA), append(bytes.Clone(plain), '!'))
+	assert.NotEqual(t, a1[:len(headerPrefix)+idSize], other[:len(headerPrefix)+idSize])
+
+	got, err := decrypt(NewCrypter(tenantA), a1)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+}
+
+func TestConvergent_SpoolsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	c := &Crypter{Secret: tenantA, SpoolDir: dir, MemoryLimit: 1000}
+	plain := bytes.Repeat([]byte{1, 2, 3}, 3*chunked.ChunkSize)
+
+	var buf bytes.Buffer
+	w, err := c.Encrypt(&buf)
+	require.NoError(t, err)
+	for i := 0; i < len(plain); i += 700 {
+		_, err = w.Write(plain[i:min(i+700, len(plain))])
+		require.NoError(t, err)
+	}
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "the plaintext must be spooled to a temporary file")
+	spooled, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+	assert.Greater(t, len(spooled), chunked.ChunkSize)
+	assert.False(t, bytes.Contains(spooled, plain[:64]), "the spool file must not hold plaintext")
+	require.NoError(t, w.Close())
+
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the spool file must be removed")
+
+	assert.Equal(t, encrypt(t, NewCrypter(tenantA), plain), buf.Bytes(), "spooling must not change the output")
+	got, err := decrypt(c, buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+}
+
+func TestConvergent_SpoolErrorsRemoveTheFile(t *testing.T) {
+	plain := bytes.Repeat([]byte{1, 2, 3}, chunked.ChunkSize)
+
+	w, err := (&Crypter{Secret: tenantA, SpoolDir: filepath.Join(t.TempDir(), "missing"), MemoryLimit: 10}).Encrypt(io.Discard)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.Error(t, err)
+	_, err = w.Write(plain)
+	require.Error(t, err, "a failed writer stays failed")
+	require.Error(t, w.Close())
+
+	dir := t.TempDir()
+	w, err = (&Crypter{Secret: tenantA, SpoolDir: dir, MemoryLimit: 10}).Encrypt(io.Discard)
+	require.NoError(t, err)
+	_, err = w.Write(plain[:100])
+	require.NoError(t, err)
+	require.NoError(t, w.(*spoolWriter).file.Close()) // the next chunk cannot reach the disk
+	_, err = w.Write(plain)
+	require.Error(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "a failed write must remove the spool file")
+	require.Error(t, w.Close())
+}
+
+func TestConvergent_Rejects(t *testing.T) {
+	encrypted := encrypt(t, NewCrypter(tenantA), []byte("secret content"))
+
+	_, err := decrypt(NewCrypter(tenantB), encrypted)
+	require.Error(t, err)
+
+	tampered := bytes.Clone(encrypted)
+	tampered[len(tampered)-1] ^= 1
+	_, err = decrypt(NewCrypter(tenantA), tampered)
+	require.Error(t, err)
+
+	_, err = decrypt(NewCrypter(tenantA), encrypted[:len(headerPrefix)+idSize])
+	require.ErrorIs(t, err, chunked.ErrTruncated)
+
+	_, err = NewCrypter([]byte("short")).Encrypt(io.Discard)
+	require.ErrorContains(t, err, "secret too short")
+}
+
+func TestConvergent_Destroy(t *testing.T) {
+	c := NewCrypter(tenantA).(*Crypter)
+	encrypted := encrypt(t, c, []byte("secret content"))
+	c.Destroy()
+	_, err := decrypt(c, encrypted)
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+	assert.Equal(t, bytes.Repeat([]byte{0xa}, 32), tenantA, "the caller's secret is copied, not wiped")
+
+	withSecret := &Crypter{Secret: bytes.Clone(tenantA)}
+	withSecret.Destroy()
+	_, err = decrypt(withSecret, encrypted)
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+}
+
+func TestConvergent_IDMustMatchContent(t *testing.T) {
+	// A stream whose ID is not the keyed hash of its content, as a forger holding the secret could write.
+	c := &Crypter{Secret: tenantA}
+	id := bytes.Repeat([]byte{7}, idSize)
+	aead, err := c.newAEAD(id)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	buf.WriteString(headerPrefix)
+	buf.Write(id)
+	w := chunked.NewFinalWriter(&buf, aead)
+	_, err = w.Write([]byte("not what the ID says"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	_, err = decrypt(c, buf.Bytes())
+	require.ErrorContains(t, err, "convergence ID mismatch")
+}
+
+func TestConvergent_WithPipe(t *testing.T) {
+	plain := bytes.Repeat([]byte("compressible "), 10000)
+	c := NewCrypter(tenantA)
+	var outputs [][]byte
+	for range 2 {
+		r, err := pipe.CompressAndEncryptOptional(bytes.NewReader(plain), &codec.ZstdCompressor{}, c)
+		require.NoError(t, err)
+		out, err := io.ReadAll(r)
+		require.NoError(t, err)
+		outputs = append(outputs, out)
+	}
+	assert.Equal(t, outputs[0], outputs[1])
+
+	rc, err := pipe.DecryptAndDecompressOptional(bytes.NewReader(outputs[0]), c, &codec.ZstdDecompressor{})
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+}
@@ -1,6 +1,9 @@
 package crypt
 
-import "io"
+import (
+	"errors"
+	"io"
+)
 
 type Crypter interface {
 	Encrypt(w io.Writer) (io.WriteCloser, error)
@@ -8,3 +11,16 @@ type Crypter interface {
 	FileExtension() string
 	Name() string
 }
+
+// ContextCrypter binds streams to caller-supplied associated data, such as an object path,
+// tenant ID or backup ID. A stream only decrypts under the associated data it was encrypted with,
+// so swapping two objects is detected.
+type ContextCrypter interface {
+	Crypter
+	EncryptWithContext(w io.Writer, associatedData []byte) (io.WriteCloser, error)
+	DecryptWithContext(r io.Reader, associatedData []byte) (io.Reader, error)
+}
+
+// ErrContextMismatch is returned when a stream is decrypted under other associated data
+// than it was encrypted with.
+var ErrContextMismatch = errors.New("associated data mismatch: the stream belongs to a different context")
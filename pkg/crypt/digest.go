@@ -0,0 +1,69 @@
+package crypt
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// DigestAlgorithm selects the hash used for plaintext digests.
+type DigestAlgorithm byte
+
+const (
+	DigestNone DigestAlgorithm = iota
+	DigestSHA256
+	DigestBLAKE2b256
+)
+
+func (a DigestAlgorithm) String() string {
+	switch a {
+	case DigestNone:
+		return "none"
+	case DigestSHA256:
+		return "sha256"
+	case DigestBLAKE2b256:
+		return "blake2b-256"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(a))
+	}
+}
+
+// New returns a new hash.Hash for the algorithm.
+func (a DigestAlgorithm) New() (hash.Hash, error) {
+	switch a {
+	case DigestSHA256:
+		return sha256.New(), nil
+	case DigestBLAKE2b256:
+		return blake2b.New256(nil)
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm: %s", a)
+	}
+}
+
+// Digest is the digest and total length of a stream's plaintext.
+type Digest struct {
+	Algorithm DigestAlgorithm
+	Sum       []byte
+	Length    int64
+}
+
+func (d *Digest) String() string {
+	return fmt.Sprintf("%s:%x", d.Algorithm, d.Sum)
+}
+
+// DigestWriter is returned by crypters configured to record a plaintext digest.
+// Digest is available after Close.
+type DigestWriter interface {
+	io.WriteCloser
+	Digest() (*Digest, error)
+}
+
+// DigestReader is returned by crypters that verify a recorded plaintext digest.
+// Digest is available once Read has returned io.EOF, which happens only if the digest matched.
+type DigestReader interface {
+	io.Reader
+	Digest() (*Digest, error)
+}
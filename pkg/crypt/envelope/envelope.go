@@ -0,0 +1,362 @@
+// Package envelope implements envelope encryption: every stream is encrypted with a fresh
+// random data key, and the data key is wrapped for one or more recipients in the header.
+//
+// Stream layout:
+//
+//	"RCPTv2" || stanzaCount(u16) || stanza... || headerMAC(32) || chunks
+//	stanza = typeLen(u8) || type || bodyLen(u32) || body
+//
+// The header MAC is HMAC-SHA256 keyed with a key derived from the data key, so stanzas
+// cannot be added, removed or altered without the data key. The payload uses the chunk
+// framing of aesgcm v2 streams with AES-256-GCM under another key derived from the data key:
+// chunks carry their counter and the last one is marked final, so reordering and truncation
+// are detected.
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- Constants ---
+
+const (
+	DataKeySize  = 32
+	headerPrefix = "RCPTv2"
+	macSize      = sha256.Size
+
+	maxStanzas    = 1024
+	maxStanzaBody = 1 << 20
+
+	headerKeyInfo  = "streamcrypt envelope header"
+	payloadKeyInfo = "streamcrypt envelope payload"
+)
+
+// ErrIncorrectIdentity is returned by Identity.Unwrap when the stanza is not addressed to the identity.
+var ErrIncorrectIdentity = errors.New("incorrect identity for recipient stanza")
+
+// --- Recipients and Identities ---
+
+// Stanza is one wrapped copy of the data key stored in the stream header.
+type Stanza struct {
+	Type string
+	Body []byte
+}
+
+// Recipient wraps a data key so that only the matching Identity can unwrap it.
+type Recipient interface {
+	Wrap(dataKey []byte) (*Stanza, error)
+}
+
+// Identity unwraps data keys wrapped for its Recipient.
+//
+// Unwrap returns ErrIncorrectIdentity if the stanza is of a different type or was wrapped for
+// another recipient; any other error aborts decryption.
+type Identity interface {
+	Unwrap(stanza *Stanza) ([]byte, error)
+}
+
+// --- Envelope Crypter ---
+
+// Crypter encrypts to Recipients and decrypts with any of Identities.
+type Crypter struct {
+	Recipients []Recipient
+	Identities []Identity
+}
+
+var (
+	_ crypt.Crypter    = &Crypter{}
+	_ crypt.Destroyer  = &Crypter{}
+	_ policy.Describer = &Crypter{}
+)
+
+// NewEncrypter returns a Crypter that encrypts streams to all given recipients.
+func NewEncrypter(recipients ...Recipient) crypt.Crypter {
+	return &Crypter{
+		Recipients: recipients,
+	}
+}
+
+// NewDecrypter returns a Crypter that decrypts streams addressed to any of the given identities.
+func NewDecrypter(identities ...Identity) crypt.Crypter {
+	return &Crypter{
+		Identities: identities,
+	}
+}
+
+// Destroy destroys every recipient and identity that holds secret keys (implements crypt.Destroyer).
+func (c *Crypter) Destroy() {
+	for _, r := range c.Recipients {
+		if d, ok := r.(crypt.Destroyer); ok {
+			d.Destroy()
+		}
+	}
+	for _, id := range c.Identities {
+		if d, ok := id.(crypt.Destroyer); ok {
+			d.Destroy()
+		}
+	}
+}
+
+func (c *Crypter) FileExtension() string {
+	return ".envelope"
+}
+
+func (c *Crypter) Name() string {
+	return "envelope-aes-256-gcm"
+}
+
+func (c *Crypter) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	if len(c.Recipients) == 0 {
+		return nil, errors.New("no recipients specified")
+	}
+	dataKey := make([]byte, DataKeySize)
+	defer clear(dataKey) // the payload AEAD and header MAC are derived from it up front
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	stanzas := make([]*Stanza, 0, len(c.Recipients))
+	for _, r := range c.Recipients {
+		s, err := r.Wrap(dataKey)
+		if err != nil {
+			return nil, fmt.Errorf("wrap data key: %w", err)
+		}
+		stanzas = append(stanzas, s)
+	}
+	return newWriter(w, dataKey, stanzas)
+}
+
+func (c *Crypter) Decrypt(r io.Reader) (io.Reader, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	if len(c.Identities) == 0 {
+		return nil, errors.New("no identities specified")
+	}
+	h, err := ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := unwrap(h.Stanzas, c.Identities)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dataKey)
+	return newReader(r, h, dataKey)
+}
+
+func unwrap(stanzas []*Stanza, identities []Identity) ([]byte, error) {
+	for _, id := range identities {
+		for _, s := range stanzas {
+			dataKey, err := id.Unwrap(s)
+			if errors.Is(err, ErrIncorrectIdentity) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unwrap %s stanza: %w", s.Type, err)
+			}
+			return dataKey, nil
+		}
+	}
+	return nil, errors.New("no identity matched any of the recipients")
+}
+
+// --- Header ---
+
+// Header is the parsed, not yet authenticated, stream header.
+type Header struct {
+	Stanzas []*Stanza
+	raw     []byte // serialized stanzas, covered by the MAC
+	mac     []byte
+}
+
+// newWriter writes the header with the given stanzas and returns a writer that encrypts the
+// payload under dataKey.
+func newWriter(w io.Writer, dataKey []byte, stanzas []*Stanza) (io.WriteCloser, error) {
+	raw, err := marshalStanzas(stanzas)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := headerMAC(dataKey, raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := payloadAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(mac); err != nil {
+		return nil, err
+	}
+	return chunked.NewFinalWriter(w, aead), nil
+}
+
+// ReadHeader reads the stream header from r, leaving r positioned at the payload.
+func ReadHeader(r io.Reader) (*Header, error) {
+	var buf bytes.Buffer
+	tr := io.TeeReader(r, &buf)
+
+	prefix := make([]byte, len(headerPrefix)+2)
+	if _, err := io.ReadFull(tr, prefix); err != nil {
+		return nil, err
+	}
+	if string(prefix[:len(headerPrefix)]) != headerPrefix {
+		return nil, errors.New("invalid file header")
+	}
+	count := int(binary.BigEndian.Uint16(prefix[len(headerPrefix):]))
+	if count == 0 || count > maxStanzas {
+		return nil, fmt.Errorf("invalid number of stanzas: %d", count)
+	}
+
+	h := &Header{}
+	for i := 0; i < count; i++ {
+		s, err := readStanza(tr)
+		if err != nil {
+			return nil, fmt.Errorf("read stanza %d: %w", i, err)
+		}
+		h.Stanzas = append(h.Stanzas, s)
+	}
+	h.raw = buf.Bytes()
+
+	h.mac = make([]byte, macSize)
+	if _, err := io.ReadFull(r, h.mac); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// newReader authenticates the header with dataKey and returns a reader that decrypts the payload.
+func newReader(r io.Reader, h *Header, dataKey []byte) (io.Reader, error) {
+	expected, err := headerMAC(dataKey, h.raw)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(expected, h.mac) {
+		return nil, errors.New("header authentication failed: tampering or wrong data key")
+	}
+	aead, err := payloadAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return chunked.NewFinalReader(r, aead), nil
+}
+
+func marshalStanzas(stanzas []*Stanza) ([]byte, error) {
+	if len(stanzas) == 0 || len(stanzas) > maxStanzas {
+		return nil, fmt.Errorf("invalid number of stanzas: %d", len(stanzas))
+	}
+	buf := []byte(headerPrefix)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(stanzas)))
+	for _, s := range stanzas {
+		var err error
+		if buf, err = appendStanza(buf, s); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendStanza(buf []byte, s *Stanza) ([]byte, error) {
+	if s.Type == "" || len(s.Type) > 255 {
+		return nil, fmt.Errorf("invalid stanza type: %q", s.Type)
+	}
+	if len(s.Body) > maxStanzaBody {
+		return nil, fmt.Errorf("stanza %s body too large", s.Type)
+	}
+	buf = append(buf, byte(len(s.Type)))
+	buf = append(buf, s.Type...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s.Body)))
+	return append(buf, s.Body...), nil
+}
+
+func readStanza(r io.Reader) (*Stanza, error) {
+	typeLen := make([]byte, 1)
+	if _, err := io.ReadFull(r, typeLen); err != nil {
+		return nil, err
+	}
+	if typeLen[0] == 0 {
+		return nil, errors.New("empty stanza type")
+	}
+	typ := make([]byte, typeLen[0])
+	if _, err := io.ReadFull(r, typ); err != nil {
+		return nil, err
+	}
+	bodyLen := make([]byte, 4)
+	if _, err := io.ReadFull(r, bodyLen); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(bodyLen)
+	if n > maxStanzaBody {
+		return nil, fmt.Errorf("stanza %s body too large", typ)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &Stanza{Type: string(typ), Body: body}, nil
+}
+
+// --- Key schedule ---
+
+func headerMAC(dataKey, raw []byte) ([]byte, error) {
+	key, err := hkdf.Key(sha256.New, dataKey, nil, headerKeyInfo, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	m := hmac.New(sha256.New, key)
+	m.Write(raw)
+	return m.Sum(nil), nil
+}
+
+func payloadAEAD(dataKey []byte) (cipher.AEAD, error) {
+	if len(dataKey) != DataKeySize {
+		return nil, fmt.Errorf("invalid data key size: %d", len(dataKey))
+	}
+	key, err := hkdf.Key(sha256.New, dataKey, nil, payloadKeyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newWrapAEAD returns the AEAD that seals a data key under a key derived from a freshly
+// agreed secret. Every such key is used exactly once, so a zero nonce is safe.
+func newWrapAEAD(secret, salt []byte, info string) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, secret, salt, info, 32)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
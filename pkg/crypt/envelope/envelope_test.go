@@ -0,0 +1,165 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+	"github.com/hashmap-kz/streamcrypt/pkg/pipe"
+)
+
+// testRecipient wraps the data key by XOR with a fixed secret; good enough to test the envelope itself.
+type testRecipient struct {
+	name   string
+	secret []byte
+}
+
+func newTestRecipient(t *testing.T, name string) *testRecipient {
+	t.Helper()
+	secret := make([]byte, DataKeySize)
+	_, err := rand.Read(secret)
+	require.NoError(t, err)
+	return &testRecipient{name: name, secret: secret}
+}
+
+func (r *testRecipient) Wrap(dataKey []byte) (*Stanza, error) {
+	body := append([]byte(r.name+":"), dataKey...)
+	for i := range dataKey {
+		body[len(r.name)+1+i] ^= r.secret[i]
+	}
+	return &Stanza{Type: "test", Body: body}, nil
+}
+
+func (r *testRecipient) Unwrap(s *Stanza) ([]byte, error) {
+	if s.Type != "test" || !bytes.HasPrefix(s.Body, []byte(r.name+":")) {
+		return nil, ErrIncorrectIdentity
+	}
+	wrapped := s.Body[len(r.name)+1:]
+	if len(wrapped) != DataKeySize {
+		return nil, errors.New("bad test stanza")
+	}
+	dataKey := make([]byte, DataKeySize)
+	for i := range dataKey {
+		dataKey[i] = wrapped[i] ^ r.secret[i]
+	}
+	return dataKey, nil
+}
+
+func encryptTo(t *testing.T, plain []byte, recipients ...Recipient) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncrypter(recipients...).Encrypt(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func decryptWith(encrypted []byte, identities ...Identity) ([]byte, error) {
+	r, err := NewDecrypter(identities...).Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEnvelope_MultipleRecipients(t *testing.T) {
+	alice := newTestRecipient(t, "alice")
+	bob := newTestRecipient(t, "bob")
+	plain := bytes.Repeat([]byte("envelope "), 20000)
+
+	encrypted := encryptTo(t, plain, alice, bob)
+	assert.True(t, bytes.HasPrefix(encrypted, []byte(headerPrefix)))
+
+	for _, id := range []Identity{alice, bob} {
+		out, err := decryptWith(encrypted, id)
+		require.NoError(t, err)
+		assert.Equal(t, plain, out)
+	}
+
+	_, err := decryptWith(encrypted, newTestRecipient(t, "mallory"))
+	require.ErrorContains(t, err, "no identity matched")
+}
+
+func TestEnvelope_HeaderTampering(t *testing.T) {
+	alice := newTestRecipient(t, "alice")
+	encrypted := encryptTo(t, []byte("payload"), alice)
+
+	// Flipping a byte of the MAC must fail header authentication, while the stanza still unwraps.
+	h, err := ReadHeader(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	macOffset := len(h.raw)
+
+	tampered := append([]byte(nil), encrypted...)
+	tampered[macOffset] ^= 0x01
+	_, err = decryptWith(tampered, alice)
+	require.ErrorContains(t, err, "header authentication failed")
+}
+
+func TestEnvelope_DetectsReorderingAndTruncation(t *testing.T) {
+	alice := newTestRecipient(t, "alice")
+	encrypted := encryptTo(t, bytes.Repeat([]byte{7}, 3*chunked.ChunkSize+100), alice)
+	h, err := ReadHeader(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	headerSize := len(h.raw) + macSize
+	frame := chunked.NonceSize + chunked.ChunkSize + 16
+
+	body := encrypted[headerSize:]
+	swapped := append(bytes.Clone(encrypted[:headerSize]), body[frame:2*frame]...)
+	swapped = append(swapped, body[:frame]...)
+	swapped = append(swapped, body[2*frame:]...)
+	_, err = decryptWith(swapped, alice)
+	require.Error(t, err)
+
+	_, err = decryptWith(encrypted[:headerSize+3*frame], alice)
+	require.ErrorIs(t, err, chunked.ErrTruncated)
+	_, err = decryptWith(encrypted[:headerSize], alice)
+	require.ErrorIs(t, err, chunked.ErrTruncated)
+}
+
+func TestEnvelope_InvalidHeader(t *testing.T) {
+	alice := newTestRecipient(t, "alice")
+
+	_, err := decryptWith(append([]byte("AEADv1"), make([]byte, 64)...), alice)
+	require.ErrorContains(t, err, "invalid file header")
+
+	_, err = decryptWith([]byte(headerPrefix+"\x00\x00"), alice)
+	require.ErrorContains(t, err, "invalid number of stanzas")
+
+	_, err = decryptWith([]byte(headerPrefix), alice)
+	require.Error(t, err)
+}
+
+func TestEnvelope_NoRecipientsOrIdentities(t *testing.T) {
+	_, err := NewEncrypter().Encrypt(io.Discard)
+	require.ErrorContains(t, err, "no recipients")
+
+	_, err = NewDecrypter().Decrypt(bytes.NewReader(nil))
+	require.ErrorContains(t, err, "no identities")
+}
+
+func TestEnvelope_WithPipe(t *testing.T) {
+	id, err := GenerateHybridIdentity()
+	require.NoError(t, err)
+	plain := bytes.Repeat([]byte("compress me, then encrypt me "), 5000)
+
+	enc, err := pipe.CompressAndEncryptOptional(bytes.NewReader(plain), codec.ZstdCompressor{}, NewEncrypter(id.Recipient()))
+	require.NoError(t, err)
+	encrypted, err := io.ReadAll(enc)
+	require.NoError(t, err)
+
+	dec, err := pipe.DecryptAndDecompressOptional(bytes.NewReader(encrypted), NewDecrypter(id), codec.ZstdDecompressor{})
+	require.NoError(t, err)
+	defer dec.Close()
+	out, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	assert.Equal(t, plain, out)
+}
@@ -0,0 +1,245 @@
+package envelope
+
+import (
+	"crypto/ecdh"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/sha3"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// --- X25519 + ML-KEM-768 hybrid recipients ---
+//
+// The hybrid KEM is X-Wing (MLKEM768-X25519, HPKE KEM ID 0x647a): the shared secret is
+// SHA3-256(ss_MLKEM || ss_X25519 || ct_X25519 || pk_X25519 || label), so the data key
+// stays protected as long as either ML-KEM-768 or X25519 is unbroken.
+
+const (
+	HybridStanzaType = "mlkem768x25519"
+
+	HybridSeedSize       = 32
+	HybridPublicKeySize  = mlkem.EncapsulationKeySize768 + x25519Size
+	hybridCiphertextSize = mlkem.CiphertextSize768 + x25519Size
+	x25519Size           = 32
+
+	hybridRecipientPrefix = "mlkem768x25519-pub:"
+	hybridIdentityPrefix  = "MLKEM768X25519-SECRET-KEY:"
+
+	xwingLabel     = `\./` + `/^\`
+	hybridWrapInfo = "streamcrypt mlkem768x25519 wrap"
+)
+
+// HybridRecipient wraps data keys to an X25519 + ML-KEM-768 public key.
+type HybridRecipient struct {
+	pq *mlkem.EncapsulationKey768
+	t  *ecdh.PublicKey
+}
+
+var _ Recipient = &HybridRecipient{}
+
+// HybridIdentity is the private key of a HybridRecipient. It is fully determined by a 32-byte seed.
+type HybridIdentity struct {
+	seed []byte
+	pq   *mlkem.DecapsulationKey768
+	t    *ecdh.PrivateKey
+}
+
+var (
+	_ Identity        = &HybridIdentity{}
+	_ crypt.Destroyer = &HybridIdentity{}
+)
+
+// GenerateHybridIdentity returns a new random hybrid identity.
+func GenerateHybridIdentity() (*HybridIdentity, error) {
+	seed := make([]byte, HybridSeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	return NewHybridIdentity(seed)
+}
+
+// NewHybridIdentity expands a 32-byte seed into the ML-KEM-768 and X25519 private keys.
+func NewHybridIdentity(seed []byte) (*HybridIdentity, error) {
+	if len(seed) != HybridSeedSize {
+		return nil, fmt.Errorf("invalid hybrid seed size: %d", len(seed))
+	}
+	expanded := sha3.SumSHAKE256(seed, mlkem.SeedSize+x25519Size)
+	defer clear(expanded)
+
+	pq, err := mlkem.NewDecapsulationKey768(expanded[:mlkem.SeedSize])
+	if err != nil {
+		return nil, err
+	}
+	t, err := ecdh.X25519().NewPrivateKey(expanded[mlkem.SeedSize:])
+	if err != nil {
+		return nil, err
+	}
+	return &HybridIdentity{
+		seed: append([]byte(nil), seed...),
+		pq:   pq,
+		t:    t,
+	}, nil
+}
+
+// ParseHybridIdentity parses an identity encoded by HybridIdentity.String.
+func ParseHybridIdentity(s string) (*HybridIdentity, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(s), hybridIdentityPrefix)
+	if !ok {
+		return nil, errors.New("malformed hybrid identity: missing prefix")
+	}
+	seed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed hybrid identity: %w", err)
+	}
+	return NewHybridIdentity(seed)
+}
+
+func (i *HybridIdentity) String() string {
+	return hybridIdentityPrefix + base64.RawURLEncoding.EncodeToString(i.seed)
+}
+
+// Recipient returns the public key matching the identity.
+func (i *HybridIdentity) Recipient() *HybridRecipient {
+	return &HybridRecipient{
+		pq: i.pq.EncapsulationKey(),
+		t:  i.t.PublicKey(),
+	}
+}
+
+// Destroy zeroes the seed and drops the private keys; the identity cannot be used afterwards.
+// The expanded ML-KEM and X25519 keys live in the standard library and are left to the
+// garbage collector.
+func (i *HybridIdentity) Destroy() {
+	clear(i.seed)
+	i.seed, i.pq, i.t = nil, nil, nil
+}
+
+func (i *HybridIdentity) Unwrap(s *Stanza) ([]byte, error) {
+	if s.Type != HybridStanzaType {
+		return nil, ErrIncorrectIdentity
+	}
+	if i.pq == nil {
+		return nil, crypt.ErrKeyDestroyed
+	}
+	if len(s.Body) != hybridCiphertextSize+DataKeySize+16 {
+		return nil, errors.New("invalid hybrid stanza size")
+	}
+	ct, sealed := s.Body[:hybridCiphertextSize], s.Body[hybridCiphertextSize:]
+
+	ss, err := i.decapsulate(ct)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(ss)
+	aead, err := newWrapAEAD(ss, ct, hybridWrapInfo)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), sealed, nil)
+	if err != nil {
+		// Decapsulation with the wrong key yields a random secret rather than an error.
+		return nil, ErrIncorrectIdentity
+	}
+	return dataKey, nil
+}
+
+func (i *HybridIdentity) decapsulate(ct []byte) ([]byte, error) {
+	ctPQ, ctT := ct[:mlkem.CiphertextSize768], ct[mlkem.CiphertextSize768:]
+	ssPQ, err := i.pq.Decapsulate(ctPQ)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(ctT)
+	if err != nil {
+		return nil, err
+	}
+	ssT, err := i.t.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	return xwingCombine(ssPQ, ssT, ctT, i.t.PublicKey().Bytes()), nil
+}
+
+// NewHybridRecipient parses a raw public key: the ML-KEM-768 encapsulation key followed by the X25519 public key.
+func NewHybridRecipient(publicKey []byte) (*HybridRecipient, error) {
+	if len(publicKey) != HybridPublicKeySize {
+		return nil, fmt.Errorf("invalid hybrid public key size: %d", len(publicKey))
+	}
+	pq, err := mlkem.NewEncapsulationKey768(publicKey[:mlkem.EncapsulationKeySize768])
+	if err != nil {
+		return nil, err
+	}
+	t, err := ecdh.X25519().NewPublicKey(publicKey[mlkem.EncapsulationKeySize768:])
+	if err != nil {
+		return nil, err
+	}
+	return &HybridRecipient{pq: pq, t: t}, nil
+}
+
+// ParseHybridRecipient parses a recipient encoded by HybridRecipient.String.
+func ParseHybridRecipient(s string) (*HybridRecipient, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(s), hybridRecipientPrefix)
+	if !ok {
+		return nil, errors.New("malformed hybrid recipient: missing prefix")
+	}
+	publicKey, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed hybrid recipient: %w", err)
+	}
+	return NewHybridRecipient(publicKey)
+}
+
+// Bytes returns the raw public key accepted by NewHybridRecipient.
+func (r *HybridRecipient) Bytes() []byte {
+	return append(r.pq.Bytes(), r.t.Bytes()...)
+}
+
+func (r *HybridRecipient) String() string {
+	return hybridRecipientPrefix + base64.RawURLEncoding.EncodeToString(r.Bytes())
+}
+
+func (r *HybridRecipient) Wrap(dataKey []byte) (*Stanza, error) {
+	ss, ct, err := r.encapsulate()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newWrapAEAD(ss, ct, hybridWrapInfo)
+	if err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nil, make([]byte, aead.NonceSize()), dataKey, nil)
+	return &Stanza{
+		Type: HybridStanzaType,
+		Body: append(ct, sealed...),
+	}, nil
+}
+
+func (r *HybridRecipient) encapsulate() (sharedSecret, ciphertext []byte, err error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	ssT, err := ephemeral.ECDH(r.t)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctT := ephemeral.PublicKey().Bytes()
+	ssPQ, ctPQ := r.pq.Encapsulate()
+
+	return xwingCombine(ssPQ, ssT, ctT, r.t.Bytes()), append(ctPQ, ctT...), nil
+}
+
+func xwingCombine(ssPQ, ssT, ctT, pkT []byte) []byte {
+	h := sha3.New256()
+	h.Write(ssPQ)
+	h.Write(ssT)
+	h.Write(ctT)
+	h.Write(pkT)
+	h.Write([]byte(xwingLabel))
+	return h.Sum(nil)
+}
@@ -0,0 +1,118 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestHybridIdentity_XWingVectors(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "xwing_vectors.json"))
+	require.NoError(t, err)
+	var file struct {
+		Vectors []struct {
+			Seed         string `json:"seed"`
+			PublicKey    string `json:"public_key"`
+			Ciphertext   string `json:"ciphertext"`
+			SharedSecret string `json:"shared_secret"`
+		} `json:"vectors"`
+	}
+	require.NoError(t, json.Unmarshal(data, &file))
+	require.NotEmpty(t, file.Vectors)
+
+	for _, v := range file.Vectors {
+		id, err := NewHybridIdentity(mustHex(t, v.Seed))
+		require.NoError(t, err)
+		assert.Equal(t, v.PublicKey, hex.EncodeToString(id.Recipient().Bytes()))
+
+		ss, err := id.decapsulate(mustHex(t, v.Ciphertext))
+		require.NoError(t, err)
+		assert.Equal(t, v.SharedSecret, hex.EncodeToString(ss))
+	}
+}
+
+func TestHybridRecipient_EncapsulateDecapsulate(t *testing.T) {
+	id, err := GenerateHybridIdentity()
+	require.NoError(t, err)
+
+	ss, ct, err := id.Recipient().encapsulate()
+	require.NoError(t, err)
+	assert.Len(t, ct, hybridCiphertextSize)
+
+	got, err := id.decapsulate(ct)
+	require.NoError(t, err)
+	assert.Equal(t, ss, got)
+}
+
+func TestHybrid_EncodingRoundtrip(t *testing.T) {
+	id, err := GenerateHybridIdentity()
+	require.NoError(t, err)
+
+	parsedID, err := ParseHybridIdentity(id.String())
+	require.NoError(t, err)
+	assert.Equal(t, id.Recipient().Bytes(), parsedID.Recipient().Bytes())
+
+	parsedRecipient, err := ParseHybridRecipient(id.Recipient().String() + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, id.Recipient().Bytes(), parsedRecipient.Bytes())
+
+	_, err = ParseHybridRecipient(id.String())
+	assert.Error(t, err)
+	_, err = ParseHybridIdentity(id.Recipient().String())
+	assert.Error(t, err)
+	_, err = ParseHybridRecipient(hybridRecipientPrefix + "AAAA")
+	assert.Error(t, err)
+}
+
+func TestHybrid_EncryptDecrypt(t *testing.T) {
+	id, err := GenerateHybridIdentity()
+	require.NoError(t, err)
+	plain := bytes.Repeat([]byte("long-lived archive "), 10000)
+
+	var buf bytes.Buffer
+	w, err := NewEncrypter(id.Recipient()).Encrypt(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r, err := NewDecrypter(id).Decrypt(&buf)
+	require.NoError(t, err)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, plain, out)
+}
+
+func TestHybridIdentity_UnwrapWrongIdentity(t *testing.T) {
+	alice, err := GenerateHybridIdentity()
+	require.NoError(t, err)
+	bob, err := GenerateHybridIdentity()
+	require.NoError(t, err)
+
+	stanza, err := alice.Recipient().Wrap(make([]byte, DataKeySize))
+	require.NoError(t, err)
+
+	_, err = bob.Unwrap(stanza)
+	require.ErrorIs(t, err, ErrIncorrectIdentity)
+
+	_, err = alice.Unwrap(&Stanza{Type: "other", Body: stanza.Body})
+	require.ErrorIs(t, err, ErrIncorrectIdentity)
+
+	_, err = alice.Unwrap(&Stanza{Type: HybridStanzaType, Body: stanza.Body[:100]})
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrIncorrectIdentity)
+}
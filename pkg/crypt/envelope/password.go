@@ -0,0 +1,104 @@
+package envelope
+
+import (
+	"crypto/cipher"
+	"errors"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+)
+
+// --- Password recipient ---
+
+const (
+	PasswordStanzaType = "argon2id"
+
+	passwordSaltSize = 16
+	passwordWrapInfo = "streamcrypt argon2id wrap"
+)
+
+// PasswordRecipient wraps the data key with a key derived from a password by aesgcm.DerivePBEKey,
+// so a stream can be opened either with the password or with any of its public-key recipients.
+// It is both a Recipient and an Identity.
+type PasswordRecipient struct {
+	Password string
+
+	// SecretKey, when set, holds the password instead of Password and is zeroed by Destroy.
+	SecretKey *crypt.SecretKey
+}
+
+var (
+	_ Recipient       = &PasswordRecipient{}
+	_ Identity        = &PasswordRecipient{}
+	_ crypt.Destroyer = &PasswordRecipient{}
+)
+
+func NewPasswordRecipient(password string) *PasswordRecipient {
+	return &PasswordRecipient{
+		Password: password,
+	}
+}
+
+// NewPasswordRecipientFromBytes copies password into a crypt.SecretKey, so the caller can
+// wipe password afterwards.
+func NewPasswordRecipientFromBytes(password []byte) *PasswordRecipient {
+	return &PasswordRecipient{
+		SecretKey: crypt.NewSecretKey(password),
+	}
+}
+
+// Destroy zeroes SecretKey and drops Password; the recipient cannot be used afterwards.
+func (p *PasswordRecipient) Destroy() {
+	if p.SecretKey == nil {
+		p.SecretKey = &crypt.SecretKey{} // so later uses fail instead of using an empty password
+	}
+	p.SecretKey.Destroy()
+	p.Password = ""
+}
+
+// wrapAEAD derives the wrapping key from the password and salt, and wipes it once the AEAD is set up.
+func (p *PasswordRecipient) wrapAEAD(salt []byte) (cipher.AEAD, error) {
+	var key []byte
+	if p.SecretKey == nil {
+		key = aesgcm.GeneratePBEKey(p.Password, salt)
+	} else if err := p.SecretKey.Use(func(password []byte) error {
+		key = aesgcm.DerivePBEKey(password, salt)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	return newWrapAEAD(key, salt, passwordWrapInfo)
+}
+
+func (p *PasswordRecipient) Wrap(dataKey []byte) (*Stanza, error) {
+	salt, err := aesgcm.GenerateRandomNBytes(passwordSaltSize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := p.wrapAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+	body := aead.Seal(salt, make([]byte, aead.NonceSize()), dataKey, nil)
+	return &Stanza{Type: PasswordStanzaType, Body: body}, nil
+}
+
+func (p *PasswordRecipient) Unwrap(s *Stanza) ([]byte, error) {
+	if s.Type != PasswordStanzaType {
+		return nil, ErrIncorrectIdentity
+	}
+	if len(s.Body) != passwordSaltSize+DataKeySize+16 {
+		return nil, errors.New("invalid argon2id stanza size")
+	}
+	salt, sealed := s.Body[:passwordSaltSize], s.Body[passwordSaltSize:]
+	aead, err := p.wrapAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), sealed, nil)
+	if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+	return dataKey, nil
+}
@@ -0,0 +1,98 @@
+package envelope
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+func TestPasswordRecipient_WrapUnwrap(t *testing.T) {
+	dataKey := make([]byte, DataKeySize)
+	dataKey[0] = 42
+
+	stanza, err := NewPasswordRecipient("pw").Wrap(dataKey)
+	require.NoError(t, err)
+	assert.Equal(t, PasswordStanzaType, stanza.Type)
+
+	got, err := NewPasswordRecipient("pw").Unwrap(stanza)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, got)
+
+	_, err = NewPasswordRecipient("other").Unwrap(stanza)
+	require.ErrorIs(t, err, ErrIncorrectIdentity)
+
+	_, err = NewPasswordRecipient("pw").Unwrap(&Stanza{Type: PasswordStanzaType, Body: stanza.Body[:10]})
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrIncorrectIdentity)
+}
+
+func TestPasswordRecipient_Destroy(t *testing.T) {
+	password := []byte("pw")
+	recipient := NewPasswordRecipientFromBytes(password)
+	clear(password) // the constructor keeps its own copy
+	hybrid, err := GenerateHybridIdentity()
+	require.NoError(t, err)
+
+	encrypted := encryptTo(t, []byte("secret"), recipient, hybrid.Recipient())
+	got, err := decryptWith(encrypted, NewPasswordRecipient("pw"))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(got))
+
+	NewDecrypter(recipient, hybrid).(*Crypter).Destroy()
+	_, err = decryptWith(encrypted, recipient)
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+	_, err = decryptWith(encrypted, hybrid)
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+}
+
+func TestIdentities_Destroy(t *testing.T) {
+	edRecipient, err := ParseSSHRecipient(string(readTestdata(t, "ssh_ed25519.pub")))
+	require.NoError(t, err)
+	sshRSARecipient, err := ParseSSHRecipient(string(readTestdata(t, "ssh_rsa_pem.pub")))
+	require.NoError(t, err)
+	rsaRecipient, err := ParseRSARecipient(readTestdata(t, "rsa_public.pem"))
+	require.NoError(t, err)
+	c1, c2 := newTestRecipient(t, "c1"), newTestRecipient(t, "c2")
+	encrypted := encryptTo(t, []byte("secret"), edRecipient, sshRSARecipient, rsaRecipient, NewShamirRecipient(2, c1, c2))
+
+	edIdentity, err := ParseSSHIdentity(readTestdata(t, "ssh_ed25519"))
+	require.NoError(t, err)
+	sshRSAIdentity, err := ParseSSHIdentity(readTestdata(t, "ssh_rsa_pem"))
+	require.NoError(t, err)
+	rsaIdentity, err := ParseRSAIdentity(readTestdata(t, "rsa_pkcs8.pem"))
+	require.NoError(t, err)
+	rsaKey := rsaIdentity.priv
+	shares := extractShares(t, encrypted, c1, c2)
+	recovered, err := Recover(shares...)
+	require.NoError(t, err)
+	identities := []Identity{edIdentity, sshRSAIdentity, rsaIdentity}
+	for _, id := range identities {
+		_, err := decryptWith(encrypted, id)
+		require.NoError(t, err)
+	}
+
+	// The envelope crypter destroys every identity it holds, including recovered shares.
+	NewDecrypter(identities...).(*Crypter).Destroy()
+	for _, id := range identities {
+		_, err := decryptWith(encrypted, id)
+		require.ErrorIs(t, err, crypt.ErrKeyDestroyed, "%T", id)
+	}
+	assert.Zero(t, rsaKey.D.Sign(), "the private exponent must be zeroed")
+	for _, p := range rsaKey.Primes {
+		assert.Zero(t, p.Sign())
+	}
+
+	recovered.(*Crypter).Destroy()
+	_, err = recovered.Decrypt(bytes.NewReader(encrypted))
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+
+	value := shares[0].Value
+	shares[0].Destroy()
+	assert.Equal(t, make([]byte, DataKeySize), value, "the share must be zeroed")
+	_, err = Recover(shares...)
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+}
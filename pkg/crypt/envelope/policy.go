@@ -0,0 +1,82 @@
+package envelope
+
+import (
+	"fmt"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- Algorithms, for policy.Policy ---
+
+var (
+	aes256GCM  = policy.Algorithm{Kind: policy.Cipher, Name: "aes-256-gcm", KeyBits: 256}
+	hkdfSHA256 = policy.Algorithm{Kind: policy.KDF, Name: "hkdf-sha256"}
+	rsaOAEP    = policy.Algorithm{Kind: policy.KeyWrap, Name: "rsa-oaep-sha256"}
+	shamirGF   = policy.Algorithm{Kind: policy.KeyWrap, Name: "shamir-gf256"}
+
+	// payloadAlgorithms protect every envelope, whatever its recipients.
+	payloadAlgorithms = []policy.Algorithm{
+		aes256GCM,
+		hkdfSHA256,
+		{Kind: policy.MAC, Name: "hmac-sha256", KeyBits: 256},
+	}
+)
+
+// Algorithms lists the payload algorithms and those of every recipient and identity. A
+// recipient or identity that does not describe itself is listed as a key wrap named by its type,
+// so a policy that restricts key wrapping refuses it.
+func (c *Crypter) Algorithms() []policy.Algorithm {
+	algs := append([]policy.Algorithm(nil), payloadAlgorithms...)
+	for _, r := range c.Recipients {
+		algs = append(algs, describe(r)...)
+	}
+	for _, id := range c.Identities {
+		algs = append(algs, describe(id)...)
+	}
+	return algs
+}
+
+func describe(key any) []policy.Algorithm {
+	if d, ok := key.(policy.Describer); ok {
+		return d.Algorithms()
+	}
+	return []policy.Algorithm{{Kind: policy.KeyWrap, Name: fmt.Sprintf("%T", key)}}
+}
+
+func (p *PasswordRecipient) Algorithms() []policy.Algorithm {
+	return []policy.Algorithm{{Kind: policy.KDF, Name: "argon2id"}, hkdfSHA256, aes256GCM}
+}
+
+func (r *HybridRecipient) Algorithms() []policy.Algorithm {
+	return []policy.Algorithm{{Kind: policy.KeyAgreement, Name: HybridStanzaType}, hkdfSHA256, aes256GCM}
+}
+
+func (i *HybridIdentity) Algorithms() []policy.Algorithm {
+	return (&HybridRecipient{}).Algorithms()
+}
+
+func (r *RSARecipient) Algorithms() []policy.Algorithm    { return []policy.Algorithm{rsaOAEP} }
+func (i *RSAIdentity) Algorithms() []policy.Algorithm     { return []policy.Algorithm{rsaOAEP} }
+func (r *SSHRSARecipient) Algorithms() []policy.Algorithm { return []policy.Algorithm{rsaOAEP} }
+func (i *SSHRSAIdentity) Algorithms() []policy.Algorithm  { return []policy.Algorithm{rsaOAEP} }
+
+func (r *SSHEd25519Recipient) Algorithms() []policy.Algorithm {
+	return []policy.Algorithm{{Kind: policy.KeyAgreement, Name: "x25519"}, hkdfSHA256, aes256GCM}
+}
+
+func (i *SSHEd25519Identity) Algorithms() []policy.Algorithm {
+	return (&SSHEd25519Recipient{}).Algorithms()
+}
+
+// Algorithms lists the secret sharing scheme and the algorithms of every custodian.
+func (s *ShamirRecipient) Algorithms() []policy.Algorithm {
+	algs := []policy.Algorithm{shamirGF}
+	for _, c := range s.Custodians {
+		algs = append(algs, describe(c)...)
+	}
+	return algs
+}
+
+func (r *recoveredIdentity) Algorithms() []policy.Algorithm {
+	return []policy.Algorithm{shamirGF}
+}
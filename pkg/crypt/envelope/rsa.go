@@ -0,0 +1,196 @@
+package envelope
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// --- RSA-OAEP recipients ---
+//
+// The data key is wrapped with RSA-OAEP-SHA256. Every stanza starts with the SHA-256 of the
+// recipient's DER SubjectPublicKeyInfo, which identifies the key (and certificate) it was wrapped for.
+
+const (
+	RSAOAEPStanzaType = "rsa-oaep-sha256"
+
+	rsaKeyIDSize  = sha256.Size
+	rsaOAEPLabel  = "streamcrypt rsa-oaep-sha256 wrap"
+	minRSAKeyBits = 2048
+)
+
+type RSARecipient struct {
+	pub   *rsa.PublicKey
+	keyID []byte
+}
+
+var _ Recipient = &RSARecipient{}
+
+// NewRSARecipient returns a recipient for an RSA public key of at least 2048 bits.
+func NewRSARecipient(pub *rsa.PublicKey) (*RSARecipient, error) {
+	if pub.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("RSA key too small: %d bits", pub.N.BitLen())
+	}
+	keyID, err := rsaKeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+	return &RSARecipient{pub: pub, keyID: keyID}, nil
+}
+
+// NewRSARecipientFromCertificate returns a recipient for the RSA key of an X.509 certificate.
+// Certificates whose key usage is restricted must allow key encipherment.
+func NewRSARecipientFromCertificate(cert *x509.Certificate) (*RSARecipient, error) {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("certificate %q does not hold an RSA key", cert.Subject)
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageKeyEncipherment == 0 {
+		return nil, fmt.Errorf("certificate %q is not valid for key encipherment", cert.Subject)
+	}
+	return NewRSARecipient(pub)
+}
+
+// ParseRSARecipient parses a PEM "CERTIFICATE", "PUBLIC KEY" (PKIX) or "RSA PUBLIC KEY" (PKCS#1)
+// block, or a DER-encoded X.509 certificate.
+func ParseRSARecipient(data []byte) (*RSARecipient, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, fmt.Errorf("malformed RSA recipient: not PEM and not a DER certificate: %w", err)
+		}
+		return NewRSARecipientFromCertificate(cert)
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewRSARecipientFromCertificate(cert)
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported public key type: %T", key)
+		}
+		return NewRSARecipient(pub)
+	case "RSA PUBLIC KEY":
+		pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewRSARecipient(pub)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %q", block.Type)
+	}
+}
+
+func (r *RSARecipient) Wrap(dataKey []byte) (*Stanza, error) {
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, r.pub, dataKey, []byte(rsaOAEPLabel))
+	if err != nil {
+		return nil, err
+	}
+	body := append([]byte(nil), r.keyID...)
+	return &Stanza{Type: RSAOAEPStanzaType, Body: append(body, wrapped...)}, nil
+}
+
+type RSAIdentity struct {
+	priv  *rsa.PrivateKey
+	keyID []byte
+}
+
+var (
+	_ Identity        = &RSAIdentity{}
+	_ crypt.Destroyer = &RSAIdentity{}
+)
+
+// NewRSAIdentity takes ownership of priv: Destroy zeroes it.
+func NewRSAIdentity(priv *rsa.PrivateKey) (*RSAIdentity, error) {
+	keyID, err := rsaKeyID(&priv.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &RSAIdentity{priv: priv, keyID: keyID}, nil
+}
+
+// ParseRSAIdentity parses an unencrypted PKCS#8 private key, PEM ("PRIVATE KEY") or DER encoded.
+func ParseRSAIdentity(data []byte) (*RSAIdentity, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "PRIVATE KEY" {
+			return nil, fmt.Errorf("unsupported PEM block type: %q, want PKCS#8 \"PRIVATE KEY\"", block.Type)
+		}
+		der = block.Bytes
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+	return NewRSAIdentity(priv)
+}
+
+// Destroy zeroes the private key; the identity cannot be used afterwards.
+func (i *RSAIdentity) Destroy() {
+	if i.priv != nil {
+		wipeRSAKey(i.priv)
+	}
+	i.priv = nil
+}
+
+func (i *RSAIdentity) Unwrap(s *Stanza) ([]byte, error) {
+	if s.Type != RSAOAEPStanzaType {
+		return nil, ErrIncorrectIdentity
+	}
+	if i.priv == nil {
+		return nil, crypt.ErrKeyDestroyed
+	}
+	if len(s.Body) <= rsaKeyIDSize {
+		return nil, errors.New("invalid rsa-oaep-sha256 stanza size")
+	}
+	if string(s.Body[:rsaKeyIDSize]) != string(i.keyID) {
+		return nil, ErrIncorrectIdentity
+	}
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), nil, i.priv, s.Body[rsaKeyIDSize:], []byte(rsaOAEPLabel))
+	if err != nil {
+		return nil, fmt.Errorf("rsa-oaep-sha256: %w", err)
+	}
+	return dataKey, nil
+}
+
+// wipeRSAKey zeroes the private exponent, the primes and the CRT values of k. Values that
+// crypto/rsa precomputes internally are left to the garbage collector.
+func wipeRSAKey(k *rsa.PrivateKey) {
+	secrets := append([]*big.Int{k.D, k.Precomputed.Dp, k.Precomputed.Dq, k.Precomputed.Qinv}, k.Primes...)
+	for _, n := range secrets {
+		if n != nil {
+			clear(n.Bits())
+			n.SetInt64(0)
+		}
+	}
+}
+
+func rsaKeyID(pub *rsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	return sum[:], nil
+}
@@ -0,0 +1,101 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rsa_pkcs8.pem, rsa_cert.pem and rsa_public.pem are one key pair generated with openssl;
+// rsa_cert.pem allows keyEncipherment, rsa_cert_signing_only.pem only digitalSignature.
+
+func TestRSA_EncryptDecryptWithCertificateAndPKCS8(t *testing.T) {
+	identity, err := ParseRSAIdentity(readTestdata(t, "rsa_pkcs8.pem"))
+	require.NoError(t, err)
+
+	for _, name := range []string{"rsa_cert.pem", "rsa_public.pem"} {
+		recipient, err := ParseRSARecipient(readTestdata(t, name))
+		require.NoError(t, err, name)
+
+		plain := bytes.Repeat([]byte("pki wrapped backup "), 2000)
+		out, err := decryptWith(encryptTo(t, plain, recipient), identity)
+		require.NoError(t, err, name)
+		assert.Equal(t, plain, out, name)
+	}
+}
+
+func TestRSA_DERCertificateAndPKCS1PublicKey(t *testing.T) {
+	identity, err := ParseRSAIdentity(readTestdata(t, "rsa_pkcs8.pem"))
+	require.NoError(t, err)
+
+	block, _ := pem.Decode(readTestdata(t, "rsa_cert.pem"))
+	require.NotNil(t, block)
+	fromDER, err := ParseRSARecipient(block.Bytes)
+	require.NoError(t, err)
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&identity.priv.PublicKey)})
+	fromPKCS1, err := ParseRSARecipient(pkcs1)
+	require.NoError(t, err)
+
+	assert.Equal(t, identity.keyID, fromDER.keyID)
+	assert.Equal(t, identity.keyID, fromPKCS1.keyID)
+}
+
+func TestRSA_RejectsSigningOnlyCertificate(t *testing.T) {
+	_, err := ParseRSARecipient(readTestdata(t, "rsa_cert_signing_only.pem"))
+	require.ErrorContains(t, err, "not valid for key encipherment")
+}
+
+func TestRSA_RejectsUnsupportedInput(t *testing.T) {
+	_, err := ParseRSARecipient([]byte("garbage"))
+	require.Error(t, err)
+
+	_, err = ParseRSARecipient(readTestdata(t, "rsa_pkcs8.pem"))
+	require.ErrorContains(t, err, "unsupported PEM block type")
+
+	_, err = ParseRSAIdentity(readTestdata(t, "ssh_rsa_pem")) // PKCS#1, not PKCS#8
+	require.ErrorContains(t, err, "PKCS#8")
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	_, err = NewRSARecipient(&small.PublicKey)
+	require.ErrorContains(t, err, "RSA key too small")
+}
+
+func TestRSA_WrongKey(t *testing.T) {
+	recipient, err := ParseRSARecipient(readTestdata(t, "rsa_cert.pem"))
+	require.NoError(t, err)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherIdentity, err := NewRSAIdentity(other)
+	require.NoError(t, err)
+
+	_, err = decryptWith(encryptTo(t, []byte("secret"), recipient), otherIdentity)
+	require.ErrorContains(t, err, "no identity matched")
+}
+
+func TestRSA_AlongsidePassword(t *testing.T) {
+	recipient, err := ParseRSARecipient(readTestdata(t, "rsa_cert.pem"))
+	require.NoError(t, err)
+	identity, err := ParseRSAIdentity(readTestdata(t, "rsa_pkcs8.pem"))
+	require.NoError(t, err)
+	password := NewPasswordRecipient("break-glass")
+
+	encrypted := encryptTo(t, []byte("either works"), recipient, password)
+
+	for _, id := range []Identity{identity, password} {
+		out, err := decryptWith(encrypted, id)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("either works"), out)
+	}
+
+	_, err = decryptWith(encrypted, NewPasswordRecipient("wrong"))
+	require.ErrorContains(t, err, "no identity matched")
+}
@@ -0,0 +1,328 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// --- Shamir secret-sharing escrow ---
+//
+// ShamirRecipient splits the data key into N shares with threshold T (Shamir's scheme over
+// GF(2^8), byte by byte) and wraps every share for one custodian. All wrapped shares are
+// stored in a single "shamir" stanza:
+//
+//	escrowID(8) || threshold(1) || count(1) || (index(1) || stanza)...
+//
+// Custodians extract their share with ExtractShare, and any T shares passed to Recover give a
+// Crypter that decrypts the stream. The header MAC verifies the reconstructed data key.
+
+const (
+	ShamirStanzaType = "shamir"
+
+	escrowIDSize      = 8
+	shareStringPrefix = "streamcrypt-share:"
+)
+
+// ShamirRecipient escrows the data key across Custodians; any Threshold of them can recover it.
+type ShamirRecipient struct {
+	Threshold  int
+	Custodians []Recipient
+}
+
+var _ Recipient = &ShamirRecipient{}
+
+func NewShamirRecipient(threshold int, custodians ...Recipient) *ShamirRecipient {
+	return &ShamirRecipient{
+		Threshold:  threshold,
+		Custodians: custodians,
+	}
+}
+
+func (s *ShamirRecipient) Wrap(dataKey []byte) (*Stanza, error) {
+	n := len(s.Custodians)
+	if s.Threshold < 2 || s.Threshold > n || n > 255 {
+		return nil, fmt.Errorf("invalid threshold %d for %d custodians", s.Threshold, n)
+	}
+	shares, err := shamirSplit(dataKey, n, s.Threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, escrowIDSize, escrowIDSize+2)
+	if _, err := rand.Read(body); err != nil {
+		return nil, err
+	}
+	body = append(body, byte(s.Threshold), byte(n))
+	for i, custodian := range s.Custodians {
+		inner, err := custodian.Wrap(shares[i])
+		if err != nil {
+			return nil, fmt.Errorf("wrap share %d: %w", i+1, err)
+		}
+		body = append(body, byte(i+1))
+		if body, err = appendStanza(body, inner); err != nil {
+			return nil, err
+		}
+	}
+	return &Stanza{Type: ShamirStanzaType, Body: body}, nil
+}
+
+// Share is one custodian's share of a stream's data key.
+type Share struct {
+	EscrowID  []byte
+	Threshold int
+	Index     int
+	Value     []byte
+
+	destroyed bool
+}
+
+var (
+	_ crypt.Destroyer = &Share{}
+	_ crypt.Destroyer = &recoveredIdentity{}
+)
+
+// Destroy zeroes Value; Recover refuses the share afterwards.
+func (s *Share) Destroy() {
+	clear(s.Value)
+	s.Value, s.destroyed = nil, true
+}
+
+// ExtractShare returns the share addressed to identity from the stream header.
+func ExtractShare(h *Header, identity Identity) (*Share, error) {
+	for _, s := range h.Stanzas {
+		if s.Type != ShamirStanzaType {
+			continue
+		}
+		escrowID, threshold, wrapped, err := parseShamirStanza(s)
+		if err != nil {
+			return nil, err
+		}
+		for index, inner := range wrapped {
+			value, err := identity.Unwrap(inner)
+			if errors.Is(err, ErrIncorrectIdentity) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unwrap share %d: %w", index, err)
+			}
+			return &Share{EscrowID: escrowID, Threshold: threshold, Index: index, Value: value}, nil
+		}
+	}
+	return nil, errors.New("no share addressed to this identity")
+}
+
+// String encodes the share so it can be handed over for recovery; see ParseShare.
+func (s *Share) String() string {
+	raw := append([]byte(nil), s.EscrowID...)
+	raw = append(raw, byte(s.Threshold), byte(s.Index))
+	raw = append(raw, s.Value...)
+	return shareStringPrefix + base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// ParseShare parses a share encoded by Share.String.
+func ParseShare(str string) (*Share, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(str), shareStringPrefix)
+	if !ok {
+		return nil, errors.New("malformed share: missing prefix")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed share: %w", err)
+	}
+	if len(raw) != escrowIDSize+2+DataKeySize {
+		return nil, errors.New("malformed share: invalid length")
+	}
+	if raw[escrowIDSize] < 2 {
+		return nil, fmt.Errorf("malformed share: invalid threshold %d", raw[escrowIDSize])
+	}
+	return &Share{
+		EscrowID:  raw[:escrowIDSize],
+		Threshold: int(raw[escrowIDSize]),
+		Index:     int(raw[escrowIDSize+1]),
+		Value:     raw[escrowIDSize+2:],
+	}, nil
+}
+
+// Recover combines at least Threshold shares of the same stream into a Crypter that decrypts it.
+// The shares are checked against the stream's shamir stanza before they are combined.
+func Recover(shares ...*Share) (crypt.Crypter, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares given")
+	}
+	first := shares[0]
+	if first.Threshold < 2 || first.Threshold > 255 {
+		return nil, fmt.Errorf("invalid share threshold %d", first.Threshold)
+	}
+	seen := make(map[int]bool)
+	for _, s := range shares {
+		if s.destroyed {
+			return nil, crypt.ErrKeyDestroyed
+		}
+		if len(s.EscrowID) != escrowIDSize || !bytes.Equal(s.EscrowID, first.EscrowID) {
+			return nil, errors.New("shares belong to different streams")
+		}
+		if s.Threshold != first.Threshold {
+			return nil, fmt.Errorf("share %d has threshold %d, share %d has %d", s.Index, s.Threshold, first.Index, first.Threshold)
+		}
+		if s.Index < 1 || s.Index > 255 || len(s.Value) != DataKeySize {
+			return nil, fmt.Errorf("invalid share %d", s.Index)
+		}
+		if seen[s.Index] {
+			return nil, fmt.Errorf("duplicate share %d", s.Index)
+		}
+		seen[s.Index] = true
+	}
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("not enough shares: got %d, need %d", len(shares), first.Threshold)
+	}
+
+	r := &recoveredIdentity{escrowID: bytes.Clone(first.EscrowID), threshold: first.Threshold}
+	for _, s := range shares[:first.Threshold] {
+		r.shares = append(r.shares, &Share{EscrowID: r.escrowID, Threshold: s.Threshold, Index: s.Index, Value: bytes.Clone(s.Value)})
+	}
+	return NewDecrypter(r), nil
+}
+
+// recoveredIdentity unwraps the shamir stanza its shares were extracted from.
+type recoveredIdentity struct {
+	escrowID  []byte
+	threshold int
+	shares    []*Share
+}
+
+// Destroy zeroes the recovered shares; the identity cannot be used afterwards.
+func (r *recoveredIdentity) Destroy() {
+	for _, share := range r.shares {
+		share.Destroy()
+	}
+	r.shares = nil
+}
+
+func (r *recoveredIdentity) Unwrap(s *Stanza) ([]byte, error) {
+	if s.Type != ShamirStanzaType || len(s.Body) < escrowIDSize || !bytes.Equal(s.Body[:escrowIDSize], r.escrowID) {
+		return nil, ErrIncorrectIdentity
+	}
+	if r.shares == nil {
+		return nil, crypt.ErrKeyDestroyed
+	}
+	_, threshold, wrapped, err := parseShamirStanza(s)
+	if err != nil {
+		return nil, err
+	}
+	if threshold != r.threshold {
+		return nil, fmt.Errorf("shares have threshold %d, the stream needs %d", r.threshold, threshold)
+	}
+	for _, share := range r.shares {
+		if wrapped[share.Index] == nil {
+			return nil, fmt.Errorf("share %d is not part of the stream", share.Index)
+		}
+	}
+	// A fresh key each time: the caller wipes the data key once the stream is set up.
+	return shamirCombine(r.shares), nil
+}
+
+func parseShamirStanza(s *Stanza) (escrowID []byte, threshold int, wrapped map[int]*Stanza, err error) {
+	if len(s.Body) < escrowIDSize+2 {
+		return nil, 0, nil, errors.New("invalid shamir stanza size")
+	}
+	escrowID = s.Body[:escrowIDSize]
+	threshold = int(s.Body[escrowIDSize])
+	count := int(s.Body[escrowIDSize+1])
+	if threshold < 2 || threshold > count {
+		return nil, 0, nil, fmt.Errorf("invalid shamir threshold %d of %d", threshold, count)
+	}
+
+	r := bytes.NewReader(s.Body[escrowIDSize+2:])
+	wrapped = make(map[int]*Stanza, count)
+	for i := 0; i < count; i++ {
+		index, err := r.ReadByte()
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("read shamir share %d: %w", i, err)
+		}
+		inner, err := readStanza(r)
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("read shamir share %d: %w", i, err)
+		}
+		wrapped[int(index)] = inner
+	}
+	if r.Len() != 0 {
+		return nil, 0, nil, errors.New("trailing data in shamir stanza")
+	}
+	return escrowID, threshold, wrapped, nil
+}
+
+// --- Shamir's secret sharing over GF(2^8) ---
+
+// shamirSplit returns n shares of secret; share i is the polynomial evaluated at x = i+1.
+func shamirSplit(secret []byte, n, threshold int) ([][]byte, error) {
+	coefficients := make([]byte, threshold-1)
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+	for b, s := range secret {
+		if _, err := io.ReadFull(rand.Reader, coefficients); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i][b] = gfEval(s, coefficients, byte(i+1))
+		}
+	}
+	return shares, nil
+}
+
+// shamirCombine interpolates the shares at x = 0.
+func shamirCombine(shares []*Share) []byte {
+	secret := make([]byte, len(shares[0].Value))
+	for i, si := range shares {
+		// Lagrange basis polynomial of share i at x = 0: prod(x_j / (x_j - x_i)), j != i.
+		basis := byte(1)
+		for j, sj := range shares {
+			if i == j {
+				continue
+			}
+			basis = gfMul(basis, gfDiv(byte(sj.Index), byte(sj.Index)^byte(si.Index)))
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(si.Value[b], basis)
+		}
+	}
+	return secret
+}
+
+// gfEval evaluates intercept + c[0]*x + c[1]*x^2 + ... with Horner's method.
+func gfEval(intercept byte, coefficients []byte, x byte) byte {
+	y := byte(0)
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coefficients[i]
+	}
+	return gfMul(y, x) ^ intercept
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1, in constant time.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		carry := -(a >> 7)
+		a = (a << 1) ^ (carry & 0x1b)
+		b >>= 1
+	}
+	return p
+}
+
+// gfDiv returns a / b; b must not be zero. The inverse is b^254.
+func gfDiv(a, b byte) byte {
+	inv := byte(1)
+	for i := 0; i < 254; i++ {
+		inv = gfMul(inv, b)
+	}
+	return gfMul(a, inv)
+}
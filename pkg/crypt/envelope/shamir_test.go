@@ -0,0 +1,228 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func extractShares(t *testing.T, encrypted []byte, identities ...Identity) []*Share {
+	t.Helper()
+	h, err := ReadHeader(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	shares := make([]*Share, 0, len(identities))
+	for _, id := range identities {
+		share, err := ExtractShare(h, id)
+		require.NoError(t, err)
+		shares = append(shares, share)
+	}
+	return shares
+}
+
+func recoverAndDecrypt(encrypted []byte, shares ...*Share) ([]byte, error) {
+	c, err := Recover(shares...)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// The recovered crypter stays usable: decrypting wipes only its own copy of the data key.
+	r, err = c.Decrypt(bytes.NewReader(encrypted))
+	if err != nil {
+		return nil, err
+	}
+	again, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(plain, again) {
+		return nil, errors.Join(errors.New("second decryption differs"), err)
+	}
+	return plain, nil
+}
+
+func TestShamir_AnyThresholdSubsetRecovers(t *testing.T) {
+	custodians := []*testRecipient{
+		newTestRecipient(t, "c1"),
+		newTestRecipient(t, "c2"),
+		newTestRecipient(t, "c3"),
+		newTestRecipient(t, "c4"),
+		newTestRecipient(t, "c5"),
+	}
+	recipients := make([]Recipient, len(custodians))
+	for i, c := range custodians {
+		recipients[i] = c
+	}
+	plain := bytes.Repeat([]byte("escrowed "), 10000)
+	encrypted := encryptTo(t, plain, NewShamirRecipient(3, recipients...))
+
+	all := extractShares(t, encrypted, custodians[0], custodians[1], custodians[2], custodians[3], custodians[4])
+	for i := range all {
+		assert.Equal(t, i+1, all[i].Index)
+		assert.Equal(t, 3, all[i].Threshold)
+	}
+
+	for _, subset := range [][]int{{0, 1, 2}, {0, 2, 4}, {4, 3, 1}, {1, 2, 3, 4}} {
+		shares := make([]*Share, len(subset))
+		for i, idx := range subset {
+			shares[i] = all[idx]
+		}
+		got, err := recoverAndDecrypt(encrypted, shares...)
+		require.NoError(t, err, "subset %v", subset)
+		assert.Equal(t, plain, got)
+	}
+
+	_, err := recoverAndDecrypt(encrypted, all[0], all[1])
+	require.ErrorContains(t, err, "not enough shares")
+}
+
+func TestShamir_CustodianCannotDecryptAlone(t *testing.T) {
+	c1 := newTestRecipient(t, "c1")
+	c2 := newTestRecipient(t, "c2")
+	encrypted := encryptTo(t, []byte("data"), NewShamirRecipient(2, c1, c2))
+
+	_, err := decryptWith(encrypted, c1)
+	require.Error(t, err)
+
+	// A share posing as a 1-of-N share is refused before anything is combined.
+	share := extractShares(t, encrypted, c1)[0]
+	share.Threshold = 1
+	_, err = recoverAndDecrypt(encrypted, share)
+	require.ErrorContains(t, err, "invalid share threshold")
+}
+
+func TestShamir_InvalidShares(t *testing.T) {
+	c1 := newTestRecipient(t, "c1")
+	c2 := newTestRecipient(t, "c2")
+	c3 := newTestRecipient(t, "c3")
+	first := extractShares(t, encryptTo(t, []byte("a"), NewShamirRecipient(2, c1, c2, c3)), c1, c2)
+	second := extractShares(t, encryptTo(t, []byte("b"), NewShamirRecipient(2, c1, c2, c3)), c1, c2)
+
+	_, err := Recover(first[0], first[0])
+	require.ErrorContains(t, err, "duplicate share")
+
+	_, err = Recover(first[0], second[1])
+	require.ErrorContains(t, err, "different streams")
+
+	_, err = Recover()
+	require.Error(t, err)
+
+	_, err = ExtractShare(&Header{Stanzas: []*Stanza{{Type: ShamirStanzaType, Body: []byte{1, 2}}}}, c1)
+	require.Error(t, err)
+}
+
+func TestShamir_InvalidThreshold(t *testing.T) {
+	c1 := newTestRecipient(t, "c1")
+	c2 := newTestRecipient(t, "c2")
+	dataKey := make([]byte, DataKeySize)
+
+	for _, threshold := range []int{0, 1, 3} {
+		_, err := NewShamirRecipient(threshold, c1, c2).Wrap(dataKey)
+		require.Error(t, err, "threshold %d", threshold)
+	}
+
+	encrypted := encryptTo(t, []byte("data"), NewShamirRecipient(2, c1, c2))
+	shares := extractShares(t, encrypted, c1, c2)
+	for _, threshold := range []int{-1, 0, 1, 256} {
+		bad := *shares[0]
+		bad.Threshold = threshold
+		require.NotPanics(t, func() {
+			_, err := Recover(&bad, shares[1])
+			require.ErrorContains(t, err, "invalid share threshold", "threshold %d", threshold)
+		})
+	}
+
+	// Shares must agree on the threshold, and the threshold must be the stream's.
+	bad := *shares[1]
+	bad.Threshold = 3
+	_, err := Recover(shares[0], &bad)
+	require.ErrorContains(t, err, "has threshold")
+
+	lied := make([]*Share, 3)
+	for i := range lied {
+		s := *shares[i%2]
+		s.Threshold, s.Index = 3, i+1
+		lied[i] = &s
+	}
+	_, err = recoverAndDecrypt(encrypted, lied...)
+	require.ErrorContains(t, err, "the stream needs 2")
+
+	// A share with a different value length would otherwise be combined past its end.
+	short := *shares[1]
+	short.Value = short.Value[:8]
+	_, err = Recover(shares[0], &short)
+	require.ErrorContains(t, err, "invalid share")
+
+	zero := *shares[0]
+	zero.Threshold = 0
+	_, err = ParseShare(zero.String())
+	require.ErrorContains(t, err, "invalid threshold")
+
+	// A stanza claiming more shares are needed than it holds is rejected.
+	h, err := ReadHeader(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+	for _, st := range h.Stanzas {
+		if st.Type == ShamirStanzaType {
+			st.Body[escrowIDSize] = 3
+		}
+	}
+	_, err = ExtractShare(h, c1)
+	require.ErrorContains(t, err, "invalid shamir threshold")
+}
+
+func TestShamir_ShareString(t *testing.T) {
+	c1 := newTestRecipient(t, "c1")
+	c2 := newTestRecipient(t, "c2")
+	plain := []byte("handed over as text")
+	encrypted := encryptTo(t, plain, NewShamirRecipient(2, c1, c2))
+	shares := extractShares(t, encrypted, c1, c2)
+
+	parsed := make([]*Share, len(shares))
+	for i, s := range shares {
+		var err error
+		parsed[i], err = ParseShare(s.String())
+		require.NoError(t, err)
+		assert.Equal(t, s, parsed[i])
+	}
+	got, err := recoverAndDecrypt(encrypted, parsed...)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+
+	_, err = ParseShare("streamcrypt-share:AAAA")
+	require.Error(t, err)
+	_, err = ParseShare("something-else")
+	require.Error(t, err)
+}
+
+func TestShamir_RealCustodians(t *testing.T) {
+	hybrid, err := GenerateHybridIdentity()
+	require.NoError(t, err)
+	password := NewPasswordRecipient("custodian password")
+	plain := []byte("mixed custodians")
+
+	encrypted := encryptTo(t, plain,
+		NewShamirRecipient(2, hybrid.Recipient(), password),
+		newTestRecipient(t, "owner"),
+	)
+	shares := extractShares(t, encrypted, hybrid, password)
+	got, err := recoverAndDecrypt(encrypted, shares...)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+}
+
+func TestGF256(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		assert.Equal(t, byte(1), gfDiv(byte(a), byte(a)))
+		assert.Equal(t, byte(a), gfMul(byte(a), 1))
+	}
+	// 0x53 * 0xca = 0x01 in the AES field (FIPS-197 section 4.2).
+	assert.Equal(t, byte(0x01), gfMul(0x53, 0xca))
+	assert.Equal(t, byte(0xc1), gfMul(0x57, 0x83))
+}
@@ -0,0 +1,303 @@
+package envelope
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// --- SSH recipients ---
+//
+// ssh-ed25519 keys are converted to X25519 and used for an ephemeral-static key agreement;
+// ssh-rsa keys wrap the data key with RSA-OAEP-SHA256. Every stanza starts with a 4-byte
+// tag derived from the SSH public key, so identities can skip stanzas meant for other keys.
+
+const (
+	SSHEd25519StanzaType = "ssh-ed25519"
+	SSHRSAStanzaType     = "ssh-rsa"
+
+	sshTagSize        = 4
+	sshEd25519Info    = "streamcrypt ssh-ed25519 wrap"
+	sshRSALabel       = "streamcrypt ssh-rsa wrap"
+	minSSHRSAKeyBits  = 2048
+	sshEd25519BodyLen = sshTagSize + x25519Size + DataKeySize + 16
+)
+
+// ParseSSHRecipient parses a public key in authorized_keys format (ssh-ed25519 or ssh-rsa).
+func ParseSSHRecipient(authorizedKey string) (Recipient, error) {
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return nil, fmt.Errorf("malformed SSH recipient: %w", err)
+	}
+	return NewSSHRecipient(pk)
+}
+
+// NewSSHRecipient returns a recipient for an ssh-ed25519 or ssh-rsa public key.
+func NewSSHRecipient(pk ssh.PublicKey) (Recipient, error) {
+	cpk, ok := pk.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported SSH key type: %s", pk.Type())
+	}
+	switch key := cpk.CryptoPublicKey().(type) {
+	case ed25519.PublicKey:
+		x, err := ed25519PublicKeyToX25519(key)
+		if err != nil {
+			return nil, err
+		}
+		return &SSHEd25519Recipient{sshKey: pk, x25519: x}, nil
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minSSHRSAKeyBits {
+			return nil, fmt.Errorf("RSA key too small: %d bits", key.N.BitLen())
+		}
+		return &SSHRSARecipient{sshKey: pk, rsa: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported SSH key type: %s", pk.Type())
+	}
+}
+
+// ParseSSHIdentity parses an unencrypted OpenSSH, PKCS#1 or PKCS#8 private key file.
+// For passphrase-protected keys it returns an *ssh.PassphraseMissingError; use ParseSSHIdentityWithPassphrase.
+func ParseSSHIdentity(pemBytes []byte) (Identity, error) {
+	key, err := ssh.ParseRawPrivateKey(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	return newSSHIdentity(key)
+}
+
+// ParseSSHIdentityWithPassphrase parses a passphrase-protected private key file.
+func ParseSSHIdentityWithPassphrase(pemBytes, passphrase []byte) (Identity, error) {
+	key, err := ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return newSSHIdentity(key)
+}
+
+func newSSHIdentity(key any) (Identity, error) {
+	switch k := key.(type) {
+	case *ed25519.PrivateKey:
+		defer clear(*k)
+		return newSSHEd25519Identity(*k)
+	case ed25519.PrivateKey:
+		defer clear(k)
+		return newSSHEd25519Identity(k)
+	case *rsa.PrivateKey:
+		pk, err := ssh.NewPublicKey(&k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return &SSHRSAIdentity{sshKey: pk, rsa: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported SSH private key type: %T", key)
+	}
+}
+
+func sshTag(pk ssh.PublicKey) []byte {
+	sum := sha256.Sum256(pk.Marshal())
+	return sum[:sshTagSize]
+}
+
+// --- ssh-ed25519 ---
+
+type SSHEd25519Recipient struct {
+	sshKey ssh.PublicKey
+	x25519 *ecdh.PublicKey
+}
+
+var _ Recipient = &SSHEd25519Recipient{}
+
+func (r *SSHEd25519Recipient) Wrap(dataKey []byte) (*Stanza, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(r.x25519)
+	if err != nil {
+		return nil, err
+	}
+	share := ephemeral.PublicKey().Bytes()
+
+	aead, err := newWrapAEAD(shared, sshEd25519Salt(share, r.x25519, r.sshKey), sshEd25519Info)
+	if err != nil {
+		return nil, err
+	}
+	body := append(sshTag(r.sshKey), share...)
+	body = aead.Seal(body, make([]byte, aead.NonceSize()), dataKey, nil)
+	return &Stanza{Type: SSHEd25519StanzaType, Body: body}, nil
+}
+
+// SSHEd25519Identity holds the X25519 key derived from an Ed25519 private key; the parsed
+// Ed25519 key itself is zeroed once the X25519 key is derived.
+type SSHEd25519Identity struct {
+	sshKey ssh.PublicKey
+	x25519 *ecdh.PrivateKey
+}
+
+var (
+	_ Identity        = &SSHEd25519Identity{}
+	_ crypt.Destroyer = &SSHEd25519Identity{}
+)
+
+func newSSHEd25519Identity(key ed25519.PrivateKey) (*SSHEd25519Identity, error) {
+	pk, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	// The X25519 scalar is the clamped first half of SHA-512(seed), as in Ed25519 signing.
+	seed := key.Seed()
+	defer clear(seed)
+	h := sha512.Sum512(seed)
+	defer clear(h[:])
+	x, err := ecdh.X25519().NewPrivateKey(h[:32])
+	if err != nil {
+		return nil, err
+	}
+	return &SSHEd25519Identity{sshKey: pk, x25519: x}, nil
+}
+
+// Destroy drops the X25519 key; the identity cannot be used afterwards. The key lives in the
+// standard library and is left to the garbage collector.
+func (i *SSHEd25519Identity) Destroy() {
+	i.x25519 = nil
+}
+
+func (i *SSHEd25519Identity) Unwrap(s *Stanza) ([]byte, error) {
+	if s.Type != SSHEd25519StanzaType {
+		return nil, ErrIncorrectIdentity
+	}
+	if i.x25519 == nil {
+		return nil, crypt.ErrKeyDestroyed
+	}
+	if len(s.Body) != sshEd25519BodyLen {
+		return nil, errors.New("invalid ssh-ed25519 stanza size")
+	}
+	tag, share, sealed := s.Body[:sshTagSize], s.Body[sshTagSize:sshTagSize+x25519Size], s.Body[sshTagSize+x25519Size:]
+	if string(tag) != string(sshTag(i.sshKey)) {
+		return nil, ErrIncorrectIdentity
+	}
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(share)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := i.x25519.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newWrapAEAD(shared, sshEd25519Salt(share, i.x25519.PublicKey(), i.sshKey), sshEd25519Info)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), sealed, nil)
+	if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+	return dataKey, nil
+}
+
+func sshEd25519Salt(share []byte, recipient *ecdh.PublicKey, sshKey ssh.PublicKey) []byte {
+	salt := append([]byte(nil), share...)
+	salt = append(salt, recipient.Bytes()...)
+	return append(salt, sshKey.Marshal()...)
+}
+
+// ed25519PublicKeyToX25519 maps an Edwards point to its Montgomery u-coordinate: u = (1 + y) / (1 - y).
+func ed25519PublicKeyToX25519(pk ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(pk) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 public key size")
+	}
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+	le := append([]byte(nil), pk...)
+	le[31] &= 0x7f // drop the sign bit of x
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(p) >= 0 {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, p)
+	if den.Sign() == 0 {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+	u := new(big.Int).Add(big.NewInt(1), y)
+	u.Mul(u, den.ModInverse(den, p))
+	u.Mod(u, p)
+
+	out := make([]byte, x25519Size)
+	u.FillBytes(out)
+	return ecdh.X25519().NewPublicKey(reverse(out))
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// --- ssh-rsa ---
+
+type SSHRSARecipient struct {
+	sshKey ssh.PublicKey
+	rsa    *rsa.PublicKey
+}
+
+var _ Recipient = &SSHRSARecipient{}
+
+func (r *SSHRSARecipient) Wrap(dataKey []byte) (*Stanza, error) {
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, r.rsa, dataKey, []byte(sshRSALabel))
+	if err != nil {
+		return nil, err
+	}
+	return &Stanza{Type: SSHRSAStanzaType, Body: append(sshTag(r.sshKey), wrapped...)}, nil
+}
+
+type SSHRSAIdentity struct {
+	sshKey ssh.PublicKey
+	rsa    *rsa.PrivateKey
+}
+
+var (
+	_ Identity        = &SSHRSAIdentity{}
+	_ crypt.Destroyer = &SSHRSAIdentity{}
+)
+
+// Destroy zeroes the private key; the identity cannot be used afterwards.
+func (i *SSHRSAIdentity) Destroy() {
+	if i.rsa != nil {
+		wipeRSAKey(i.rsa)
+	}
+	i.rsa = nil
+}
+
+func (i *SSHRSAIdentity) Unwrap(s *Stanza) ([]byte, error) {
+	if s.Type != SSHRSAStanzaType {
+		return nil, ErrIncorrectIdentity
+	}
+	if i.rsa == nil {
+		return nil, crypt.ErrKeyDestroyed
+	}
+	if len(s.Body) <= sshTagSize {
+		return nil, errors.New("invalid ssh-rsa stanza size")
+	}
+	if string(s.Body[:sshTagSize]) != string(sshTag(i.sshKey)) {
+		return nil, ErrIncorrectIdentity
+	}
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), nil, i.rsa, s.Body[sshTagSize:], []byte(sshRSALabel))
+	if err != nil {
+		return nil, ErrIncorrectIdentity
+	}
+	return dataKey, nil
+}
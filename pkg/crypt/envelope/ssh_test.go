@@ -0,0 +1,143 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// The key files in testdata were generated with ssh-keygen; the protected ones use this passphrase.
+const testPassphrase = "correct horse battery staple"
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return data
+}
+
+func TestSSH_EncryptDecryptWithOpenSSHKeys(t *testing.T) {
+	cases := []struct {
+		name       string
+		passphrase string
+	}{
+		{"ssh_ed25519", ""},
+		{"ssh_ed25519_passphrase", testPassphrase},
+		{"ssh_rsa_passphrase", testPassphrase},
+		{"ssh_rsa_pem", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			recipient, err := ParseSSHRecipient(string(readTestdata(t, tc.name+".pub")))
+			require.NoError(t, err)
+
+			var identity Identity
+			if tc.passphrase == "" {
+				identity, err = ParseSSHIdentity(readTestdata(t, tc.name))
+			} else {
+				identity, err = ParseSSHIdentityWithPassphrase(readTestdata(t, tc.name), []byte(tc.passphrase))
+			}
+			require.NoError(t, err)
+
+			plain := bytes.Repeat([]byte("restore bundle "), 3000)
+			out, err := decryptWith(encryptTo(t, plain, recipient), identity)
+			require.NoError(t, err)
+			assert.Equal(t, plain, out)
+		})
+	}
+}
+
+func TestSSH_PassphraseHandling(t *testing.T) {
+	_, err := ParseSSHIdentity(readTestdata(t, "ssh_ed25519_passphrase"))
+	var missing *ssh.PassphraseMissingError
+	require.ErrorAs(t, err, &missing)
+
+	_, err = ParseSSHIdentityWithPassphrase(readTestdata(t, "ssh_ed25519_passphrase"), []byte("wrong"))
+	require.Error(t, err)
+}
+
+func TestSSH_MixedRecipients(t *testing.T) {
+	ed, err := ParseSSHRecipient(string(readTestdata(t, "ssh_ed25519.pub")))
+	require.NoError(t, err)
+	rsaRecipient, err := ParseSSHRecipient(string(readTestdata(t, "ssh_rsa_pem.pub")))
+	require.NoError(t, err)
+	encrypted := encryptTo(t, []byte("for both engineers"), rsaRecipient, ed)
+
+	edIdentity, err := ParseSSHIdentity(readTestdata(t, "ssh_ed25519"))
+	require.NoError(t, err)
+	rsaIdentity, err := ParseSSHIdentity(readTestdata(t, "ssh_rsa_pem"))
+	require.NoError(t, err)
+
+	for _, id := range []Identity{edIdentity, rsaIdentity} {
+		out, err := decryptWith(encrypted, id)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("for both engineers"), out)
+	}
+
+	// A different ed25519 key must not match: its tag differs.
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherIdentity, err := newSSHIdentity(other)
+	require.NoError(t, err)
+	_, err = decryptWith(encrypted, otherIdentity)
+	require.ErrorContains(t, err, "no identity matched")
+}
+
+func TestSSH_Ed25519ToX25519Conversion(t *testing.T) {
+	// The converted public key must match the public key of the converted private key.
+	for i := 0; i < 20; i++ {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		x, err := ed25519PublicKeyToX25519(pub)
+		require.NoError(t, err)
+		id, err := newSSHEd25519Identity(priv)
+		require.NoError(t, err)
+		assert.Equal(t, id.x25519.PublicKey().Bytes(), x.Bytes())
+	}
+}
+
+func TestSSH_RejectsUnsupportedKeys(t *testing.T) {
+	_, err := ParseSSHRecipient("not a key")
+	require.Error(t, err)
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	pk, err := ssh.NewPublicKey(&small.PublicKey)
+	require.NoError(t, err)
+	_, err = NewSSHRecipient(pk)
+	require.ErrorContains(t, err, "RSA key too small")
+
+	_, err = ParseSSHIdentity([]byte("-----BEGIN NOTHING-----\n-----END NOTHING-----\n"))
+	require.Error(t, err)
+}
+
+func TestSSH_GeneratedKeyWithPassphrase(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "generated", []byte("s3cret"))
+	require.NoError(t, err)
+	pk, err := ssh.NewPublicKey(priv.Public())
+	require.NoError(t, err)
+
+	recipient, err := ParseSSHRecipient(string(ssh.MarshalAuthorizedKey(pk)))
+	require.NoError(t, err)
+	identity, err := ParseSSHIdentityWithPassphrase(pem.EncodeToMemory(block), []byte("s3cret"))
+	require.NoError(t, err)
+
+	r, err := NewDecrypter(identity).Decrypt(bytes.NewReader(encryptTo(t, []byte("x"), recipient)))
+	require.NoError(t, err)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("x"), out)
+}
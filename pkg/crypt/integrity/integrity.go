@@ -0,0 +1,238 @@
+// Package integrity frames a stream like the AEAD crypters do, but leaves the data in the clear:
+// every chunk carries a tag over the stream header, its counter, its final flag and its data,
+// so corruption, reordering and truncation are detected without encryption.
+//
+// HMAC-SHA256 tags also detect tampering by anyone who does not hold the key. CRC-32C and
+// XXH64 tags are unkeyed and only detect accidental corruption.
+//
+//	"INTv1\x00" || algorithm(1) || stream ID(16) || (nonce(12) || data || tag)...
+package integrity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- Constants ---
+
+const (
+	headerPrefix = "INTv1\x00"
+	streamIDSize = 16
+	headerSize   = len(headerPrefix) + 1 + streamIDSize
+	minKeySize   = 16
+)
+
+// Algorithm selects the per-chunk tag.
+type Algorithm byte
+
+const (
+	HMACSHA256 Algorithm = iota + 1
+	CRC32C
+	XXH64
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case HMACSHA256:
+		return "hmac-sha256"
+	case CRC32C:
+		return "crc32c"
+	case XXH64:
+		return "xxh64"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(a))
+	}
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// --- Crypter ---
+
+// Crypter adds per-chunk integrity tags; Key is required for HMACSHA256 only.
+type Crypter struct {
+	Algorithm Algorithm
+	Key       []byte
+
+	// SecretKey, when set, is used instead of Key.
+	SecretKey *crypt.SecretKey
+}
+
+var (
+	_ crypt.Crypter    = &Crypter{}
+	_ crypt.Destroyer  = &Crypter{}
+	_ policy.Describer = &Crypter{}
+)
+
+// NewHMACSHA256Crypter copies key into a crypt.SecretKey, so the caller can wipe key afterwards.
+func NewHMACSHA256Crypter(key []byte) crypt.Crypter {
+	return &Crypter{
+		Algorithm: HMACSHA256,
+		SecretKey: crypt.NewSecretKey(key),
+	}
+}
+
+func NewCRC32CCrypter() crypt.Crypter {
+	return &Crypter{
+		Algorithm: CRC32C,
+	}
+}
+
+func NewXXH64Crypter() crypt.Crypter {
+	return &Crypter{
+		Algorithm: XXH64,
+	}
+}
+
+func (c *Crypter) Destroy() {
+	if c.SecretKey == nil {
+		c.SecretKey = &crypt.SecretKey{}
+	}
+	c.SecretKey.Destroy()
+	clear(c.Key)
+	c.Key = nil
+}
+
+func (c *Crypter) FileExtension() string {
+	return "." + c.Algorithm.String()
+}
+
+func (c *Crypter) Name() string {
+	return "integrity-" + c.Algorithm.String()
+}
+
+func (c *Crypter) Algorithms() []policy.Algorithm {
+	switch c.Algorithm {
+	case HMACSHA256:
+		keyBits := len(c.Key) * 8
+		if c.SecretKey != nil {
+			keyBits = c.SecretKey.Len() * 8
+		}
+		return []policy.Algorithm{{Kind: policy.MAC, Name: c.Algorithm.String(), KeyBits: keyBits}}
+	default:
+		return []policy.Algorithm{{Kind: policy.Checksum, Name: c.Algorithm.String()}}
+	}
+}
+
+func (c *Crypter) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	streamID, err := aesgcm.GenerateRandomNBytes(streamIDSize)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte(headerPrefix), byte(c.Algorithm))
+	header = append(header, streamID...)
+
+	tagger, err := c.newTagger(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return chunked.NewFinalWriter(w, tagger), nil
+}
+
+func (c *Crypter) Decrypt(r io.Reader) (io.Reader, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(headerPrefix)]) != headerPrefix {
+		return nil, errors.New("invalid file header")
+	}
+	// Never let the stream pick a weaker tag than the one configured.
+	if got := Algorithm(header[len(headerPrefix)]); got != c.Algorithm {
+		return nil, fmt.Errorf("integrity algorithm mismatch: stream uses %s, want %s", got, c.Algorithm)
+	}
+
+	tagger, err := c.newTagger(header)
+	if err != nil {
+		return nil, err
+	}
+	return chunked.NewFinalReader(r, tagger), nil
+}
+
+func (c *Crypter) newTagger(header []byte) (*tagger, error) {
+	var h hash.Hash
+	switch c.Algorithm {
+	case HMACSHA256:
+		// The HMAC keeps its own padded copy of the key, so the key is only needed here.
+		err := crypt.UseKey(c.SecretKey, c.Key, func(key []byte) error {
+			if len(key) < minKeySize {
+				return fmt.Errorf("hmac key too short: got %d bytes, want at least %d", len(key), minKeySize)
+			}
+			h = hmac.New(sha256.New, key)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	case CRC32C:
+		h = crc32.New(castagnoli)
+	case XXH64:
+		h = xxhash.New()
+	default:
+		return nil, fmt.Errorf("unsupported integrity algorithm: %s", c.Algorithm)
+	}
+	return &tagger{header: header, hash: h}, nil
+}
+
+// --- Tagger ---
+
+// tagger implements cipher.AEAD without encryption, so the chunk framing can be reused:
+// Seal appends a tag over the header, nonce and data, and Open checks and strips it.
+type tagger struct {
+	header []byte
+	hash   hash.Hash // reset for every chunk; streams are sealed and opened sequentially
+}
+
+func (t *tagger) NonceSize() int { return chunked.NonceSize }
+
+func (t *tagger) Overhead() int { return t.hash.Size() }
+
+func (t *tagger) tag(nonce, data, additionalData []byte) []byte {
+	h := t.hash
+	h.Reset()
+	h.Write(t.header)
+	h.Write(nonce)
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(len(additionalData))))
+	h.Write(additionalData)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func (t *tagger) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	tag := t.tag(nonce, plaintext, additionalData)
+	dst = append(dst, plaintext...)
+	return append(dst, tag...)
+}
+
+func (t *tagger) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	size := t.hash.Size()
+	if len(ciphertext) < size {
+		return nil, errors.New("integrity check failed")
+	}
+	data, tag := ciphertext[:len(ciphertext)-size], ciphertext[len(ciphertext)-size:]
+	if !hmac.Equal(tag, t.tag(nonce, data, additionalData)) {
+		return nil, errors.New("integrity check failed")
+	}
+	return append(dst, data...), nil
+}
@@ -0,0 +1,127 @@
+package integrity
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+	"github.com/hashmap-kz/streamcrypt/pkg/pipe"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func allCrypters() []crypt.Crypter {
+	return []crypt.Crypter{NewHMACSHA256Crypter(testKey), NewCRC32CCrypter(), NewXXH64Crypter()}
+}
+
+func seal(t *testing.T, c crypt.Crypter, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := c.Encrypt(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func open(c crypt.Crypter, sealed []byte) ([]byte, error) {
+	r, err := c.Decrypt(bytes.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestIntegrity_RoundtripKeepsDataInClear(t *testing.T) {
+	plain := bytes.Repeat([]byte("public dataset "), 10000)
+	for _, c := range allCrypters() {
+		sealed := seal(t, c, plain)
+		assert.True(t, bytes.Contains(sealed, plain[:1000]), "%s must not encrypt", c.Name())
+
+		got, err := open(c, sealed)
+		require.NoError(t, err, c.Name())
+		assert.Equal(t, plain, got)
+	}
+}
+
+func TestIntegrity_DetectsCorruptionReorderingAndTruncation(t *testing.T) {
+	plain := bytes.Repeat([]byte{7}, 3*chunked.ChunkSize+100)
+	for _, c := range allCrypters() {
+		sealed := seal(t, c, plain)
+		tagSize := len(sealed) - headerSize - len(plain) - 4*chunked.NonceSize
+		frame := chunked.NonceSize + chunked.ChunkSize + tagSize/4
+
+		flipped := bytes.Clone(sealed)
+		flipped[headerSize+frame+500] ^= 1
+		_, err := open(c, flipped)
+		require.Error(t, err, c.Name())
+
+		body := sealed[headerSize:]
+		swapped := append(bytes.Clone(sealed[:headerSize]), body[frame:2*frame]...)
+		swapped = append(swapped, body[:frame]...)
+		swapped = append(swapped, body[2*frame:]...)
+		_, err = open(c, swapped)
+		require.Error(t, err, c.Name())
+
+		_, err = open(c, sealed[:headerSize+2*frame])
+		require.ErrorIs(t, err, chunked.ErrTruncated, c.Name())
+
+		// Chunks of another stream carry another stream ID.
+		other := seal(t, c, plain)
+		spliced := append(bytes.Clone(sealed[:headerSize+frame]), other[headerSize+frame:]...)
+		_, err = open(c, spliced)
+		require.Error(t, err, c.Name())
+	}
+}
+
+func TestIntegrity_HMACNeedsTheKey(t *testing.T) {
+	sealed := seal(t, NewHMACSHA256Crypter(testKey), []byte("signed"))
+	_, err := open(NewHMACSHA256Crypter(bytes.Repeat([]byte{1}, 32)), sealed)
+	require.Error(t, err)
+
+	_, err = NewHMACSHA256Crypter([]byte("short")).Encrypt(io.Discard)
+	require.ErrorContains(t, err, "hmac key too short")
+
+	c := NewHMACSHA256Crypter(testKey).(*Crypter)
+	c.Destroy()
+	_, err = open(c, sealed)
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+
+	c = &Crypter{Algorithm: HMACSHA256, Key: bytes.Clone(testKey)}
+	c.Destroy()
+	_, err = open(c, sealed)
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+}
+
+func TestIntegrity_RejectsAlgorithmDowngrade(t *testing.T) {
+	sealed := seal(t, NewCRC32CCrypter(), []byte("data"))
+	_, err := open(NewHMACSHA256Crypter(testKey), sealed)
+	require.ErrorContains(t, err, "stream uses crc32c, want hmac-sha256")
+
+	_, err = open(NewCRC32CCrypter(), []byte("not a stream at all, really"))
+	require.ErrorContains(t, err, "invalid file header")
+}
+
+func TestIntegrity_WithPipe(t *testing.T) {
+	plain := bytes.Repeat([]byte("compress me "), 50000)
+	c := NewXXH64Crypter()
+	assert.Equal(t, ".xxh64", c.FileExtension())
+
+	r, err := pipe.CompressAndEncryptOptional(bytes.NewReader(plain), &codec.GzipCompressor{}, c)
+	require.NoError(t, err)
+	sealed, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	dr, err := pipe.DecryptAndDecompressOptional(bytes.NewReader(sealed), c, &codec.GzipDecompressor{})
+	require.NoError(t, err)
+	got, err := io.ReadAll(dr)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+}
@@ -0,0 +1,434 @@
+// Package chunked implements the chunk framing shared by the AEAD crypters:
+// the plaintext is split into fixed-size chunks, and every chunk is sealed
+// independently and written as nonce || ciphertext || tag.
+//
+// The nonce is a zero byte, the big-endian append epoch (2 bytes), the final
+// flag and the big-endian chunk counter (8 bytes). Streams written by
+// NewFinalWriter set the final flag of the last chunk, so NewFinalReader
+// detects truncation, reordering and trailing data.
+//
+// The epoch is zero until a stream is appended to: ResumeWriter seals the
+// cut-off partial chunk again under the next epoch, so no nonce is reused.
+//
+// Streams written by NewFlushWriter prefix every frame with its big-endian
+// length (4 bytes), so Flush can seal a short chunk in the middle of a stream.
+package chunked
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	ChunkSize = 64 * 1024
+	NonceSize = 12 // Every supported AEAD uses a 12-byte (96-bit) nonce.
+
+	MaxEpoch = 1<<16 - 1
+
+	finalFlagIndex   = 3
+	lengthPrefixSize = 4
+)
+
+var (
+	ErrTruncated    = errors.New("truncated stream: missing final chunk")
+	ErrTrailingData = errors.New("unexpected data after final chunk")
+	errDecryption   = errors.New("decryption failed: tampering or corruption detected")
+)
+
+// ChunkNonce returns the nonce of chunk n.
+func ChunkNonce(n uint64, final bool) []byte {
+	return EpochNonce(0, n, final)
+}
+
+// EpochNonce returns the nonce of chunk n sealed in the given append epoch.
+func EpochNonce(epoch uint16, n uint64, final bool) []byte {
+	nonce := make([]byte, NonceSize)
+	binary.BigEndian.PutUint16(nonce[1:], epoch)
+	if final {
+		nonce[finalFlagIndex] = 1
+	}
+	binary.BigEndian.PutUint64(nonce[4:], n)
+	return nonce
+}
+
+// parseNonce splits a chunk nonce; ok is false if it cannot be one.
+func parseNonce(nonce []byte) (epoch uint16, n uint64, final, ok bool) {
+	if len(nonce) != NonceSize || nonce[0] != 0 || nonce[finalFlagIndex] > 1 {
+		return 0, 0, false, false
+	}
+	epoch = binary.BigEndian.Uint16(nonce[1:])
+	return epoch, binary.BigEndian.Uint64(nonce[4:]), nonce[finalFlagIndex] == 1, true
+}
+
+// OpenChunk authenticates a single frame that must hold chunk n, and returns
+// its plaintext and the epoch it was sealed in.
+func OpenChunk(aead cipher.AEAD, frame []byte, n uint64, final bool) ([]byte, uint16, error) {
+	if len(frame) < NonceSize+aead.Overhead() || len(frame) > NonceSize+ChunkSize+aead.Overhead() {
+		return nil, 0, errDecryption
+	}
+	nonce := frame[:NonceSize]
+	epoch, num, isFinal, ok := parseNonce(nonce)
+	if !ok || num != n || isFinal != final {
+		return nil, 0, errDecryption
+	}
+	plaintext, err := aead.Open(nil, nonce, frame[NonceSize:], nil)
+	if err != nil {
+		return nil, 0, errDecryption
+	}
+	return plaintext, epoch, nil
+}
+
+// NewWriter returns a writer that seals everything written to it with aead
+// and writes the framed chunks to w. Close must be called to flush the final chunk.
+func NewWriter(w io.Writer, aead cipher.AEAD) io.WriteCloser {
+	return &chunkedWriter{
+		aead:     aead,
+		w:        w,
+		buf:      make([]byte, 0, ChunkSize),
+		chunkNum: 0,
+	}
+}
+
+// NewFinalWriter is like NewWriter, but marks the last chunk as final.
+// Close always writes the final chunk, even when it is empty.
+func NewFinalWriter(w io.Writer, aead cipher.AEAD) io.WriteCloser {
+	return &chunkedWriter{
+		aead:      aead,
+		w:         w,
+		buf:       make([]byte, 0, ChunkSize),
+		chunkNum:  0,
+		markFinal: true,
+	}
+}
+
+// NewFlushWriter is like NewFinalWriter, but frames are length-prefixed and
+// Flush seals the buffered data as a short chunk right away.
+func NewFlushWriter(w io.Writer, aead cipher.AEAD) FlushWriteCloser {
+	return &flushWriter{&chunkedWriter{
+		aead:         aead,
+		w:            w,
+		buf:          make([]byte, 0, ChunkSize),
+		markFinal:    true,
+		lengthPrefix: true,
+	}}
+}
+
+// FlushWriteCloser is the writer returned by NewFlushWriter.
+type FlushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Framing selects the layout of a resumed stream.
+type Framing int
+
+const (
+	Fixed      Framing = iota // NewWriter
+	FixedFinal                // NewFinalWriter
+	Flushable                 // NewFlushWriter
+)
+
+// Resume describes where a resumed writer picks up an existing stream.
+type Resume struct {
+	Epoch    uint16 // epoch of the chunks sealed from now on
+	ChunkNum uint64 // number of chunks kept in place
+	Pending  []byte // plaintext of the cut-off partial chunk, sealed again first
+}
+
+// ResumeWriter continues a stream of the given framing after its first res.ChunkNum
+// chunks; w must be positioned right after them. If Pending is not empty, Epoch must be
+// newer than the epoch of the chunk it came from. Flushable writers implement FlushWriteCloser.
+func ResumeWriter(w io.Writer, aead cipher.AEAD, framing Framing, res Resume) io.WriteCloser {
+	buf := make([]byte, 0, ChunkSize)
+	g := &chunkedWriter{
+		aead:         aead,
+		w:            w,
+		buf:          append(buf, res.Pending...),
+		chunkNum:     res.ChunkNum,
+		epoch:        res.Epoch,
+		markFinal:    framing != Fixed,
+		lengthPrefix: framing == Flushable,
+	}
+	if framing == Flushable {
+		return &flushWriter{g}
+	}
+	return g
+}
+
+type chunkedWriter struct {
+	aead         cipher.AEAD
+	w            io.Writer
+	buf          []byte
+	chunkNum     uint64
+	epoch        uint16
+	markFinal    bool
+	lengthPrefix bool
+	closed       bool
+}
+
+type flushWriter struct {
+	*chunkedWriter
+}
+
+// Flush seals the buffered data, if any, as a non-final chunk and flushes w if it can.
+func (g *flushWriter) Flush() error {
+	if g.closed {
+		return nil
+	}
+	if len(g.buf) > 0 {
+		if err := g.flush(false); err != nil {
+			return err
+		}
+	}
+	if f, ok := g.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (g *chunkedWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		space := ChunkSize - len(g.buf)
+		if space > len(p) {
+			space = len(p)
+		}
+		g.buf = append(g.buf, p[:space]...)
+		p = p[space:]
+		total += space
+
+		if len(g.buf) == ChunkSize {
+			if err := g.flush(false); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+func (g *chunkedWriter) Close() error {
+	if g.closed {
+		return nil
+	}
+	g.closed = true
+	if len(g.buf) > 0 || g.markFinal {
+		if err := g.flush(g.markFinal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *chunkedWriter) flush(final bool) error {
+	nonce := EpochNonce(g.epoch, g.chunkNum, final)
+	ciphertext := g.aead.Seal(nil, nonce, g.buf, nil)
+
+	if g.lengthPrefix {
+		var length [lengthPrefixSize]byte
+		binary.BigEndian.PutUint32(length[:], uint32(NonceSize+len(ciphertext)))
+		if _, err := g.w.Write(length[:]); err != nil {
+			return err
+		}
+	}
+	if _, err := g.w.Write(nonce); err != nil {
+		return err
+	}
+	if _, err := g.w.Write(ciphertext); err != nil {
+		return err
+	}
+
+	g.chunkNum++
+	g.buf = g.buf[:0]
+	return nil
+}
+
+// NewReader returns a reader that opens the framed chunks read from r with aead.
+func NewReader(r io.Reader, aead cipher.AEAD) io.Reader {
+	return &chunkedReader{
+		aead:     aead,
+		r:        r,
+		chunkNum: 0,
+		buf:      nil,
+	}
+}
+
+// NewFinalReader opens streams written by NewFinalWriter. Every chunk must
+// carry the expected counter and an epoch no older than the chunk before it,
+// and the stream must end right after the final chunk.
+func NewFinalReader(r io.Reader, aead cipher.AEAD) io.Reader {
+	return &finalReader{
+		aead: aead,
+		r:    r,
+	}
+}
+
+// NewFlushReader opens streams written by NewFlushWriter, with the checks of NewFinalReader.
+func NewFlushReader(r io.Reader, aead cipher.AEAD) io.Reader {
+	return &finalReader{
+		aead:         aead,
+		r:            r,
+		lengthPrefix: true,
+	}
+}
+
+type chunkedReader struct {
+	aead     cipher.AEAD
+	r        io.Reader
+	chunkNum uint64
+	buf      []byte
+}
+
+func (g *chunkedReader) Read(p []byte) (int, error) {
+	if len(g.buf) == 0 {
+		nonce := make([]byte, NonceSize)
+		if _, err := io.ReadFull(g.r, nonce); err != nil {
+			return 0, err
+		}
+
+		ciphertext := make([]byte, ChunkSize+g.aead.Overhead())
+		n, err := io.ReadFull(g.r, ciphertext)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, err
+		}
+		ciphertext = ciphertext[:n]
+
+		plaintext, err := g.aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return 0, errDecryption
+		}
+		g.buf = plaintext
+		g.chunkNum++
+	}
+
+	n := copy(p, g.buf)
+	g.buf = g.buf[n:]
+	return n, nil
+}
+
+type finalReader struct {
+	aead     cipher.AEAD
+	r        io.Reader
+	chunkNum uint64
+	epoch    uint16
+	buf      []byte
+	done     bool
+	// peeked holds the byte read ahead to tell whether a full chunk is the last one.
+	peeked       []byte
+	lengthPrefix bool
+}
+
+func (g *finalReader) Read(p []byte) (int, error) {
+	for len(g.buf) == 0 {
+		if g.done {
+			return 0, io.EOF
+		}
+		if err := g.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, g.buf)
+	g.buf = g.buf[n:]
+	return n, nil
+}
+
+func (g *finalReader) next() error {
+	var frame []byte
+	var final bool
+	var err error
+	if g.lengthPrefix {
+		frame, final, err = g.readPrefixedFrame()
+	} else {
+		frame, final, err = g.readFixedFrame()
+	}
+	if err != nil {
+		return err
+	}
+
+	nonce, ciphertext := frame[:NonceSize], frame[NonceSize:]
+	epoch, num, isFinal, ok := parseNonce(nonce)
+	if !ok || num != g.chunkNum || epoch < g.epoch {
+		return errDecryption
+	}
+	if final && !isFinal {
+		return ErrTruncated
+	}
+	if !final && isFinal {
+		return ErrTrailingData
+	}
+	plaintext, err := g.aead.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return errDecryption
+	}
+	g.buf = plaintext
+	g.chunkNum++
+	g.epoch = epoch
+	g.done = final
+	return nil
+}
+
+// readFixedFrame reads the next frame; final reports whether the stream ends with it.
+func (g *finalReader) readFixedFrame() ([]byte, bool, error) {
+	frame := make([]byte, NonceSize+ChunkSize+g.aead.Overhead())
+	copy(frame, g.peeked)
+	n, err := io.ReadFull(g.r, frame[len(g.peeked):])
+	n += len(g.peeked)
+	g.peeked = nil
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, false, err
+	}
+	frame = frame[:n]
+
+	final := n < len(frame) || err != nil
+	if !final {
+		var b [1]byte
+		if _, err := io.ReadFull(g.r, b[:]); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return nil, false, err
+			}
+			final = true
+		} else {
+			g.peeked = b[:]
+		}
+	}
+	if n < NonceSize+g.aead.Overhead() {
+		return nil, false, ErrTruncated
+	}
+	return frame, final, nil
+}
+
+// readPrefixedFrame reads the next length-prefixed frame. The stream can only end
+// after a frame whose nonce is marked final, so only then is the end probed for.
+func (g *finalReader) readPrefixedFrame() ([]byte, bool, error) {
+	var length [lengthPrefixSize]byte
+	if _, err := io.ReadFull(g.r, length[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, false, ErrTruncated
+		}
+		return nil, false, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n < uint32(NonceSize+g.aead.Overhead()) || n > uint32(NonceSize+ChunkSize+g.aead.Overhead()) {
+		return nil, false, errDecryption
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(g.r, frame); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, false, ErrTruncated
+		}
+		return nil, false, err
+	}
+	if frame[finalFlagIndex] != 1 {
+		return frame, false, nil
+	}
+	var b [1]byte
+	if _, err := io.ReadFull(g.r, b[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return frame, true, nil
+		}
+		return nil, false, err
+	}
+	return frame, false, nil
+}
@@ -0,0 +1,110 @@
+package chunked
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAEAD(t *testing.T) cipher.AEAD {
+	t.Helper()
+	block, err := aes.NewCipher(make([]byte, 32))
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	return aead
+}
+
+func sealFinal(t *testing.T, aead cipher.AEAD, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewFinalWriter(&buf, aead)
+	_, err := w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close(), "Close must be idempotent")
+	return buf.Bytes()
+}
+
+func TestFinal_Roundtrip(t *testing.T) {
+	aead := testAEAD(t)
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3 * ChunkSize} {
+		plain := bytes.Repeat([]byte{0xab}, size)
+		got, err := io.ReadAll(NewFinalReader(bytes.NewReader(sealFinal(t, aead, plain)), aead))
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, plain, got)
+	}
+}
+
+func TestFinal_DetectsTruncationReorderAndTrailingData(t *testing.T) {
+	aead := testAEAD(t)
+	frame := NonceSize + ChunkSize + aead.Overhead()
+	sealed := sealFinal(t, aead, bytes.Repeat([]byte{1}, 2*ChunkSize+5))
+	require.Len(t, sealed, 2*frame+NonceSize+5+aead.Overhead())
+
+	read := func(b []byte) error {
+		_, err := io.ReadAll(NewFinalReader(bytes.NewReader(b), aead))
+		return err
+	}
+
+	require.ErrorIs(t, read(sealed[:frame]), ErrTruncated)
+	require.ErrorIs(t, read(sealed[:2*frame]), ErrTruncated)
+	require.ErrorIs(t, read(nil), ErrTruncated)
+
+	swapped := append(append(bytes.Clone(sealed[frame:2*frame]), sealed[:frame]...), sealed[2*frame:]...)
+	require.ErrorContains(t, read(swapped), "decryption failed")
+
+	withExtra := append(bytes.Clone(sealed), sealFinal(t, aead, nil)...)
+	require.Error(t, read(withExtra))
+
+	// A full chunk marked final, followed by more data.
+	early := append(ChunkNonce(0, true), aead.Seal(nil, ChunkNonce(0, true), make([]byte, ChunkSize), nil)...)
+	require.ErrorIs(t, read(append(early, sealed[frame:]...)), ErrTrailingData)
+}
+
+func TestWriter_EmptyInputWritesNothing(t *testing.T) {
+	aead := testAEAD(t)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, aead)
+	require.NoError(t, w.Close())
+	assert.Zero(t, buf.Len(), "the v1 writer writes nothing for empty input")
+}
+
+func TestResumeWriter_ContinuesStream(t *testing.T) {
+	aead := testAEAD(t)
+	frame := NonceSize + ChunkSize + aead.Overhead()
+	first := bytes.Repeat([]byte{1}, ChunkSize+10)
+	sealed := sealFinal(t, aead, first)
+
+	// Cut off the final chunk and seal it again, with more data, in epoch 1.
+	pending, epoch, err := OpenChunk(aead, sealed[frame:], 1, true)
+	require.NoError(t, err)
+	require.Zero(t, epoch)
+	var buf bytes.Buffer
+	buf.Write(sealed[:frame])
+	w := ResumeWriter(&buf, aead, FixedFinal, Resume{Epoch: 1, ChunkNum: 1, Pending: pending})
+	more := bytes.Repeat([]byte{2}, 2*ChunkSize)
+	_, err = w.Write(more)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	resumed := buf.Bytes()
+	assert.Equal(t, EpochNonce(1, 1, false), resumed[frame:frame+NonceSize])
+	got, err := io.ReadAll(NewFinalReader(bytes.NewReader(resumed), aead))
+	require.NoError(t, err)
+	assert.Equal(t, append(bytes.Clone(first), more...), got)
+
+	// A chunk of an older epoch must not follow a newer one.
+	stale := append(bytes.Clone(resumed[:2*frame]), ChunkNonce(2, true)...)
+	stale = append(stale, aead.Seal(nil, ChunkNonce(2, true), nil, nil)...)
+	_, err = io.ReadAll(NewFinalReader(bytes.NewReader(stale), aead))
+	require.ErrorContains(t, err, "decryption failed")
+
+	_, _, err = OpenChunk(aead, sealed[frame:], 0, true)
+	require.ErrorContains(t, err, "decryption failed", "wrong counter")
+}
@@ -0,0 +1,207 @@
+package chunked
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// SalvageReader opens chunks like NewReader or NewFinalReader, but skips chunks that fail
+// authentication instead of aborting. Chunk nonces double as sync markers: after a failure it
+// looks for the next chunk's nonce within two frames, so inserted or deleted bytes are tolerated
+// as well as damage in place. Lost plaintext ranges follow from the chunk counter.
+// Chunks of a later append epoch are accepted once their counter and flag match.
+type SalvageReader struct {
+	aead      cipher.AEAD
+	br        *bufio.Reader
+	frameSize int
+	markFinal bool
+	zeroFill  bool
+
+	chunkNum  uint64
+	epoch     uint16
+	buf       []byte
+	zeros     int64
+	done      bool
+	finalSeen bool
+	report    crypt.DamageReport
+}
+
+// NewSalvageReader reads a stream written by NewWriter, or by NewFinalWriter if markFinal is set.
+func NewSalvageReader(r io.Reader, aead cipher.AEAD, markFinal bool, opts crypt.SalvageOptions) *SalvageReader {
+	frameSize := NonceSize + ChunkSize + aead.Overhead()
+	return &SalvageReader{
+		aead:      aead,
+		br:        bufio.NewReaderSize(r, 2*frameSize+NonceSize),
+		frameSize: frameSize,
+		markFinal: markFinal,
+		zeroFill:  opts.ZeroFill,
+	}
+}
+
+func (s *SalvageReader) Read(p []byte) (int, error) {
+	for {
+		switch {
+		case s.zeros > 0:
+			n := int(min(int64(len(p)), s.zeros))
+			clear(p[:n])
+			s.zeros -= int64(n)
+			return n, nil
+		case len(s.buf) > 0:
+			n := copy(p, s.buf)
+			s.buf = s.buf[n:]
+			return n, nil
+		case s.done:
+			s.report.Truncated = s.markFinal && !s.finalSeen
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Report returns the damage found so far.
+func (s *SalvageReader) Report() *crypt.DamageReport {
+	return &s.report
+}
+
+// Done reports whether the end of the stream has been reached.
+func (s *SalvageReader) Done() bool {
+	return s.done && s.zeros == 0 && len(s.buf) == 0
+}
+
+func (s *SalvageReader) next() error {
+	window, err := s.br.Peek(2*s.frameSize + NonceSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if len(window) == 0 {
+		s.done = true
+		return nil
+	}
+	short := err != nil
+
+	if plaintext, n, final, ok := s.open(window, short, 0, s.chunkNum); ok {
+		s.accept(plaintext, final, s.nonce(window, s.chunkNum, final))
+		_, err := s.br.Discard(n)
+		return err
+	}
+
+	if off, num, ok := s.resync(window, short); ok {
+		for ; s.chunkNum < num; s.chunkNum++ {
+			s.damage(ChunkSize)
+		}
+		_, err := s.br.Discard(off)
+		return err
+	}
+
+	// Damaged in place: skip one frame.
+	n := min(s.frameSize, len(window))
+	lost := ChunkSize
+	if n < s.frameSize {
+		lost = max(0, n-NonceSize-s.aead.Overhead())
+	}
+	s.damage(lost)
+	s.chunkNum++
+	if n == len(window) && short {
+		s.done = true
+	}
+	_, err = s.br.Discard(n)
+	return err
+}
+
+// open tries the frame of chunk num at window[off:]. Only the last frame of the stream
+// can be a final chunk; a full last frame may also be a non-final chunk of a truncated stream.
+func (s *SalvageReader) open(window []byte, short bool, off int, num uint64) ([]byte, int, bool, bool) {
+	end := min(off+s.frameSize, len(window))
+	last := short && end == len(window)
+	frame := window[off:end]
+	if len(frame) < NonceSize+s.aead.Overhead() {
+		return nil, 0, false, false
+	}
+	if s.markFinal && last {
+		if plaintext, err := s.aead.Open(nil, s.nonce(frame, num, true), frame[NonceSize:], nil); err == nil {
+			return plaintext, len(frame), true, true
+		}
+	}
+	if len(frame) < s.frameSize && !(last && !s.markFinal) {
+		return nil, 0, false, false
+	}
+	plaintext, err := s.aead.Open(nil, s.nonce(frame, num, false), frame[NonceSize:], nil)
+	if err != nil {
+		return nil, 0, false, false
+	}
+	return plaintext, len(frame), false, true
+}
+
+// nonce returns the expected nonce of chunk num. The stored nonce is trusted only for
+// its epoch, and only if that epoch is not older than the last authentic chunk's.
+func (s *SalvageReader) nonce(frame []byte, num uint64, final bool) []byte {
+	epoch, n, isFinal, ok := parseNonce(frame[:NonceSize])
+	if !ok || n != num || isFinal != final || epoch < s.epoch {
+		epoch = s.epoch
+	}
+	return EpochNonce(epoch, num, final)
+}
+
+// resync finds the nearest later offset holding an authentic frame of one of the next chunks.
+func (s *SalvageReader) resync(window []byte, short bool) (int, uint64, bool) {
+	bestOff, bestNum, found := 0, uint64(0), false
+	for num := s.chunkNum; num <= s.chunkNum+2; num++ {
+		for _, final := range []bool{false, true} {
+			if final && !s.markFinal {
+				continue
+			}
+			// The epoch is not part of the marker: the flag and counter follow it.
+			marker := ChunkNonce(num, final)[finalFlagIndex:]
+			for start := 1 + finalFlagIndex; start < len(window); {
+				i := bytes.Index(window[start:], marker)
+				if i < 0 {
+					break
+				}
+				off := start + i - finalFlagIndex
+				if found && off >= bestOff {
+					break
+				}
+				if _, _, _, ok := s.open(window, short, off, num); ok {
+					bestOff, bestNum, found = off, num, true
+					break
+				}
+				start = off + finalFlagIndex + 1
+			}
+		}
+	}
+	return bestOff, bestNum, found
+}
+
+// accept takes an authentic chunk; nonce is the one it was opened with.
+func (s *SalvageReader) accept(plaintext []byte, final bool, nonce []byte) {
+	s.buf = plaintext
+	s.chunkNum++
+	s.epoch, _, _, _ = parseNonce(nonce)
+	if final {
+		s.finalSeen = true
+		s.done = true
+	}
+}
+
+func (s *SalvageReader) damage(lost int) {
+	if lost == 0 {
+		return
+	}
+	offset := int64(s.chunkNum) * ChunkSize
+	ranges := s.report.Damaged
+	if n := len(ranges); n > 0 && ranges[n-1].Offset+ranges[n-1].Length == offset {
+		ranges[n-1].Length += int64(lost)
+	} else {
+		s.report.Damaged = append(ranges, crypt.ByteRange{Offset: offset, Length: int64(lost)})
+	}
+	if s.zeroFill {
+		s.zeros += int64(lost)
+	}
+}
@@ -0,0 +1,110 @@
+package chunked
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+func salvage(t *testing.T, sealed []byte, markFinal, zeroFill bool) ([]byte, *crypt.DamageReport) {
+	t.Helper()
+	aead := testAEAD(t)
+	r := NewSalvageReader(bytes.NewReader(sealed), aead, markFinal, crypt.SalvageOptions{ZeroFill: zeroFill})
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.True(t, r.Done())
+	return got, r.Report()
+}
+
+func patterned(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i / ChunkSize)
+	}
+	return b
+}
+
+func TestSalvage_Intact(t *testing.T) {
+	plain := patterned(3*ChunkSize + 10)
+	got, report := salvage(t, sealFinal(t, testAEAD(t), plain), true, false)
+	assert.Equal(t, plain, got)
+	assert.True(t, report.OK())
+}
+
+func TestSalvage_DamageInPlace(t *testing.T) {
+	aead := testAEAD(t)
+	frame := NonceSize + ChunkSize + aead.Overhead()
+	plain := patterned(4*ChunkSize + 10)
+	sealed := sealFinal(t, aead, plain)
+	sealed[frame+100] ^= 0xff      // chunk 1 body
+	sealed[3*frame-1] ^= 0xff      // chunk 2 tag
+	sealed[4*frame+NonceSize] ^= 1 // final chunk
+
+	got, report := salvage(t, bytes.Clone(sealed), true, true)
+	want := bytes.Clone(plain)
+	clear(want[ChunkSize : 3*ChunkSize])
+	clear(want[4*ChunkSize:])
+	assert.Equal(t, want, got)
+	assert.Equal(t, []crypt.ByteRange{{Offset: ChunkSize, Length: 2 * ChunkSize}, {Offset: 4 * ChunkSize, Length: 10}}, report.Damaged)
+	assert.True(t, report.Truncated, "the final chunk could not be authenticated")
+
+	got, _ = salvage(t, sealed, true, false)
+	assert.Equal(t, append(bytes.Clone(plain[:ChunkSize]), plain[3*ChunkSize:4*ChunkSize]...), got)
+}
+
+func TestSalvage_InsertedAndDeletedBytes(t *testing.T) {
+	aead := testAEAD(t)
+	frame := NonceSize + ChunkSize + aead.Overhead()
+	plain := patterned(5*ChunkSize + 1)
+	sealed := sealFinal(t, aead, plain)
+
+	// 5 garbage bytes inside chunk 1, and 3 bytes missing from chunk 3.
+	var damaged []byte
+	damaged = append(damaged, sealed[:frame+50]...)
+	damaged = append(damaged, 1, 2, 3, 4, 5)
+	damaged = append(damaged, sealed[frame+50:3*frame+70]...)
+	damaged = append(damaged, sealed[3*frame+73:]...)
+
+	got, report := salvage(t, damaged, true, true)
+	want := bytes.Clone(plain)
+	clear(want[ChunkSize : 2*ChunkSize])
+	clear(want[3*ChunkSize : 4*ChunkSize])
+	assert.Equal(t, want, got)
+	assert.Equal(t, []crypt.ByteRange{{Offset: ChunkSize, Length: ChunkSize}, {Offset: 3 * ChunkSize, Length: ChunkSize}}, report.Damaged)
+	assert.False(t, report.Truncated)
+}
+
+func TestSalvage_Truncated(t *testing.T) {
+	aead := testAEAD(t)
+	frame := NonceSize + ChunkSize + aead.Overhead()
+	plain := patterned(3 * ChunkSize)
+	sealed := sealFinal(t, aead, plain)
+
+	got, report := salvage(t, sealed[:2*frame], true, false)
+	assert.Equal(t, plain[:2*ChunkSize], got)
+	assert.Empty(t, report.Damaged)
+	assert.True(t, report.Truncated)
+}
+
+func TestSalvage_LegacyFraming(t *testing.T) {
+	aead := testAEAD(t)
+	frame := NonceSize + ChunkSize + aead.Overhead()
+	plain := patterned(2*ChunkSize + 7)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, aead)
+	_, err := w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	sealed := buf.Bytes()
+	sealed[frame-1] ^= 1
+
+	got, report := salvage(t, sealed, false, false)
+	assert.Equal(t, plain[ChunkSize:], got)
+	assert.Equal(t, []crypt.ByteRange{{Offset: 0, Length: ChunkSize}}, report.Damaged)
+	assert.False(t, report.Truncated)
+}
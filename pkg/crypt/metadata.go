@@ -0,0 +1,32 @@
+package crypt
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// Metadata describes the original object. It is encrypted and authenticated
+// together with the stream, so none of it leaks into object names or storage metadata.
+type Metadata struct {
+	Name        string            `json:"name,omitempty"`
+	ModTime     time.Time         `json:"mtime,omitzero"`
+	Mode        os.FileMode       `json:"mode,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+// MetadataCrypter is implemented by crypters that can store Metadata as the first record of the stream.
+type MetadataCrypter interface {
+	Crypter
+
+	// EncryptWithMetadata works like Encrypt and stores md in the stream; a nil md stores nothing.
+	EncryptWithMetadata(w io.Writer, md *Metadata) (io.WriteCloser, error)
+
+	// ReadMetadata reads only the stream header and the metadata record.
+	// It returns nil metadata when the stream carries none.
+	ReadMetadata(r io.Reader) (*Metadata, error)
+
+	// DecryptWithMetadata works like Decrypt and also returns the stored metadata, if any.
+	DecryptWithMetadata(r io.Reader) (io.Reader, *Metadata, error)
+}
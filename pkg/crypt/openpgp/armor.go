@@ -0,0 +1,223 @@
+package openpgp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// --- ASCII armor ---
+//
+// Armored messages (RFC 9580 section 6) wrap the binary packets in base64 lines between
+// BEGIN and END lines, optionally followed by a CRC-24 line. RFC 9580 drops the CRC for new
+// messages, but GnuPG 2.2 still expects it, so it is written with SEIPD v1 messages.
+
+const (
+	armorBegin    = "-----BEGIN PGP MESSAGE-----"
+	armorEnd      = "-----END PGP MESSAGE-----"
+	armorLineSize = 64 // base64 characters per line, as GnuPG writes them
+
+	crc24Init = 0xb704ce
+	crc24Poly = 0x1864cfb
+)
+
+var ErrArmorChecksum = errors.New("armor checksum mismatch: corruption detected")
+
+func crc24(crc uint32, b []byte) uint32 {
+	for _, c := range b {
+		crc ^= uint32(c) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= crc24Poly
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+// armorWriter armors the bytes written to it; Close writes the END line but does not close w.
+type armorWriter struct {
+	w        io.Writer
+	lines    *lineWriter
+	enc      io.WriteCloser
+	crc      uint32
+	checksum bool
+}
+
+func newArmorWriter(w io.Writer, checksum bool) (*armorWriter, error) {
+	if _, err := io.WriteString(w, armorBegin+"\n\n"); err != nil {
+		return nil, err
+	}
+	lines := &lineWriter{w: w}
+	return &armorWriter{
+		w:        w,
+		lines:    lines,
+		enc:      base64.NewEncoder(base64.StdEncoding, lines),
+		crc:      crc24Init,
+		checksum: checksum,
+	}, nil
+}
+
+func (a *armorWriter) Write(p []byte) (int, error) {
+	a.crc = crc24(a.crc, p)
+	return a.enc.Write(p)
+}
+
+func (a *armorWriter) Close() error {
+	if err := a.enc.Close(); err != nil {
+		return err
+	}
+	var tail []byte
+	if a.lines.n > 0 {
+		tail = append(tail, '\n')
+	}
+	if a.checksum {
+		crc := []byte{byte(a.crc >> 16), byte(a.crc >> 8), byte(a.crc)}
+		tail = append(tail, '=')
+		tail = base64.StdEncoding.AppendEncode(tail, crc)
+		tail = append(tail, '\n')
+	}
+	_, err := a.w.Write(append(tail, armorEnd+"\n"...))
+	return err
+}
+
+// lineWriter breaks the base64 output into lines.
+type lineWriter struct {
+	w io.Writer
+	n int // characters on the current line
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	var out []byte
+	for rest := p; len(rest) > 0; {
+		k := min(len(rest), armorLineSize-l.n)
+		out = append(out, rest[:k]...)
+		rest = rest[k:]
+		if l.n += k; l.n == armorLineSize {
+			out = append(out, '\n')
+			l.n = 0
+		}
+	}
+	if _, err := l.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// isArmored reports whether the message starts with text rather than a packet header, whose
+// first byte always has bit 7 set.
+func isArmored(br *bufio.Reader) bool {
+	for i := 1; ; i++ {
+		b, err := br.Peek(i)
+		if err != nil {
+			return false
+		}
+		switch c := b[i-1]; c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		default:
+			return c&0x80 == 0
+		}
+	}
+}
+
+// armorReader decodes an armored message line by line.
+type armorReader struct {
+	br   *bufio.Reader
+	buf  []byte
+	crc  uint32
+	done bool
+	err  error
+}
+
+// newArmorReader skips to the BEGIN line and past the armor headers.
+func newArmorReader(br *bufio.Reader) (io.Reader, error) {
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return nil, errors.New("invalid armor: missing " + armorBegin)
+		}
+		if string(line) == armorBegin {
+			break
+		}
+	}
+	a := &armorReader{br: br, crc: crc24Init}
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if len(line) == 0 {
+			return a, nil
+		}
+		if !bytes.Contains(line, []byte(": ")) {
+			// No blank line after the headers: this is already data.
+			if err := a.decodeLine(line); err != nil {
+				return nil, err
+			}
+			return a, nil
+		}
+	}
+}
+
+func readLine(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	return bytes.TrimSpace(line), err
+}
+
+func (a *armorReader) Read(p []byte) (int, error) {
+	for len(a.buf) == 0 {
+		if a.err != nil {
+			return 0, a.err
+		}
+		line, err := readLine(a.br)
+		if err != nil {
+			a.err = errors.New("invalid armor: missing " + armorEnd)
+			continue
+		}
+		if err := a.decodeLine(line); err != nil {
+			a.err = err
+		}
+	}
+	n := copy(p, a.buf)
+	a.buf = a.buf[n:]
+	return n, nil
+}
+
+func (a *armorReader) decodeLine(line []byte) error {
+	switch {
+	case bytes.HasPrefix(line, []byte("-----")):
+		if string(line) != armorEnd {
+			return errors.New("invalid armor: missing " + armorEnd)
+		}
+		return io.EOF
+	case a.done:
+		if len(line) == 0 {
+			return nil
+		}
+		return errors.New("invalid armor: data after the checksum")
+	case len(line) > 0 && line[0] == '=':
+		crc, err := base64.StdEncoding.DecodeString(string(line[1:]))
+		if err != nil || len(crc) != 3 {
+			return errors.New("invalid armor checksum")
+		}
+		if uint32(crc[0])<<16|uint32(crc[1])<<8|uint32(crc[2]) != a.crc {
+			return ErrArmorChecksum
+		}
+		a.done = true
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(string(line))
+	if err != nil {
+		return errors.New("invalid armor: " + err.Error())
+	}
+	a.crc = crc24(a.crc, b)
+	a.buf = b
+	return nil
+}
@@ -0,0 +1,92 @@
+package openpgp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func armor(t *testing.T, data []byte, checksum bool) string {
+	t.Helper()
+	var buf bytes.Buffer
+	aw, err := newArmorWriter(&buf, checksum)
+	require.NoError(t, err)
+	_, err = aw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, aw.Close())
+	return buf.String()
+}
+
+func dearmor(text string) ([]byte, error) {
+	br := bufio.NewReader(strings.NewReader(text))
+	if !isArmored(br) {
+		return nil, io.ErrNoProgress
+	}
+	ar, err := newArmorReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(ar)
+}
+
+func TestCRC24(t *testing.T) {
+	// CRC-24/OPENPGP check value.
+	assert.Equal(t, uint32(crc24Init), crc24(crc24Init, nil))
+	assert.Equal(t, uint32(0x21cf02), crc24(crc24Init, []byte("123456789")))
+}
+
+func TestArmor_Roundtrip(t *testing.T) {
+	for _, n := range []int{0, 1, 47, 48, 49, 1000} {
+		data := bytes.Repeat([]byte{0xc3, 0x00, 0x7f}, n)[:n]
+		for _, checksum := range []bool{false, true} {
+			text := armor(t, data, checksum)
+			assert.True(t, strings.HasPrefix(text, armorBegin+"\n\n"))
+			assert.True(t, strings.HasSuffix(text, armorEnd+"\n"))
+			assert.Equal(t, checksum, strings.Contains(text, "\n="), n)
+			for _, line := range strings.Split(text, "\n") {
+				assert.LessOrEqual(t, len(line), armorLineSize)
+			}
+
+			got, err := dearmor(text)
+			require.NoError(t, err, n)
+			assert.Equal(t, data, append([]byte{}, got...), n)
+		}
+	}
+}
+
+func TestArmor_Reader(t *testing.T) {
+	text := armor(t, []byte("packets"), true)
+
+	// Leading text, armor headers and CRLF line endings are accepted.
+	withHeaders := strings.Replace(text, armorBegin+"\n\n", armorBegin+"\nVersion: GnuPG v2\nComment: test\n\n", 1)
+	for _, variant := range []string{
+		"\n\n" + text,
+		withHeaders,
+		strings.ReplaceAll(withHeaders, "\n", "\r\n"),
+		strings.Replace(text, armorBegin+"\n\n", armorBegin+"\n", 1), // no blank line
+	} {
+		got, err := dearmor(variant)
+		require.NoError(t, err, variant)
+		assert.Equal(t, "packets", string(got))
+	}
+
+	// A binary message is not armored.
+	assert.False(t, isArmored(bufio.NewReader(bytes.NewReader([]byte{0xc3, 0x0d}))))
+
+	lines := strings.Split(text, "\n")
+	checksumLine := len(lines) - 3
+	require.True(t, strings.HasPrefix(lines[checksumLine], "="))
+	lines[checksumLine] = "=AAAA"
+	_, err := dearmor(strings.Join(lines, "\n"))
+	require.ErrorIs(t, err, ErrArmorChecksum)
+
+	_, err = dearmor(strings.TrimSuffix(text, armorEnd+"\n"))
+	require.ErrorContains(t, err, "missing "+armorEnd)
+	_, err = dearmor("-----BEGIN PGP SIGNATURE-----\n\nAAAA\n-----END PGP SIGNATURE-----\n")
+	require.ErrorContains(t, err, "missing "+armorBegin)
+}
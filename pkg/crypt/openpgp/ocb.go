@@ -0,0 +1,198 @@
+package openpgp
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"math/bits"
+)
+
+// --- OCB ---
+//
+// OCB (RFC 7253) with 128-bit tags, the AEAD mode every RFC 9580 implementation must support.
+// The standard library has no OCB, so it is implemented here on top of a cipher.Block.
+
+const (
+	ocbBlockSize    = 16
+	ocbTagSize      = 16
+	ocbMaxNonceSize = 15 // the OpenPGP nonce size
+)
+
+type ocb struct {
+	block     cipher.Block
+	nonceSize int
+	lStar     [ocbBlockSize]byte
+	lDollar   [ocbBlockSize]byte
+	l         [64][ocbBlockSize]byte // l[i] = L_i
+}
+
+var _ cipher.AEAD = &ocb{}
+
+func newOCB(block cipher.Block, nonceSize int) (cipher.AEAD, error) {
+	if block.BlockSize() != ocbBlockSize {
+		return nil, errors.New("ocb: block size must be 16 bytes")
+	}
+	if nonceSize < 1 || nonceSize > ocbMaxNonceSize {
+		return nil, errors.New("ocb: invalid nonce size")
+	}
+	o := &ocb{block: block, nonceSize: nonceSize}
+	block.Encrypt(o.lStar[:], o.lStar[:])
+	o.lDollar = double(o.lStar)
+	o.l[0] = double(o.lDollar)
+	for i := 1; i < len(o.l); i++ {
+		o.l[i] = double(o.l[i-1])
+	}
+	return o, nil
+}
+
+func (o *ocb) NonceSize() int { return o.nonceSize }
+
+func (o *ocb) Overhead() int { return ocbTagSize }
+
+// double multiplies by x in GF(2^128), as in RFC 7253 section 2.
+func double(s [ocbBlockSize]byte) [ocbBlockSize]byte {
+	var d [ocbBlockSize]byte
+	for i := 0; i < ocbBlockSize-1; i++ {
+		d[i] = s[i]<<1 | s[i+1]>>7
+	}
+	d[ocbBlockSize-1] = s[ocbBlockSize-1] << 1
+	if s[0]&0x80 != 0 {
+		d[ocbBlockSize-1] ^= 0x87
+	}
+	return d
+}
+
+// lAt returns L_{ntz(i)} for block number i >= 1.
+func (o *ocb) lAt(i int) *[ocbBlockSize]byte {
+	return &o.l[bits.TrailingZeros64(uint64(i))]
+}
+
+// initialOffset computes Offset_0 from the nonce (RFC 7253 section 4.2).
+func (o *ocb) initialOffset(nonce []byte) [ocbBlockSize]byte {
+	var n [ocbBlockSize]byte
+	copy(n[ocbBlockSize-len(nonce):], nonce)
+	n[ocbBlockSize-1-len(nonce)] |= 1 // the tag length (128 mod 128 = 0) leaves the top bits zero
+	bottom := int(n[ocbBlockSize-1] & 0x3f)
+	n[ocbBlockSize-1] &^= 0x3f
+
+	var ktop [ocbBlockSize]byte
+	o.block.Encrypt(ktop[:], n[:])
+	var stretch [ocbBlockSize + 8]byte
+	copy(stretch[:], ktop[:])
+	for i := 0; i < 8; i++ {
+		stretch[ocbBlockSize+i] = ktop[i] ^ ktop[i+1]
+	}
+
+	var offset [ocbBlockSize]byte
+	byteShift, bitShift := bottom/8, uint(bottom%8)
+	for i := range offset {
+		offset[i] = stretch[i+byteShift] << bitShift
+		if bitShift != 0 {
+			offset[i] |= stretch[i+byteShift+1] >> (8 - bitShift)
+		}
+	}
+	return offset
+}
+
+// hash is HASH(K, A) of RFC 7253 section 4.1.
+func (o *ocb) hash(ad []byte) [ocbBlockSize]byte {
+	var sum, offset, tmp [ocbBlockSize]byte
+	i := 1
+	for ; len(ad) >= ocbBlockSize; i++ {
+		subtle.XORBytes(offset[:], offset[:], o.lAt(i)[:])
+		subtle.XORBytes(tmp[:], ad[:ocbBlockSize], offset[:])
+		o.block.Encrypt(tmp[:], tmp[:])
+		subtle.XORBytes(sum[:], sum[:], tmp[:])
+		ad = ad[ocbBlockSize:]
+	}
+	if len(ad) > 0 {
+		subtle.XORBytes(offset[:], offset[:], o.lStar[:])
+		tmp = [ocbBlockSize]byte{}
+		copy(tmp[:], ad)
+		tmp[len(ad)] = 0x80
+		subtle.XORBytes(tmp[:], tmp[:], offset[:])
+		o.block.Encrypt(tmp[:], tmp[:])
+		subtle.XORBytes(sum[:], sum[:], tmp[:])
+	}
+	return sum
+}
+
+// crypt encrypts or decrypts src into dst and returns the tag.
+func (o *ocb) crypt(encrypt bool, dst, nonce, src, ad []byte) [ocbBlockSize]byte {
+	if len(nonce) != o.nonceSize {
+		panic("ocb: incorrect nonce length")
+	}
+	offset := o.initialOffset(nonce)
+	var checksum, tmp [ocbBlockSize]byte
+	i := 1
+	for ; len(src) >= ocbBlockSize; i++ {
+		subtle.XORBytes(offset[:], offset[:], o.lAt(i)[:])
+		subtle.XORBytes(tmp[:], src[:ocbBlockSize], offset[:])
+		if encrypt {
+			subtle.XORBytes(checksum[:], checksum[:], src[:ocbBlockSize])
+			o.block.Encrypt(tmp[:], tmp[:])
+		} else {
+			o.block.Decrypt(tmp[:], tmp[:])
+		}
+		subtle.XORBytes(dst[:ocbBlockSize], tmp[:], offset[:])
+		if !encrypt {
+			subtle.XORBytes(checksum[:], checksum[:], dst[:ocbBlockSize])
+		}
+		src, dst = src[ocbBlockSize:], dst[ocbBlockSize:]
+	}
+	if len(src) > 0 {
+		subtle.XORBytes(offset[:], offset[:], o.lStar[:])
+		var pad [ocbBlockSize]byte
+		o.block.Encrypt(pad[:], offset[:])
+		tmp = [ocbBlockSize]byte{}
+		if encrypt {
+			copy(tmp[:], src)
+		}
+		subtle.XORBytes(dst[:len(src)], src, pad[:len(src)])
+		if !encrypt {
+			copy(tmp[:], dst[:len(src)])
+		}
+		tmp[len(src)] = 0x80
+		subtle.XORBytes(checksum[:], checksum[:], tmp[:])
+	}
+
+	var tag [ocbBlockSize]byte
+	subtle.XORBytes(tag[:], checksum[:], offset[:])
+	subtle.XORBytes(tag[:], tag[:], o.lDollar[:])
+	o.block.Encrypt(tag[:], tag[:])
+	h := o.hash(ad)
+	subtle.XORBytes(tag[:], tag[:], h[:])
+	return tag
+}
+
+func (o *ocb) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	ret, out := sliceForAppend(dst, len(plaintext)+ocbTagSize)
+	tag := o.crypt(true, out, nonce, plaintext, additionalData)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+func (o *ocb) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < ocbTagSize {
+		return nil, errors.New("ocb: message authentication failed")
+	}
+	ciphertext, wantTag := ciphertext[:len(ciphertext)-ocbTagSize], ciphertext[len(ciphertext)-ocbTagSize:]
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	tag := o.crypt(false, out, nonce, ciphertext, additionalData)
+	if subtle.ConstantTimeCompare(tag[:], wantTag) != 1 {
+		clear(out)
+		return nil, errors.New("ocb: message authentication failed")
+	}
+	return ret, nil
+}
+
+// sliceForAppend extends in by n bytes, as the standard library AEADs do.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	return head, head[len(in):]
+}
@@ -0,0 +1,63 @@
+package openpgp
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// RFC 7253 appendix A, AES-128 with 96-bit nonces.
+func TestOCB_KnownAnswers(t *testing.T) {
+	block, err := aes.NewCipher(unhex(t, "000102030405060708090A0B0C0D0E0F"))
+	require.NoError(t, err)
+	aead, err := newOCB(block, 12)
+	require.NoError(t, err)
+
+	for _, tc := range []struct{ nonce, ad, plain, sealed string }{
+		{"BBAA99887766554433221100", "", "", "785407BFFFC8AD9EDCC5520AC9111EE6"},
+		{"BBAA99887766554433221101", "0001020304050607", "0001020304050607", "6820B3657B6F615A5725BDA0D3B4EB3A257C9AF1F8F03009"},
+		{"BBAA99887766554433221102", "0001020304050607", "", "81017F8203F081277152FADE694A0A00"},
+		{"BBAA99887766554433221103", "", "0001020304050607", "45DD69F8F5AAE72414054CD1F35D82760B2CD00D2F99BFA9"},
+	} {
+		nonce, ad, plain, sealed := unhex(t, tc.nonce), unhex(t, tc.ad), unhex(t, tc.plain), unhex(t, tc.sealed)
+		assert.Equal(t, sealed, aead.Seal(nil, nonce, plain, ad), tc.nonce)
+		got, err := aead.Open(nil, nonce, sealed, ad)
+		require.NoError(t, err, tc.nonce)
+		assert.Equal(t, plain, append([]byte{}, got...), tc.nonce)
+
+		sealed[0] ^= 1
+		_, err = aead.Open(nil, nonce, sealed, ad)
+		require.Error(t, err, tc.nonce)
+	}
+}
+
+func TestOCB_InPlace(t *testing.T) {
+	block, err := aes.NewCipher(make([]byte, 32))
+	require.NoError(t, err)
+	aead, err := newOCB(block, ocbMaxNonceSize)
+	require.NoError(t, err)
+	nonce := make([]byte, ocbMaxNonceSize)
+
+	for _, n := range []int{0, 1, 15, 16, 17, 100} {
+		plain := make([]byte, n)
+		for i := range plain {
+			plain[i] = byte(i)
+		}
+		buf := append([]byte{}, plain...)
+		sealed := aead.Seal(buf[:0], nonce, buf, []byte("ad"))
+		assert.Equal(t, aead.Seal(nil, nonce, plain, []byte("ad")), sealed, n)
+		opened, err := aead.Open(sealed[:0], nonce, sealed, []byte("ad"))
+		require.NoError(t, err, n)
+		assert.Equal(t, plain, opened, n)
+	}
+}
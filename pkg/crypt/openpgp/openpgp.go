@@ -0,0 +1,388 @@
+// Package openpgp encrypts with a password into OpenPGP messages (RFC 9580), for consumers that
+// only accept the output of gpg --symmetric.
+//
+// New messages are a v6 SKESK packet with an Argon2 S2K and an AES-256 session key, followed by
+// a SEIPD v2 packet sealed with AES-256-OCB (or GCM) in 64 KiB chunks. GnuPG 2.2 and 2.4 cannot
+// read these; set SEIPDv1 to write the v4 SKESK and SEIPD v1 packets GnuPG writes itself
+// (iterated and salted SHA-256 S2K, AES-256-CFB with a SHA-1 modification detection code).
+//
+// Decrypt reads both, binary or ASCII armored, including GnuPG's compressed messages. Signed
+// messages and public-key encrypted sessions are not supported.
+//
+// SEIPD v1 is not authenticated until the end: CFB mode lets an attacker flip chosen plaintext
+// bits, and the modification detection code over the whole message is checked only when the
+// last read reaches EOF. Decrypt returns that plaintext as it goes, so a caller reading a
+// SEIPD v1 message must buffer or stage its output and discard all of it when a read fails
+// with ErrAuthentication. SEIPD v2 authenticates each chunk before returning it.
+//
+// SEIPD v1 messages are tested against messages written by GnuPG. SEIPD v2 messages are
+// cross-checked only against ProtonMail's go-crypto, not against gpg, which can neither write
+// nor read them.
+package openpgp
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- OpenPGP Crypter ---
+
+type Crypter struct {
+	Password string
+
+	// SecretKey, when set, holds the password instead of Password and is zeroed by Destroy.
+	SecretKey *crypt.SecretKey
+
+	// Mode is the AEAD mode of new SEIPD v2 messages; zero means OCB.
+	Mode Mode
+
+	// S2K is the Argon2 cost of new SEIPD v2 messages; zero means DefaultArgon2S2K.
+	S2K Argon2S2K
+
+	// Armor writes ASCII-armored messages (.asc) instead of binary ones (.gpg).
+	Armor bool
+
+	// SEIPDv1 writes messages GnuPG 2.2 and 2.4 can decrypt, without AEAD or Argon2. Their
+	// plaintext is unauthenticated until the reader reaches EOF; see the package doc.
+	SEIPDv1 bool
+}
+
+var (
+	_ crypt.Crypter    = &Crypter{}
+	_ crypt.Destroyer  = &Crypter{}
+	_ policy.Describer = &Crypter{}
+)
+
+func NewCrypter(password string) crypt.Crypter {
+	return &Crypter{
+		Password: password,
+	}
+}
+
+// NewCrypterFromBytes copies password into a crypt.SecretKey, so the caller can wipe password
+// afterwards.
+func NewCrypterFromBytes(password []byte) crypt.Crypter {
+	return &Crypter{
+		SecretKey: crypt.NewSecretKey(password),
+	}
+}
+
+// Destroy zeroes SecretKey and drops Password; the crypter cannot be used afterwards.
+func (c *Crypter) Destroy() {
+	if c.SecretKey == nil {
+		c.SecretKey = &crypt.SecretKey{} // so later uses fail instead of using an empty password
+	}
+	c.SecretKey.Destroy()
+	c.Password = ""
+}
+
+// usePassword calls fn with the password from SecretKey, or from Password when SecretKey is nil.
+func (c *Crypter) usePassword(fn func(password []byte) error) error {
+	if c.SecretKey == nil {
+		password := []byte(c.Password)
+		defer clear(password)
+		return fn(password)
+	}
+	return c.SecretKey.Use(fn)
+}
+
+func (c *Crypter) FileExtension() string {
+	if c.Armor {
+		return ".asc"
+	}
+	return ".gpg"
+}
+
+func (c *Crypter) Name() string {
+	return "openpgp"
+}
+
+// Algorithms describes new messages; Decrypt checks the algorithms recorded in each message
+// instead.
+func (c *Crypter) Algorithms() []policy.Algorithm {
+	if c.SEIPDv1 {
+		return []policy.Algorithm{
+			cipherAlgorithm(cipherAES256, "cfb"),
+			{Kind: policy.KDF, Name: newIteratedS2K(nil).name()},
+			{Kind: policy.Digest, Name: "sha1"},
+		}
+	}
+	return []policy.Algorithm{
+		cipherAlgorithm(cipherAES256, c.mode().String()),
+		{Kind: policy.KDF, Name: "argon2id"},
+		{Kind: policy.KDF, Name: "hkdf-sha256"},
+	}
+}
+
+func (c *Crypter) mode() Mode {
+	if c.Mode == 0 {
+		return OCB
+	}
+	return c.Mode
+}
+
+func (c *Crypter) s2kParams() Argon2S2K {
+	if c.S2K == (Argon2S2K{}) {
+		return DefaultArgon2S2K
+	}
+	return c.S2K
+}
+
+// --- Encrypt ---
+
+func (c *Crypter) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	if m := c.mode(); m != OCB && m != GCM {
+		return nil, fmt.Errorf("unsupported aead mode: %d", byte(m))
+	}
+	if err := c.s2kParams().validate(); err != nil {
+		return nil, err
+	}
+
+	mw := &messageWriter{}
+	if c.Armor {
+		aw, err := newArmorWriter(w, c.SEIPDv1)
+		if err != nil {
+			return nil, err
+		}
+		mw.closers = append(mw.closers, aw)
+		w = aw
+	}
+
+	var k *skesk
+	var sessionKey []byte
+	err := c.usePassword(func(password []byte) error {
+		if c.SEIPDv1 {
+			salt := make([]byte, iteratedSaltSize)
+			if _, err := rand.Read(salt); err != nil {
+				return err
+			}
+			k = &skesk{version: skeskV4, cipher: cipherAES256, s2k: newIteratedS2K(salt)}
+			sessionKey = k.s2k.deriveKey(password, keySize(cipherAES256))
+			return nil
+		}
+		sessionKey = make([]byte, keySize(cipherAES256))
+		if _, err := rand.Read(sessionKey); err != nil {
+			return err
+		}
+		var err error
+		k, err = newSKESKv6(password, sessionKey, c.mode(), c.s2kParams())
+		return err
+	})
+	// The session key is wiped as soon as the cipher is set up.
+	defer clear(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	body := k.marshal()
+	if _, err := w.Write(appendHeader(nil, tagSKESK, len(body))); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+
+	pw, err := newPartialWriter(w, tagSEIPD)
+	if err != nil {
+		return nil, err
+	}
+	mw.closers = append(mw.closers, pw)
+	var enc io.WriteCloser
+	if c.SEIPDv1 {
+		if _, err := pw.Write([]byte{seipdV1}); err != nil {
+			return nil, err
+		}
+		if enc, err = newSEIPDv1Writer(pw, sessionKey); err != nil {
+			return nil, err
+		}
+	} else {
+		params := &seipdV2Params{cipher: cipherAES256, mode: c.mode(), chunkByte: writeChunk, salt: make([]byte, seipdSaltSize)}
+		if _, err := rand.Read(params.salt); err != nil {
+			return nil, err
+		}
+		ch, err := params.chunker(sessionKey)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write(params.header()); err != nil {
+			return nil, err
+		}
+		enc = &seipdV2Writer{w: pw, c: ch, buf: make([]byte, 0, ch.chunkSize)}
+	}
+	mw.closers = append(mw.closers, enc)
+
+	lw, err := newLiteralWriter(enc)
+	if err != nil {
+		return nil, err
+	}
+	mw.WriteCloser = lw
+	return mw, nil
+}
+
+// messageWriter closes the literal data packet and then the layers around it, innermost first.
+type messageWriter struct {
+	io.WriteCloser
+	closers []io.Closer // outermost first
+}
+
+func (m *messageWriter) Close() error {
+	if err := m.WriteCloser.Close(); err != nil {
+		return err
+	}
+	for i := len(m.closers) - 1; i >= 0; i-- {
+		if err := m.closers[i].Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- Decrypt ---
+
+// Decrypt tries the password on each SKESK packet of the message. A wrong password fails here
+// with ErrPassword; tampering fails the read that reaches the end of the message with
+// ErrAuthentication, after a SEIPD v1 message has already returned the tampered plaintext.
+func (c *Crypter) Decrypt(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if isArmored(br) {
+		ar, err := newArmorReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(ar)
+	}
+
+	var keys []*skesk
+	for {
+		tag, body, err := readPacket(br)
+		if err == io.EOF {
+			return nil, errors.New("invalid message: no encrypted data")
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagSKESK:
+			b, err := io.ReadAll(body)
+			if err != nil {
+				return nil, err
+			}
+			k, err := parseSKESK(b)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		case tagPKESK, tagMarker, tagPadding:
+			if _, err := io.Copy(io.Discard, body); err != nil {
+				return nil, err
+			}
+		case tagSEIPD:
+			if len(keys) == 0 {
+				return nil, errors.New("invalid message: no symmetric-key encrypted session key packet (public-key encryption is not supported)")
+			}
+			dec, err := c.decryptSEIPD(bufio.NewReader(body), keys)
+			if err != nil {
+				return nil, err
+			}
+			return readMessage(dec, 0)
+		default:
+			return nil, fmt.Errorf("unsupported packet before encrypted data: tag %d", tag)
+		}
+	}
+}
+
+func (c *Crypter) decryptSEIPD(body *bufio.Reader, keys []*skesk) (io.Reader, error) {
+	version, err := body.ReadByte()
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	var params *seipdV2Params
+	var encPrefix []byte
+	var wantSKESK byte
+	switch version {
+	case seipdV2:
+		b := make([]byte, 3+seipdSaltSize)
+		if _, err := io.ReadFull(body, b); err != nil {
+			return nil, noEOF(err)
+		}
+		params = &seipdV2Params{cipher: b[0], mode: Mode(b[1]), chunkByte: b[2], salt: b[3:]}
+		if keySize(params.cipher) == 0 {
+			return nil, fmt.Errorf("unsupported cipher: %d", params.cipher)
+		}
+		if params.mode != OCB && params.mode != GCM {
+			return nil, fmt.Errorf("unsupported aead mode: %d", b[1])
+		}
+		if params.chunkByte > maxChunkByte {
+			return nil, fmt.Errorf("invalid chunk size: %d", params.chunkByte)
+		}
+		if err := policy.CheckAlgorithms(params.algorithms()...); err != nil {
+			return nil, err
+		}
+		wantSKESK = skeskV6
+	case seipdV1:
+		encPrefix = make([]byte, aes.BlockSize+2)
+		if _, err := io.ReadFull(body, encPrefix); err != nil {
+			return nil, noEOF(err)
+		}
+		if err := policy.CheckAlgorithms(policy.Algorithm{Kind: policy.Digest, Name: "sha1"}); err != nil {
+			return nil, err
+		}
+		wantSKESK = skeskV4
+	default:
+		return nil, fmt.Errorf("unsupported encrypted data version: %d", version)
+	}
+
+	var dec io.Reader
+	err = c.usePassword(func(password []byte) error {
+		for _, k := range keys {
+			if k.version != wantSKESK {
+				continue
+			}
+			if err := policy.CheckAlgorithms(k.algorithms()...); err != nil {
+				return err
+			}
+			cipherID, sessionKey, err := k.sessionKey(password)
+			if errors.Is(err, ErrPassword) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			// Session keys are wiped as soon as the cipher is set up.
+			defer clear(sessionKey)
+			if params != nil {
+				if len(sessionKey) != keySize(params.cipher) {
+					return errors.New("invalid message: session key does not match the cipher")
+				}
+				ch, err := params.chunker(sessionKey)
+				if err != nil {
+					return err
+				}
+				dec = newSEIPDv2Reader(body, ch)
+				return nil
+			}
+			if err := policy.CheckAlgorithms(cipherAlgorithm(cipherID, "cfb")); err != nil {
+				return err
+			}
+			dec, err = newSEIPDv1Reader(body, encPrefix, cipherID, sessionKey)
+			if errors.Is(err, ErrPassword) {
+				continue
+			}
+			return err
+		}
+		return ErrPassword
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dec, nil
+}
@@ -0,0 +1,303 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// testS2K keeps Argon2 cheap in tests.
+var testS2K = Argon2S2K{Passes: 1, Parallelism: 1, MemoryExp: 10}
+
+type fixtures struct {
+	Password   string `json:"password"`
+	Plaintexts map[string]struct {
+		Size   int    `json:"size"`
+		SHA256 string `json:"sha256"`
+	} `json:"plaintexts"`
+	Messages []struct {
+		File      string `json:"file"`
+		Plaintext string `json:"plaintext"`
+		SKESK     int    `json:"skesk"`
+		SEIPD     int    `json:"seipd"`
+	} `json:"messages"`
+}
+
+func loadFixtures(t *testing.T) fixtures {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "vectors.json"))
+	require.NoError(t, err)
+	var f fixtures
+	require.NoError(t, json.Unmarshal(data, &f))
+	return f
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return data
+}
+
+func encrypt(c *Crypter, plain []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := c.Encrypt(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plain); err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+func decrypt(c *Crypter, message []byte) ([]byte, error) {
+	r, err := c.Decrypt(bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func testFixtures(t *testing.T, seipd int) {
+	fx := loadFixtures(t)
+	var n int
+	for _, m := range fx.Messages {
+		if m.SEIPD != seipd {
+			continue
+		}
+		n++
+		t.Run(m.File, func(t *testing.T) {
+			got, err := decrypt(&Crypter{Password: fx.Password}, readFixture(t, m.File))
+			require.NoError(t, err)
+			want := fx.Plaintexts[m.Plaintext]
+			assert.Len(t, got, want.Size)
+			sum := sha256.Sum256(got)
+			assert.Equal(t, want.SHA256, hex.EncodeToString(sum[:]))
+		})
+	}
+	require.NotZero(t, n)
+}
+
+// GnuPG writes only SEIPD v1 messages.
+func TestCrypter_GnuPGFixtures_SEIPDv1(t *testing.T) {
+	testFixtures(t, 1)
+}
+
+// SEIPD v2 is cross-checked only against go-crypto: gpg cannot write these messages.
+func TestCrypter_GoCryptoFixtures_SEIPDv2(t *testing.T) {
+	testFixtures(t, 2)
+}
+
+func TestCrypter_FixturesWrongPassword(t *testing.T) {
+	fx := loadFixtures(t)
+	for _, m := range fx.Messages {
+		_, err := decrypt(&Crypter{Password: "wrong horse"}, readFixture(t, m.File))
+		require.ErrorIs(t, err, ErrPassword, m.File)
+	}
+}
+
+func TestCrypter_Roundtrip(t *testing.T) {
+	chunk := 1 << (writeChunk + 6)
+	for name, c := range map[string]*Crypter{
+		"ocb":           {Password: "pw", S2K: testS2K},
+		"gcm":           {Password: "pw", S2K: testS2K, Mode: GCM},
+		"ocb armor":     {Password: "pw", S2K: testS2K, Armor: true},
+		"seipdv1":       {Password: "pw", SEIPDv1: true},
+		"seipdv1 armor": {Password: "pw", SEIPDv1: true, Armor: true},
+	} {
+		// Sizes around the chunk and partial length boundaries; the literal data header takes 6 bytes.
+		for _, n := range []int{0, 1, chunk - 6, chunk - 5, chunk, 3*chunk + 17} {
+			plain := make([]byte, n)
+			_, _ = rand.Read(plain)
+			message, err := encrypt(c, plain)
+			require.NoError(t, err, name)
+			got, err := decrypt(c, message)
+			require.NoError(t, err, "%s: %d bytes", name, n)
+			assert.Equal(t, plain, append([]byte{}, got...), "%s: %d bytes", name, n)
+		}
+	}
+}
+
+func TestCrypter_MessageFormat(t *testing.T) {
+	message, err := encrypt(&Crypter{Password: "pw", S2K: testS2K, Mode: GCM}, []byte("hello"))
+	require.NoError(t, err)
+	// A v6 SKESK with AES-256, GCM and an Argon2 S2K, then a SEIPD v2 packet.
+	assert.Equal(t, []byte{0xc0 | tagSKESK}, message[:1])
+	assert.Equal(t, []byte{skeskV6, 3 + 20 + 12, cipherAES256, byte(GCM), 20, s2kArgon2}, message[2:8])
+	seipd := 2 + int(message[1])
+	assert.Equal(t, []byte{0xc0 | tagSEIPD}, message[seipd:seipd+1])
+	assert.Equal(t, []byte{seipdV2, cipherAES256, byte(GCM), writeChunk}, message[seipd+2:seipd+6])
+
+	message, err = encrypt(&Crypter{Password: "pw", SEIPDv1: true}, []byte("hello"))
+	require.NoError(t, err)
+	// A v4 SKESK without encrypted session key, as GnuPG writes it, then a SEIPD v1 packet.
+	assert.Equal(t, []byte{0xc0 | tagSKESK, 5 + iteratedSaltSize, skeskV4, cipherAES256, s2kIteratedSalted, hashSHA256}, message[:6])
+	assert.Equal(t, []byte{0xc0 | tagSEIPD}, message[15:16])
+	assert.Equal(t, byte(seipdV1), message[17])
+
+	armored, err := encrypt(&Crypter{Password: "pw", SEIPDv1: true, Armor: true}, []byte("hello"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(armored), armorBegin+"\n\n"))
+}
+
+func TestCrypter_WrongPassword(t *testing.T) {
+	for _, c := range []*Crypter{
+		{Password: "pw", S2K: testS2K},
+		{Password: "pw", SEIPDv1: true},
+	} {
+		message, err := encrypt(c, []byte("secret"))
+		require.NoError(t, err)
+		_, err = decrypt(&Crypter{Password: "other"}, message)
+		require.ErrorIs(t, err, ErrPassword)
+	}
+}
+
+func TestCrypter_Tampering(t *testing.T) {
+	plain := make([]byte, 3<<(writeChunk+6))
+	for _, c := range []*Crypter{
+		{Password: "pw", S2K: testS2K},
+		{Password: "pw", S2K: testS2K, Mode: GCM},
+		{Password: "pw", SEIPDv1: true},
+	} {
+		message, err := encrypt(c, plain)
+		require.NoError(t, err)
+
+		// Flip a bit in the first chunk, the last chunk, and the final tag or MDC.
+		for _, pos := range []int{200, len(message) - 40, len(message) - 1} {
+			tampered := bytes.Clone(message)
+			tampered[pos] ^= 1
+			_, err := decrypt(c, tampered)
+			require.ErrorIs(t, err, ErrAuthentication, "%+v at %d", c, pos)
+		}
+
+		// Truncated messages never read to a clean end.
+		for _, n := range []int{len(message) - 1, len(message) - tagSize, len(message) / 2} {
+			_, err := decrypt(c, message[:n])
+			require.Error(t, err, "%+v truncated to %d", c, n)
+		}
+	}
+}
+
+func TestCrypter_GnuPGFixtureTampering(t *testing.T) {
+	message := readFixture(t, "gpg-uncompressed.gpg")
+	tampered := bytes.Clone(message)
+	tampered[len(tampered)/2] ^= 1
+	_, err := decrypt(&Crypter{Password: "correct horse"}, tampered)
+	require.ErrorIs(t, err, ErrAuthentication)
+
+	armored := readFixture(t, "gpg-armor.asc")
+	lines := strings.Split(string(armored), "\n")
+	line := []byte(lines[2]) // the first line of base64
+	if line[10] == 'A' {
+		line[10] = 'B'
+	} else {
+		line[10] = 'A'
+	}
+	lines[2] = string(line)
+	_, err = decrypt(&Crypter{Password: "correct horse"}, []byte(strings.Join(lines, "\n")))
+	require.Error(t, err)
+}
+
+func TestCrypter_PasswordBytes(t *testing.T) {
+	password := []byte("correct horse")
+	c := NewCrypterFromBytes(password).(*Crypter)
+	clear(password) // the crypter keeps its own copy
+
+	got, err := decrypt(c, readFixture(t, "gocrypto-short.pgp"))
+	require.NoError(t, err)
+	assert.Equal(t, "hi\n", string(got))
+	assert.Equal(t, ".gpg", c.FileExtension())
+	assert.Equal(t, "openpgp", c.Name())
+	assert.Equal(t, ".asc", (&Crypter{Armor: true}).FileExtension())
+
+	c.Destroy()
+	_, err = decrypt(c, readFixture(t, "gocrypto-short.pgp"))
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+	_, err = encrypt(c, []byte("x"))
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+
+	s := NewCrypter("pw").(*Crypter)
+	s.Destroy()
+	assert.Empty(t, s.Password)
+	_, err = encrypt(s, []byte("x"))
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+}
+
+func TestCrypter_InvalidSettings(t *testing.T) {
+	_, err := encrypt(&Crypter{Password: "pw", Mode: 7}, nil)
+	require.ErrorContains(t, err, "unsupported aead mode")
+	_, err = encrypt(&Crypter{Password: "pw", S2K: Argon2S2K{Passes: 1, Parallelism: 1, MemoryExp: 30}}, nil)
+	require.ErrorContains(t, err, "invalid argon2 s2k memory")
+
+	// The Argon2 cost comes from the message, so a crafted SKESK cannot exceed the limits:
+	// passes, parallelism and memory exponent follow the version, counts, S2K type and salt.
+	message, err := encrypt(&Crypter{Password: "pw", S2K: testS2K}, []byte("x"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{testS2K.Passes, testS2K.Parallelism, testS2K.MemoryExp}, message[8+argon2SaltSize:11+argon2SaltSize])
+	for offset, value := range map[int]byte{8 + argon2SaltSize: MaxArgon2Passes + 1, 10 + argon2SaltSize: MaxArgon2MemoryExp + 1} {
+		crafted := bytes.Clone(message)
+		crafted[offset] = value
+		_, err = decrypt(&Crypter{Password: "pw"}, crafted)
+		require.ErrorContains(t, err, "invalid argon2 s2k", "offset %d", offset)
+	}
+
+	_, err = decrypt(&Crypter{Password: "pw"}, []byte("not a message"))
+	require.Error(t, err)
+	_, err = decrypt(&Crypter{Password: "pw"}, nil)
+	require.Error(t, err)
+
+	// A public-key encrypted session cannot be opened with a password.
+	pkesk := appendHeader(nil, tagPKESK, 3)
+	pkesk = append(pkesk, 3, 0, 0)
+	pkesk = append(appendHeader(pkesk, tagSEIPD, 1), seipdV2)
+	_, err = decrypt(&Crypter{Password: "pw"}, pkesk)
+	require.ErrorContains(t, err, "public-key encryption is not supported")
+}
+
+func TestCrypter_Policy(t *testing.T) {
+	t.Cleanup(func() { policy.SetDefault(nil) })
+	assert.Equal(t, []policy.Algorithm{
+		{Kind: policy.Cipher, Name: "aes-256-ocb", KeyBits: 256},
+		{Kind: policy.KDF, Name: "argon2id"},
+		{Kind: policy.KDF, Name: "hkdf-sha256"},
+	}, (&Crypter{}).Algorithms())
+	assert.Equal(t, []policy.Algorithm{
+		{Kind: policy.Cipher, Name: "aes-256-cfb", KeyBits: 256},
+		{Kind: policy.KDF, Name: "openpgp-s2k-sha256"},
+		{Kind: policy.Digest, Name: "sha1"},
+	}, (&Crypter{SEIPDv1: true}).Algorithms())
+
+	policy.SetDefault(&policy.Policy{Name: "no-cfb", Allowed: map[policy.Kind][]string{
+		policy.Cipher: {"aes-256-ocb", "aes-256-gcm"},
+	}})
+	_, err := encrypt(&Crypter{Password: "pw", SEIPDv1: true}, nil)
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+	_, err = encrypt(&Crypter{Password: "pw", S2K: testS2K}, nil)
+	require.NoError(t, err)
+
+	// The algorithms recorded in the message are checked, whatever the reader is configured with.
+	_, err = decrypt(&Crypter{Password: "correct horse"}, readFixture(t, "gpg-default.gpg"))
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+	_, err = decrypt(&Crypter{Password: "correct horse"}, readFixture(t, "gocrypto-short.pgp"))
+	require.NoError(t, err)
+
+	policy.SetDefault(policy.FIPS())
+	_, err = decrypt(&Crypter{Password: "correct horse"}, readFixture(t, "gocrypto-gcm.pgp"))
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+	require.ErrorContains(t, err, "kdf argon2id")
+}
@@ -0,0 +1,324 @@
+package openpgp
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+)
+
+// --- Packets ---
+//
+// Packets (RFC 9580 section 4) start with a tag and a length. New-format headers are written;
+// old-format headers, which GnuPG still writes, are read as well. Streamed bodies use partial
+// lengths: a run of power-of-two parts ended by a part with a definite length.
+
+const (
+	tagPKESK      = 1
+	tagSKESK      = 3
+	tagCompressed = 8
+	tagMarker     = 10
+	tagLiteral    = 11
+	tagSEIPD      = 18
+	tagPadding    = 21
+
+	partialBits = 16 // 64 KiB parts; RFC 9580 requires at least 512 bytes in the first one
+
+	maxCompressionDepth = 2
+)
+
+// readPacket reads a packet header and returns the tag and a reader over the body, which must
+// be drained before the next packet is read. It returns io.EOF at the end of r.
+func readPacket(r *bufio.Reader) (tag byte, body io.Reader, err error) {
+	ctb, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	if ctb&0x80 == 0 {
+		return 0, nil, fmt.Errorf("invalid packet header: %#x", ctb)
+	}
+	if ctb&0x40 != 0 {
+		length, partial, err := readNewLength(r)
+		if err != nil {
+			return 0, nil, noEOF(err)
+		}
+		tag = ctb & 0x3f
+		if partial {
+			return tag, &partialReader{r: r, remaining: length}, nil
+		}
+		return tag, io.LimitReader(r, length), nil
+	}
+
+	tag = (ctb >> 2) & 0x0f
+	var size int
+	switch ctb & 3 {
+	case 0:
+		size = 1
+	case 1:
+		size = 2
+	case 2:
+		size = 4
+	default:
+		return tag, r, nil // indeterminate: the rest of the stream
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, noEOF(err)
+	}
+	var length int64
+	for _, c := range b {
+		length = length<<8 | int64(c)
+	}
+	return tag, io.LimitReader(r, length), nil
+}
+
+// readNewLength reads a new-format length; partial is set for a part of a partial length body.
+func readNewLength(r *bufio.Reader) (length int64, partial bool, err error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch {
+	case b < 192:
+		return int64(b), false, nil
+	case b < 224:
+		b2, err := r.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return (int64(b)-192)<<8 + int64(b2) + 192, false, nil
+	case b < 255:
+		return 1 << (b & 0x1f), true, nil
+	default:
+		var b4 [4]byte
+		if _, err := io.ReadFull(r, b4[:]); err != nil {
+			return 0, false, err
+		}
+		return int64(binary.BigEndian.Uint32(b4[:])), false, nil
+	}
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// partialReader reads a body with partial lengths.
+type partialReader struct {
+	r         *bufio.Reader
+	remaining int64
+	final     bool
+}
+
+func (p *partialReader) Read(b []byte) (int, error) {
+	for p.remaining == 0 {
+		if p.final {
+			return 0, io.EOF
+		}
+		length, partial, err := readNewLength(p.r)
+		if err != nil {
+			return 0, noEOF(err)
+		}
+		p.remaining, p.final = length, !partial
+	}
+	n, err := p.r.Read(b[:min(int64(len(b)), p.remaining)])
+	p.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// appendHeader appends a new-format header with a definite length.
+func appendHeader(b []byte, tag byte, length int) []byte {
+	b = append(b, 0xc0|tag)
+	switch {
+	case length < 192:
+		return append(b, byte(length))
+	case length < 8384:
+		length -= 192
+		return append(b, byte(length>>8)+192, byte(length))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, 255), uint32(length))
+	}
+}
+
+// partialWriter writes a packet body with partial lengths, so its size need not be known.
+type partialWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func newPartialWriter(w io.Writer, tag byte) (*partialWriter, error) {
+	if _, err := w.Write([]byte{0xc0 | tag}); err != nil {
+		return nil, err
+	}
+	return &partialWriter{w: w, buf: make([]byte, 0, 1<<partialBits)}, nil
+}
+
+func (p *partialWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := copy(p.buf[len(p.buf):cap(p.buf)], b)
+		p.buf = p.buf[:len(p.buf)+n]
+		b = b[n:]
+		written += n
+		if len(p.buf) == cap(p.buf) {
+			if _, err := p.w.Write([]byte{224 + partialBits}); err != nil {
+				return written, err
+			}
+			if _, err := p.w.Write(p.buf); err != nil {
+				return written, err
+			}
+			p.buf = p.buf[:0]
+		}
+	}
+	return written, nil
+}
+
+// Close writes the last part, with a definite length; it does not close the underlying writer.
+func (p *partialWriter) Close() error {
+	// One write: an empty write to an io.Pipe blocks until the next read.
+	_, err := p.w.Write(append(appendHeader(nil, 0, len(p.buf))[1:], p.buf...))
+	return err
+}
+
+// --- Literal data ---
+
+// newLiteralWriter starts a binary literal data packet without a file name or date, which are
+// not protected by the encryption and would only leak metadata.
+func newLiteralWriter(w io.Writer) (*partialWriter, error) {
+	lw, err := newPartialWriter(w, tagLiteral)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := lw.Write([]byte{'b', 0, 0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+	return lw, nil
+}
+
+// readMessage reads the packets of a decrypted message and returns a reader over the literal
+// data. The reader returns io.EOF only once the whole message has been read, so the
+// integrity check of the enclosing packet has run.
+func readMessage(r io.Reader, depth int) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	for {
+		tag, body, err := readPacket(br)
+		if err == io.EOF {
+			return nil, errors.New("invalid message: no literal data")
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagMarker, tagPadding:
+			if _, err := io.Copy(io.Discard, body); err != nil {
+				return nil, err
+			}
+		case tagCompressed:
+			if depth >= maxCompressionDepth {
+				return nil, errors.New("invalid message: too deeply nested compressed packets")
+			}
+			d, err := decompress(body)
+			if err != nil {
+				return nil, err
+			}
+			inner, err := readMessage(d, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			return &messageReader{r: inner, rest: func() error {
+				// The decompressor may stop at the end of the compressed stream.
+				if _, err := io.Copy(io.Discard, body); err != nil {
+					return err
+				}
+				return readTrailing(br)
+			}}, nil
+		case tagLiteral:
+			if err := skipLiteralHeader(body); err != nil {
+				return nil, err
+			}
+			return &messageReader{r: body, rest: func() error { return readTrailing(br) }}, nil
+		default:
+			return nil, fmt.Errorf("unsupported packet in message: tag %d (signed messages are not supported)", tag)
+		}
+	}
+}
+
+func skipLiteralHeader(body io.Reader) error {
+	var b [2]byte // format, file name length
+	if _, err := io.ReadFull(body, b[:]); err != nil {
+		return noEOF(err)
+	}
+	_, err := io.CopyN(io.Discard, body, int64(b[1])+4) // file name, date
+	return noEOF(err)
+}
+
+// readTrailing reads the packets after the literal data, where only padding may follow.
+func readTrailing(br *bufio.Reader) error {
+	for {
+		tag, body, err := readPacket(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if tag != tagPadding && tag != tagMarker {
+			return fmt.Errorf("invalid message: unexpected packet after literal data: tag %d", tag)
+		}
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return err
+		}
+	}
+}
+
+type messageReader struct {
+	r    io.Reader
+	rest func() error
+	err  error
+}
+
+func (m *messageReader) Read(p []byte) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	n, err := m.r.Read(p)
+	if err == io.EOF {
+		if err = m.rest(); err == nil {
+			err = io.EOF
+		}
+	}
+	if err != nil {
+		m.err = err
+	}
+	return n, err
+}
+
+// decompress reads a compressed data packet: ZIP is raw deflate.
+func decompress(body io.Reader) (io.Reader, error) {
+	var alg [1]byte
+	if _, err := io.ReadFull(body, alg[:]); err != nil {
+		return nil, noEOF(err)
+	}
+	switch alg[0] {
+	case 0:
+		return body, nil
+	case 1:
+		return codec.DeflateDecompressor{}.Decompress(body)
+	case 2:
+		return zlib.NewReader(body)
+	case 3:
+		return bzip2.NewReader(body), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm: %d", alg[0])
+	}
+}
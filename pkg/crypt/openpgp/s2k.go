@@ -0,0 +1,203 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // GnuPG's default S2K hash, accepted when reading
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// --- String-to-key ---
+//
+// S2K specifiers (RFC 9580 section 3.7) turn the password into a key:
+//
+//	iterated and salted: 3 || hash(1) || salt(8) || count(1)
+//	argon2:              4 || salt(16) || passes(1) || parallelism(1) || log2 memory in KiB(1)
+//
+// Argon2 is written with v6 SKESK packets; GnuPG does not support it, so SKESK v4 packets are
+// written with iterated and salted SHA-256.
+
+const (
+	s2kIteratedSalted = 3
+	s2kArgon2         = 4
+
+	hashSHA1   = 2
+	hashSHA256 = 8
+	hashSHA384 = 9
+	hashSHA512 = 10
+	hashSHA224 = 11
+
+	iteratedSaltSize = 8
+	argon2SaltSize   = 16
+
+	// iteratedCount hashes 16 MiB of salted password: (16 + 0) << (14 + 6).
+	iteratedCount = 0xe0
+
+	// Decrypt reads the Argon2 cost from the message, so it is capped like the password KDF of
+	// aesgcm streams (aesgcm.MaxArgon2Memory and aesgcm.MaxArgon2Time).
+	MaxArgon2MemoryExp = 20 // 1 GiB
+	MaxArgon2Passes    = 16
+)
+
+// Argon2S2K are the Argon2id parameters of new v6 SKESK packets; the memory cost is
+// 2^MemoryExp KiB.
+type Argon2S2K struct {
+	Passes      uint8
+	Parallelism uint8
+	MemoryExp   uint8
+}
+
+// DefaultArgon2S2K is the second recommended option of RFC 9580 section 3.7.1.4 (64 MiB).
+// The first needs 2 GiB, more than MaxArgon2MemoryExp allows.
+var DefaultArgon2S2K = Argon2S2K{Passes: 3, Parallelism: 4, MemoryExp: 16}
+
+func (p Argon2S2K) validate() error {
+	switch {
+	case p.Passes < 1 || p.Passes > MaxArgon2Passes:
+		return fmt.Errorf("invalid argon2 s2k passes: %d, want 1 to %d", p.Passes, MaxArgon2Passes)
+	case p.Parallelism < 1:
+		return errors.New("invalid argon2 s2k parallelism: 0")
+	case p.MemoryExp > MaxArgon2MemoryExp || uint64(1)<<p.MemoryExp < 8*uint64(p.Parallelism):
+		return fmt.Errorf("invalid argon2 s2k memory: 2^%d KiB, want at least %d KiB and at most 2^%d", p.MemoryExp, 8*uint32(p.Parallelism), MaxArgon2MemoryExp)
+	}
+	return nil
+}
+
+// s2k is a parsed S2K specifier.
+type s2k struct {
+	mode   byte
+	hash   byte // iterated and salted
+	count  int  // iterated and salted: bytes to hash
+	salt   []byte
+	argon2 Argon2S2K
+}
+
+func newArgon2S2K(params Argon2S2K, salt []byte) *s2k {
+	return &s2k{mode: s2kArgon2, salt: salt, argon2: params}
+}
+
+func newIteratedS2K(salt []byte) *s2k {
+	return &s2k{mode: s2kIteratedSalted, hash: hashSHA256, count: decodeCount(iteratedCount), salt: salt}
+}
+
+func decodeCount(c byte) int {
+	return (16 + int(c&15)) << ((c >> 4) + 6)
+}
+
+func (s *s2k) name() string {
+	if s.mode == s2kArgon2 {
+		return "argon2id"
+	}
+	return "openpgp-s2k-" + hashName(s.hash)
+}
+
+func (s *s2k) marshal() []byte {
+	if s.mode == s2kArgon2 {
+		b := append([]byte{s2kArgon2}, s.salt...)
+		return append(b, s.argon2.Passes, s.argon2.Parallelism, s.argon2.MemoryExp)
+	}
+	b := append([]byte{s2kIteratedSalted, s.hash}, s.salt...)
+	return append(b, iteratedCount)
+}
+
+// readS2K reads an S2K specifier. Only the iterated and Argon2 modes derive keys from
+// passwords safely, so the simple and salted modes are refused.
+func readS2K(r io.Reader) (*s2k, error) {
+	var mode [1]byte
+	if _, err := io.ReadFull(r, mode[:]); err != nil {
+		return nil, err
+	}
+	switch mode[0] {
+	case s2kIteratedSalted:
+		b := make([]byte, 1+iteratedSaltSize+1)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if newHash(b[0]) == nil {
+			return nil, fmt.Errorf("unsupported s2k hash: %d", b[0])
+		}
+		return &s2k{mode: s2kIteratedSalted, hash: b[0], salt: b[1 : 1+iteratedSaltSize], count: decodeCount(b[1+iteratedSaltSize])}, nil
+	case s2kArgon2:
+		b := make([]byte, argon2SaltSize+3)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		params := Argon2S2K{Passes: b[argon2SaltSize], Parallelism: b[argon2SaltSize+1], MemoryExp: b[argon2SaltSize+2]}
+		if err := params.validate(); err != nil {
+			return nil, err
+		}
+		return newArgon2S2K(params, b[:argon2SaltSize]), nil
+	default:
+		return nil, fmt.Errorf("unsupported s2k mode: %d", mode[0])
+	}
+}
+
+// deriveKey returns a key of keySize bytes, which the caller clears.
+func (s *s2k) deriveKey(password []byte, keySize int) []byte {
+	if s.mode == s2kArgon2 {
+		p := s.argon2
+		return argon2.IDKey(password, s.salt, uint32(p.Passes), 1<<p.MemoryExp, p.Parallelism, uint32(keySize))
+	}
+
+	// RFC 9580 section 3.7.1.3: hash salt || password repeatedly until count bytes, once
+	// more per digest needed, each hash preloaded with one more zero byte.
+	seed := append(slices.Clone(s.salt), password...)
+	defer clear(seed)
+	input := bytes.Repeat(seed, max(1, 64<<10/len(seed)))
+	defer clear(input)
+	key := make([]byte, 0, keySize+sha512.Size)
+	for i := 0; len(key) < keySize; i++ {
+		h := newHash(s.hash)
+		h.Write(make([]byte, i))
+		n := max(s.count, len(seed))
+		for n > 0 {
+			chunk := input[:min(n, len(input))]
+			h.Write(chunk)
+			n -= len(chunk)
+		}
+		key = h.Sum(key)
+	}
+	clear(key[keySize:])
+	return key[:keySize]
+}
+
+func newHash(id byte) hash.Hash {
+	switch id {
+	case hashSHA1:
+		return sha1.New()
+	case hashSHA224:
+		return sha256.New224()
+	case hashSHA256:
+		return sha256.New()
+	case hashSHA384:
+		return sha512.New384()
+	case hashSHA512:
+		return sha512.New()
+	default:
+		return nil
+	}
+}
+
+func hashName(id byte) string {
+	switch id {
+	case hashSHA1:
+		return "sha1"
+	case hashSHA224:
+		return "sha224"
+	case hashSHA256:
+		return "sha256"
+	case hashSHA384:
+		return "sha384"
+	case hashSHA512:
+		return "sha512"
+	default:
+		return fmt.Sprintf("hash-%d", id)
+	}
+}
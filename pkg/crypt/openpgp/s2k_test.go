@@ -0,0 +1,64 @@
+package openpgp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS2K_MarshalRoundtrip(t *testing.T) {
+	for _, s := range []*s2k{
+		newArgon2S2K(Argon2S2K{Passes: 1, Parallelism: 4, MemoryExp: 13}, bytes.Repeat([]byte{1}, argon2SaltSize)),
+		newIteratedS2K(bytes.Repeat([]byte{2}, iteratedSaltSize)),
+	} {
+		got, err := readS2K(bytes.NewReader(s.marshal()))
+		require.NoError(t, err)
+		assert.Equal(t, s, got)
+	}
+}
+
+func TestS2K_IteratedCount(t *testing.T) {
+	assert.Equal(t, 65536, decodeCount(0x60))
+	assert.Equal(t, 16<<20, decodeCount(iteratedCount))
+	assert.Equal(t, 65011712, decodeCount(0xff)) // GnuPG's default
+}
+
+func TestS2K_IteratedKey(t *testing.T) {
+	salt := []byte("saltsalt")
+	s := newIteratedS2K(salt)
+	s.count = decodeCount(0x60)
+
+	// The hashed input is salt || password, repeated up to count bytes, and the key is longer
+	// than a SHA-256 digest only with a second, zero-prefixed hash.
+	key := s.deriveKey([]byte("pw"), 32)
+	assert.Len(t, key, 32)
+	assert.Equal(t, key, s.deriveKey([]byte("pw"), 32))
+	assert.NotEqual(t, key, s.deriveKey([]byte("px"), 32))
+	long := s.deriveKey([]byte("pw"), 48)
+	assert.Equal(t, key, long[:32])
+
+	// A count below the length of salt || password hashes it once.
+	s.count = 1
+	assert.Len(t, s.deriveKey([]byte("a long password"), 16), 16)
+}
+
+func TestS2K_Limits(t *testing.T) {
+	require.NoError(t, DefaultArgon2S2K.validate())
+	for _, p := range []Argon2S2K{
+		{Passes: 0, Parallelism: 1, MemoryExp: 16},
+		{Passes: MaxArgon2Passes + 1, Parallelism: 1, MemoryExp: 16},
+		{Passes: 1, Parallelism: 0, MemoryExp: 16},
+		{Passes: 1, Parallelism: 4, MemoryExp: 4}, // 16 KiB < 8 KiB per lane
+		{Passes: 1, Parallelism: 1, MemoryExp: MaxArgon2MemoryExp + 1},
+	} {
+		require.Error(t, p.validate(), "%+v", p)
+	}
+
+	// Simple and salted S2Ks are refused.
+	_, err := readS2K(bytes.NewReader([]byte{0, hashSHA256}))
+	require.ErrorContains(t, err, "unsupported s2k mode")
+	_, err = readS2K(bytes.NewReader(append([]byte{s2kIteratedSalted, 1}, make([]byte, 9)...)))
+	require.ErrorContains(t, err, "unsupported s2k hash")
+}
@@ -0,0 +1,47 @@
+#!/bin/sh
+# Regenerates the OpenPGP fixtures in this directory.
+#
+# GnuPG (2.2.40 was used) writes v4 SKESK + SEIPD v1 messages, compressed with ZIP by default.
+# It cannot write v6 SKESK + SEIPD v2 messages or Argon2 S2Ks, so those come from ProtonMail's
+# go-crypto (v1.5.2), an RFC 9580 implementation, and are checked against no other
+# implementation. tools/openpgp-fixtures runs in a scratch module, so go-crypto stays out of
+# go.mod; it needs a Go toolchain and network access. The tests only read the results.
+# vectors.json lists the expected contents; update it if the sources below change.
+set -eu
+
+here=$(cd "$(dirname "$0")" && pwd)
+src=$(mktemp -d)
+trap 'rm -rf "$src"' EXIT
+
+i=0
+while [ $i -lt 600 ]; do
+	echo "line $i: downstream consumers only accept gpg output"
+	i=$((i + 1))
+done > "$src/message.txt"
+printf 'hi\n' > "$src/short.txt"
+
+export GNUPGHOME="$src/gnupg"
+mkdir -m 700 "$GNUPGHOME"
+gpg_symmetric() {
+	gpg --batch --quiet --pinentry-mode loopback --passphrase 'correct horse' \
+		--cipher-algo AES256 --symmetric "$@"
+}
+rm -f "$here"/gpg-*.gpg "$here"/gpg-*.asc "$here"/gocrypto-*
+gpg_symmetric --output "$here/gpg-default.gpg" "$src/message.txt"
+gpg_symmetric --armor --output "$here/gpg-armor.asc" "$src/message.txt"
+gpg_symmetric --compress-algo none --output "$here/gpg-uncompressed.gpg" "$src/message.txt"
+gpgconf --kill gpg-agent
+
+mkdir "$src/gocrypto"
+sed '/^\/\/go:build ignore$/d' "$here/../../../../tools/openpgp-fixtures/main.go" > "$src/gocrypto/main.go"
+(
+	cd "$src/gocrypto"
+	go mod init openpgp-fixtures >/dev/null 2>&1
+	go get github.com/ProtonMail/go-crypto@v1.5.2
+	go mod tidy
+	go run . "$src/message.txt" "$src/short.txt" "$here"
+)
+
+for f in message.txt short.txt; do
+	printf '%s %s %s\n' "$f" "$(wc -c < "$src/$f" | tr -d ' ')" "$(sha256sum "$src/$f" | cut -d' ' -f1)"
+done
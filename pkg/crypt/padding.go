@@ -0,0 +1,36 @@
+package crypt
+
+import "math/bits"
+
+// Padding decides how far a stream of n plaintext bytes is padded to hide its exact length.
+// PaddedLength must return a value of at least n.
+type Padding interface {
+	PaddedLength(n int64) int64
+}
+
+// PadmePadding implements the PADMÉ scheme from "Reducing Metadata Leakage from Encrypted Files
+// and Communication with PURBs" (Nikitin et al., 2019). It leaks O(log log n) bits of the length
+// with at most 12% overhead.
+type PadmePadding struct{}
+
+func (PadmePadding) PaddedLength(n int64) int64 {
+	if n < 2 {
+		return n
+	}
+	e := bits.Len64(uint64(n)) - 1 // floor(log2 n)
+	s := bits.Len64(uint64(e))     // floor(log2 e) + 1
+	mask := int64(1)<<(e-s) - 1
+	return (n + mask) &^ mask
+}
+
+// BucketPadding pads to the next multiple of Size bytes.
+type BucketPadding struct {
+	Size int64
+}
+
+func (b BucketPadding) PaddedLength(n int64) int64 {
+	if b.Size <= 0 {
+		return n
+	}
+	return (n + b.Size - 1) / b.Size * b.Size
+}
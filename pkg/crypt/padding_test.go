@@ -0,0 +1,31 @@
+package crypt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPadmePadding(t *testing.T) {
+	p := PadmePadding{}
+	for n, want := range map[int64]int64{
+		0: 0, 1: 1, 2: 2, 3: 3, 9: 10, 17: 18, 100: 104, 1000: 1024,
+		1 << 20: 1 << 20, 1<<20 + 1: 1<<20 + 1<<15,
+	} {
+		assert.Equal(t, want, p.PaddedLength(n), "n=%d", n)
+	}
+	for n := int64(1); n < 1<<16; n += 17 {
+		got := p.PaddedLength(n)
+		assert.GreaterOrEqual(t, got, n)
+		assert.LessOrEqual(t, float64(got-n)/float64(n), 0.12)
+	}
+}
+
+func TestBucketPadding(t *testing.T) {
+	p := BucketPadding{Size: 4096}
+	assert.Equal(t, int64(0), p.PaddedLength(0))
+	assert.Equal(t, int64(4096), p.PaddedLength(1))
+	assert.Equal(t, int64(4096), p.PaddedLength(4096))
+	assert.Equal(t, int64(8192), p.PaddedLength(4097))
+	assert.Equal(t, int64(10), BucketPadding{}.PaddedLength(10))
+}
@@ -0,0 +1,37 @@
+package crypt
+
+import "io"
+
+// ByteRange is a range of plaintext offsets: [Offset, Offset+Length).
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// DamageReport lists what a salvage reader could not recover.
+type DamageReport struct {
+	// Damaged holds the plaintext ranges of chunks that failed authentication.
+	Damaged []ByteRange
+	// Truncated is set when the stream format marks its last chunk and no authentic
+	// last chunk was found, so data after the last recovered byte may be missing.
+	Truncated bool
+}
+
+// OK reports whether the stream was recovered completely.
+func (d *DamageReport) OK() bool {
+	return len(d.Damaged) == 0 && !d.Truncated
+}
+
+// SalvageOptions configures best-effort decryption.
+type SalvageOptions struct {
+	// ZeroFill replaces damaged chunks with zeros, so recovered data keeps its offsets.
+	// Otherwise damaged chunks are omitted.
+	ZeroFill bool
+}
+
+// SalvageReader returns everything that can still be authenticated and skips damaged chunks.
+// Report is complete once Read has returned io.EOF.
+type SalvageReader interface {
+	io.Reader
+	Report() *DamageReport
+}
@@ -0,0 +1,73 @@
+package crypt
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrKeyDestroyed is returned when a crypter is used after its key was destroyed.
+var ErrKeyDestroyed = errors.New("secret key has been destroyed")
+
+// SecretKey holds a password or key in a []byte that Destroy zeroes, unlike a string, which
+// is immutable and stays on the heap until the garbage collector reuses its memory.
+//
+// Crypters zero the keys they derive from a SecretKey once the stream cipher is set up. The
+// expanded key schedule inside crypto/aes cannot be wiped and lives as long as the stream.
+type SecretKey struct {
+	mu        sync.RWMutex
+	b         []byte
+	destroyed bool
+}
+
+// Destroyer is implemented by crypters and recipients that hold secret keys.
+// Destroy zeroes the keys and drops what cannot be zeroed; afterwards every use fails with
+// ErrKeyDestroyed, including for a crypter configured with a plain key field instead of a SecretKey.
+type Destroyer interface {
+	Destroy()
+}
+
+// NewSecretKey copies b, so the caller can wipe its own copy right away.
+func NewSecretKey(b []byte) *SecretKey {
+	return &SecretKey{b: append(make([]byte, 0, len(b)), b...)}
+}
+
+// Use calls fn with the key bytes, which must not be retained after fn returns. Destroy
+// waits for running calls.
+func (k *SecretKey) Use(fn func(key []byte) error) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.destroyed {
+		return ErrKeyDestroyed
+	}
+	return fn(k.b)
+}
+
+// Len returns the key length, or 0 once destroyed.
+func (k *SecretKey) Len() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.b)
+}
+
+// Destroy zeroes the key. Later uses fail with ErrKeyDestroyed.
+func (k *SecretKey) Destroy() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	clear(k.b)
+	k.b, k.destroyed = nil, true
+}
+
+func (k *SecretKey) Destroyed() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.destroyed
+}
+
+// UseKey calls fn with the bytes of secret when it is set, and with raw otherwise. It lets
+// crypters accept both a SecretKey field and a plain key field.
+func UseKey(secret *SecretKey, raw []byte, fn func(key []byte) error) error {
+	if secret != nil {
+		return secret.Use(fn)
+	}
+	return fn(raw)
+}
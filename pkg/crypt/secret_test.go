@@ -0,0 +1,39 @@
+package crypt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretKey(t *testing.T) {
+	b := []byte("correct horse")
+	k := NewSecretKey(b)
+	clear(b)
+	assert.Equal(t, 13, k.Len())
+
+	var seen string
+	require.NoError(t, k.Use(func(key []byte) error {
+		seen = string(key)
+		return nil
+	}))
+	assert.Equal(t, "correct horse", seen, "the key is a copy of the input")
+
+	var buf []byte
+	require.NoError(t, k.Use(func(key []byte) error {
+		buf = key // retained only to check the wipe below
+		return nil
+	}))
+	k.Destroy()
+	assert.True(t, k.Destroyed())
+	assert.Equal(t, make([]byte, 13), buf, "Destroy zeroes the buffer")
+	assert.Zero(t, k.Len())
+	require.ErrorIs(t, k.Use(func([]byte) error { return nil }), ErrKeyDestroyed)
+	require.ErrorIs(t, UseKey(k, []byte("raw"), func([]byte) error { return nil }), ErrKeyDestroyed)
+
+	require.NoError(t, UseKey(nil, []byte("raw"), func(key []byte) error {
+		assert.Equal(t, "raw", string(key))
+		return nil
+	}))
+}
@@ -0,0 +1,236 @@
+// Package secretstream implements libsodium's crypto_secretstream_xchacha20poly1305
+// wire format, so that streams can be exchanged with services using libsodium.
+//
+// A stream is the 24-byte secretstream header followed by the pushed messages. Every
+// message holds ChunkSize bytes of plaintext (ChunkSize+ABytes on the wire), except the
+// last one, which is shorter (possibly empty) and carries TagFinal:
+//
+//	header(24) || push(chunk_0, TagMessage) || ... || push(chunk_n, TagFinal)
+//
+// This is the framing of libsodium's file encryption example, with a fixed chunk size
+// agreed upon by both sides.
+package secretstream
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+const DefaultChunkSize = 64 * 1024
+
+// --- XChaCha20-Poly1305 secretstream Crypter ---
+
+// XChaCha20Poly1305Crypter encrypts and decrypts libsodium secretstream streams with a raw 32-byte key.
+type XChaCha20Poly1305Crypter struct {
+	Key []byte
+
+	// SecretKey, when set, is used instead of Key.
+	SecretKey *crypt.SecretKey
+
+	// ChunkSize is the plaintext size of every message but the last; DefaultChunkSize if zero.
+	ChunkSize int
+
+	// RekeyInterval, if positive, makes the writer tag every RekeyInterval-th message
+	// with TagRekey. Readers follow rekeying automatically.
+	RekeyInterval int
+}
+
+var (
+	_ crypt.Crypter    = &XChaCha20Poly1305Crypter{}
+	_ crypt.Destroyer  = &XChaCha20Poly1305Crypter{}
+	_ policy.Describer = &XChaCha20Poly1305Crypter{}
+)
+
+// NewXChaCha20Poly1305Crypter copies key into a crypt.SecretKey, so the caller can wipe key afterwards.
+func NewXChaCha20Poly1305Crypter(key []byte) crypt.Crypter {
+	return &XChaCha20Poly1305Crypter{
+		SecretKey: crypt.NewSecretKey(key),
+	}
+}
+
+func (c *XChaCha20Poly1305Crypter) Destroy() {
+	if c.SecretKey == nil {
+		c.SecretKey = &crypt.SecretKey{}
+	}
+	c.SecretKey.Destroy()
+	clear(c.Key)
+	c.Key = nil
+}
+
+func (c *XChaCha20Poly1305Crypter) newState(header []byte) (*state, error) {
+	var st *state
+	err := crypt.UseKey(c.SecretKey, c.Key, func(key []byte) error {
+		var err error
+		st, err = newState(key, header)
+		return err
+	})
+	return st, err
+}
+
+func (c *XChaCha20Poly1305Crypter) FileExtension() string {
+	return ".secretstream"
+}
+
+func (c *XChaCha20Poly1305Crypter) Name() string {
+	return "xchacha20poly1305-secretstream"
+}
+
+func (c *XChaCha20Poly1305Crypter) chunkSize() int {
+	if c.ChunkSize > 0 {
+		return c.ChunkSize
+	}
+	return DefaultChunkSize
+}
+
+func (c *XChaCha20Poly1305Crypter) Algorithms() []policy.Algorithm {
+	return []policy.Algorithm{{Kind: policy.Cipher, Name: "xchacha20-poly1305", KeyBits: KeySize * 8}}
+}
+
+func (c *XChaCha20Poly1305Crypter) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	header := make([]byte, HeaderSize)
+	if _, err := rand.Read(header); err != nil {
+		return nil, err
+	}
+	st, err := c.newState(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &pushWriter{
+		state:         st,
+		w:             w,
+		buf:           make([]byte, 0, c.chunkSize()),
+		rekeyInterval: c.RekeyInterval,
+	}, nil
+}
+
+type pushWriter struct {
+	state         *state
+	w             io.Writer
+	buf           []byte
+	rekeyInterval int
+	pushed        int
+	closed        bool
+}
+
+func (p *pushWriter) Write(b []byte) (int, error) {
+	if p.closed {
+		return 0, errors.New("write on closed writer")
+	}
+	total := 0
+	for len(b) > 0 {
+		n := min(cap(p.buf)-len(p.buf), len(b))
+		p.buf = append(p.buf, b[:n]...)
+		b = b[n:]
+		total += n
+
+		if len(p.buf) == cap(p.buf) {
+			tag := TagMessage
+			if p.rekeyInterval > 0 && (p.pushed+1)%p.rekeyInterval == 0 {
+				tag = TagRekey
+			}
+			if err := p.push(tag); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// Close pushes the remaining plaintext (possibly none) as the TagFinal message.
+func (p *pushWriter) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	defer p.state.wipe()
+	return p.push(TagFinal)
+}
+
+func (p *pushWriter) push(tag byte) error {
+	if _, err := p.w.Write(p.state.push(nil, p.buf, nil, tag)); err != nil {
+		return err
+	}
+	p.pushed++
+	p.buf = p.buf[:0]
+	return nil
+}
+
+func (c *XChaCha20Poly1305Crypter) Decrypt(r io.Reader) (io.Reader, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	st, err := c.newState(header)
+	if err != nil {
+		return nil, err
+	}
+	return &pullReader{
+		state:      st,
+		r:          r,
+		ciphertext: make([]byte, c.chunkSize()+ABytes),
+	}, nil
+}
+
+type pullReader struct {
+	state      *state
+	r          io.Reader
+	ciphertext []byte
+	buf        []byte
+	final      bool
+}
+
+func (p *pullReader) Read(b []byte) (int, error) {
+	for len(p.buf) == 0 {
+		if p.final {
+			return 0, io.EOF
+		}
+		if err := p.pull(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+func (p *pullReader) pull() error {
+	n, err := io.ReadFull(p.r, p.ciphertext)
+	switch {
+	case errors.Is(err, io.EOF):
+		return errors.New("truncated stream: missing final message")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		// A short message can only be the final one; checked below.
+	case err != nil:
+		return err
+	}
+
+	message, tag, err := p.state.pull(nil, p.ciphertext[:n], nil)
+	if err != nil {
+		return err
+	}
+	if tag == TagFinal {
+		p.final = true
+		p.state.wipe()
+		// Nothing may follow the final message.
+		if _, err := io.ReadFull(p.r, make([]byte, 1)); !errors.Is(err, io.EOF) {
+			return errors.New("unexpected data after final message")
+		}
+	} else if n < len(p.ciphertext) {
+		return errors.New("truncated stream: missing final message")
+	}
+	p.buf = message
+	return nil
+}
@@ -0,0 +1,233 @@
+package secretstream
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+type fixture struct {
+	Name            string `json:"name"`
+	ChunkSize       int    `json:"chunk_size"`
+	PlaintextSize   int    `json:"plaintext_size"`
+	PlaintextSHA256 string `json:"plaintext_sha256"`
+	CiphertextFile  string `json:"ciphertext_file"`
+}
+
+func loadFixtures(t *testing.T) ([]byte, []fixture) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "vectors.json"))
+	require.NoError(t, err)
+
+	var file struct {
+		Key     string    `json:"key"`
+		Vectors []fixture `json:"vectors"`
+	}
+	require.NoError(t, json.Unmarshal(data, &file))
+	key, err := hex.DecodeString(file.Key)
+	require.NoError(t, err)
+	return key, file.Vectors
+}
+
+func readFixture(t *testing.T, f fixture) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", f.CiphertextFile))
+	require.NoError(t, err)
+	return data
+}
+
+func pattern(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func decryptAll(crypter *XChaCha20Poly1305Crypter, ciphertext []byte) ([]byte, error) {
+	r, err := crypter.Decrypt(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestXChaCha20Poly1305Crypter_DecryptLibsodiumFixtures(t *testing.T) {
+	key, fixtures := loadFixtures(t)
+	for _, f := range fixtures {
+		t.Run(f.Name, func(t *testing.T) {
+			crypter := &XChaCha20Poly1305Crypter{Key: key, ChunkSize: f.ChunkSize}
+			plaintext, err := decryptAll(crypter, readFixture(t, f))
+			require.NoError(t, err)
+
+			sum := sha256.Sum256(plaintext)
+			assert.Equal(t, f.PlaintextSize, len(plaintext))
+			assert.Equal(t, f.PlaintextSHA256, hex.EncodeToString(sum[:]))
+		})
+	}
+}
+
+func TestXChaCha20Poly1305Crypter_PushMatchesLibsodium(t *testing.T) {
+	// Re-encrypt every fixture under its own header with the same tags and compare byte by byte.
+	key, fixtures := loadFixtures(t)
+	for _, f := range fixtures {
+		t.Run(f.Name, func(t *testing.T) {
+			ciphertext := readFixture(t, f)
+			header := ciphertext[:HeaderSize]
+
+			pullState, err := newState(key, header)
+			require.NoError(t, err)
+			pushState, err := newState(key, header)
+			require.NoError(t, err)
+
+			out := append([]byte(nil), header...)
+			for rest := ciphertext[HeaderSize:]; len(rest) > 0; {
+				n := min(f.ChunkSize+ABytes, len(rest))
+				message, tag, err := pullState.pull(nil, rest[:n], nil)
+				require.NoError(t, err)
+				out = pushState.push(out, message, nil, tag)
+				rest = rest[n:]
+			}
+			assert.Equal(t, ciphertext, out)
+		})
+	}
+}
+
+func TestXChaCha20Poly1305Crypter_Roundtrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, KeySize)
+	for _, tc := range []struct {
+		chunkSize, rekeyInterval, size int
+	}{
+		{0, 0, 0},
+		{0, 0, 1},
+		{0, 0, DefaultChunkSize},
+		{0, 0, 3*DefaultChunkSize + 5},
+		{16, 3, 1000},
+		{100, 1, 1000},
+	} {
+		crypter := &XChaCha20Poly1305Crypter{Key: key, ChunkSize: tc.chunkSize, RekeyInterval: tc.rekeyInterval}
+		plaintext := pattern(tc.size)
+
+		var buf bytes.Buffer
+		w, err := crypter.Encrypt(&buf)
+		require.NoError(t, err)
+		_, err = w.Write(plaintext)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		decrypted, err := decryptAll(crypter, buf.Bytes())
+		require.NoError(t, err, "%+v", tc)
+		assert.Equal(t, plaintext, decrypted, "%+v", tc)
+	}
+}
+
+func TestXChaCha20Poly1305Crypter_RejectsModifiedStreams(t *testing.T) {
+	key, fixtures := loadFixtures(t)
+	var f fixture
+	for _, fx := range fixtures {
+		if fx.Name == "rekey-every-2nd" {
+			f = fx
+		}
+	}
+	crypter := &XChaCha20Poly1305Crypter{Key: key, ChunkSize: f.ChunkSize}
+	ciphertext := readFixture(t, f)
+	messageSize := f.ChunkSize + ABytes
+
+	t.Run("drop final message", func(t *testing.T) {
+		// Ends on a message boundary, but the last message is not tagged final.
+		truncated := ciphertext[:HeaderSize+2*messageSize]
+		_, err := decryptAll(crypter, truncated)
+		require.ErrorContains(t, err, "truncated stream")
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		for i := HeaderSize; i < len(ciphertext); i += 97 {
+			_, err := decryptAll(crypter, ciphertext[:i])
+			assert.Error(t, err, "truncated at %d", i)
+		}
+	})
+
+	t.Run("trailing data", func(t *testing.T) {
+		_, err := decryptAll(crypter, append(append([]byte(nil), ciphertext...), 0))
+		require.Error(t, err)
+	})
+
+	t.Run("reorder messages", func(t *testing.T) {
+		reordered := append([]byte(nil), ciphertext...)
+		first := HeaderSize
+		second := HeaderSize + messageSize
+		copy(reordered[first:second], ciphertext[second:second+messageSize])
+		copy(reordered[second:second+messageSize], ciphertext[first:second])
+		_, err := decryptAll(crypter, reordered)
+		require.ErrorContains(t, err, "decryption failed")
+	})
+
+	t.Run("flip bits", func(t *testing.T) {
+		for i := 0; i < len(ciphertext); i += 31 {
+			ct := append([]byte(nil), ciphertext...)
+			ct[i] ^= 0x80
+			_, err := decryptAll(crypter, ct)
+			assert.Error(t, err, "flipped byte %d", i)
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		wrong := &XChaCha20Poly1305Crypter{Key: make([]byte, KeySize), ChunkSize: f.ChunkSize}
+		_, err := decryptAll(wrong, ciphertext)
+		require.ErrorContains(t, err, "decryption failed")
+	})
+}
+
+func TestXChaCha20Poly1305Crypter_InvalidKey(t *testing.T) {
+	crypter := NewXChaCha20Poly1305Crypter([]byte("short"))
+	_, err := crypter.Encrypt(io.Discard)
+	require.Error(t, err)
+	_, err = crypter.Decrypt(bytes.NewReader(make([]byte, HeaderSize)))
+	require.Error(t, err)
+}
+
+func TestXChaCha20Poly1305Crypter_Destroy(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	crypter := NewXChaCha20Poly1305Crypter(key).(*XChaCha20Poly1305Crypter)
+	clear(key) // the constructor keeps its own copy
+
+	var buf bytes.Buffer
+	w, err := crypter.Encrypt(&buf)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("secret payload"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, [KeySize]byte{}, w.(*pushWriter).state.k, "the stream key is wiped on Close")
+
+	crypter.Destroy()
+	_, err = crypter.Decrypt(bytes.NewReader(buf.Bytes()))
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+
+	withKey := &XChaCha20Poly1305Crypter{Key: bytes.Repeat([]byte{7}, KeySize)}
+	withKey.Destroy()
+	_, err = withKey.Encrypt(io.Discard)
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+}
+
+func TestXChaCha20Poly1305Crypter_Policy(t *testing.T) {
+	t.Cleanup(func() { policy.SetDefault(nil) })
+	policy.SetDefault(policy.FIPS())
+
+	c := NewXChaCha20Poly1305Crypter(bytes.Repeat([]byte{7}, KeySize))
+	_, err := c.Encrypt(io.Discard)
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+	require.ErrorContains(t, err, `refuses cipher xchacha20-poly1305: violates rule "allowed cipher algorithms: aes-256-gcm"`)
+	_, err = c.Decrypt(bytes.NewReader(nil))
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+}
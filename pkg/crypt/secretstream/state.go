@@ -0,0 +1,158 @@
+package secretstream
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/poly1305" //nolint:staticcheck // secretstream needs raw Poly1305, there is no AEAD for this construction
+)
+
+// --- crypto_secretstream_xchacha20poly1305 state ---
+
+const (
+	KeySize    = 32
+	HeaderSize = 24
+	ABytes     = 1 + poly1305.TagSize // encrypted tag byte + MAC
+
+	counterSize = 4
+	inonceSize  = 8
+)
+
+// Message tags, as defined by libsodium.
+const (
+	TagMessage byte = 0x00
+	TagPush    byte = 0x01
+	TagRekey   byte = 0x02
+	TagFinal   byte = TagPush | TagRekey
+)
+
+var errMessageForged = errors.New("decryption failed: tampering or corruption detected")
+
+type state struct {
+	k     [KeySize]byte
+	nonce [chacha20.NonceSize]byte // counter(4, LE) || inonce(8)
+}
+
+// newState initializes the state from the key and the 24-byte stream header.
+func newState(key, header []byte) (*state, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("invalid key size")
+	}
+	subkey, err := chacha20.HChaCha20(key, header[:16])
+	if err != nil {
+		return nil, err
+	}
+	s := &state{}
+	copy(s.k[:], subkey)
+	clear(subkey)
+	s.resetCounter()
+	copy(s.nonce[counterSize:], header[16:HeaderSize])
+	return s, nil
+}
+
+func (s *state) resetCounter() {
+	clear(s.nonce[:counterSize])
+	s.nonce[0] = 1
+}
+
+func (s *state) stream(counter uint32) *chacha20.Cipher {
+	c, err := chacha20.NewUnauthenticatedCipher(s.k[:], s.nonce[:])
+	if err != nil {
+		panic(err) // unreachable: key and nonce sizes are fixed
+	}
+	if counter > 0 {
+		c.SetCounter(counter)
+	}
+	return c
+}
+
+// mac computes the Poly1305 tag over ad, the 64-byte tag block and the ciphertext.
+func (s *state) mac(ad, block, ciphertext []byte) []byte {
+	var macKey [32]byte
+	s.stream(0).XORKeyStream(macKey[:], macKey[:])
+
+	var pad [16]byte
+	h := poly1305.New(&macKey)
+	_, _ = h.Write(ad)
+	_, _ = h.Write(pad[:(0x10-len(ad))&0xf])
+	_, _ = h.Write(block)
+	_, _ = h.Write(ciphertext)
+	_, _ = h.Write(pad[:(0x10-len(block)+len(ciphertext))&0xf])
+
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(ad)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(block)+len(ciphertext)))
+	_, _ = h.Write(lengths[:])
+	return h.Sum(nil)
+}
+
+// push encrypts one message; the output is encryptedTag(1) || ciphertext || mac(16).
+func (s *state) push(dst, message, ad []byte, tag byte) []byte {
+	var block [64]byte
+	block[0] = tag
+	s.stream(1).XORKeyStream(block[:], block[:])
+
+	out := make([]byte, ABytes+len(message))
+	out[0] = block[0]
+	ciphertext := out[1 : 1+len(message)]
+	s.stream(2).XORKeyStream(ciphertext, message)
+
+	mac := s.mac(ad, block[:], ciphertext)
+	copy(out[1+len(message):], mac)
+
+	s.advance(mac, tag)
+	return append(dst, out...)
+}
+
+// pull authenticates and decrypts one message produced by push.
+func (s *state) pull(dst, in, ad []byte) ([]byte, byte, error) {
+	if len(in) < ABytes {
+		return nil, 0, errMessageForged
+	}
+	var block [64]byte
+	block[0] = in[0]
+	s.stream(1).XORKeyStream(block[:], block[:])
+	tag := block[0]
+	block[0] = in[0]
+
+	ciphertext := in[1 : len(in)-poly1305.TagSize]
+	mac := s.mac(ad, block[:], ciphertext)
+	if subtle.ConstantTimeCompare(mac, in[len(in)-poly1305.TagSize:]) != 1 {
+		return nil, 0, errMessageForged
+	}
+
+	message := make([]byte, len(ciphertext))
+	s.stream(2).XORKeyStream(message, ciphertext)
+
+	s.advance(mac, tag)
+	return append(dst, message...), tag, nil
+}
+
+func (s *state) advance(mac []byte, tag byte) {
+	subtle.XORBytes(s.nonce[counterSize:], s.nonce[counterSize:], mac[:inonceSize])
+	counter := binary.LittleEndian.Uint32(s.nonce[:counterSize]) + 1
+	binary.LittleEndian.PutUint32(s.nonce[:counterSize], counter)
+	if tag&TagRekey != 0 || counter == 0 {
+		s.rekey()
+	}
+}
+
+// rekey replaces the key and inonce with keystream derived from the current state.
+func (s *state) rekey() {
+	var buf [KeySize + inonceSize]byte
+	copy(buf[:KeySize], s.k[:])
+	copy(buf[KeySize:], s.nonce[counterSize:])
+	s.stream(0).XORKeyStream(buf[:], buf[:])
+
+	copy(s.k[:], buf[:KeySize])
+	copy(s.nonce[counterSize:], buf[KeySize:])
+	clear(buf[:])
+	s.resetCounter()
+}
+
+// wipe zeroes the key once the final message has been pushed or pulled.
+func (s *state) wipe() {
+	clear(s.k[:])
+}
@@ -0,0 +1,75 @@
+#!/usr/bin/env python3
+"""Regenerates the libsodium secretstream fixtures in this directory.
+
+Requires a system libsodium (libsodium.so.23); the ciphertexts are produced by
+crypto_secretstream_xchacha20poly1305_push itself, framed as header || messages.
+"""
+import ctypes
+import ctypes.util
+import hashlib
+import json
+import os
+
+sodium = ctypes.CDLL(ctypes.util.find_library("sodium") or "libsodium.so.23")
+assert sodium.sodium_init() >= 0
+
+STATE_BYTES = sodium.crypto_secretstream_xchacha20poly1305_statebytes()
+HEADER_BYTES = sodium.crypto_secretstream_xchacha20poly1305_headerbytes()
+ABYTES = sodium.crypto_secretstream_xchacha20poly1305_abytes()
+TAG_MESSAGE, TAG_PUSH, TAG_REKEY, TAG_FINAL = 0, 1, 2, 3
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+KEY = hashlib.sha256(b"streamcrypt secretstream fixture key").digest()
+
+
+def pattern(n):
+    return bytes((i * 7) & 0xFF for i in range(n))
+
+
+def push_stream(plaintext, chunk_size, tag_for):
+    state = ctypes.create_string_buffer(STATE_BYTES)
+    header = ctypes.create_string_buffer(HEADER_BYTES)
+    sodium.crypto_secretstream_xchacha20poly1305_init_push(state, header, KEY)
+    out = bytearray(header.raw)
+    chunks = [plaintext[i:i + chunk_size] for i in range(0, len(plaintext), chunk_size)]
+    # The last message is the remainder, or an empty one if the plaintext fills whole chunks.
+    if not chunks or len(chunks[-1]) == chunk_size:
+        chunks.append(b"")
+    for i, chunk in enumerate(chunks):
+        tag = TAG_FINAL if i == len(chunks) - 1 else tag_for(i)
+        c = ctypes.create_string_buffer(len(chunk) + ABYTES)
+        clen = ctypes.c_ulonglong()
+        rc = sodium.crypto_secretstream_xchacha20poly1305_push(
+            state, c, ctypes.byref(clen), chunk, ctypes.c_ulonglong(len(chunk)), None, ctypes.c_ulonglong(0), ctypes.c_ubyte(tag))
+        assert rc == 0
+        out += c.raw[:clen.value]
+    return bytes(out)
+
+
+CASES = [
+    ("empty", 4096, 0, lambda i: TAG_MESSAGE),
+    ("short", 4096, 100, lambda i: TAG_MESSAGE),
+    ("exact-chunk", 4096, 4096, lambda i: TAG_MESSAGE),
+    ("multi-chunk", 4096, 10000, lambda i: TAG_MESSAGE),
+    ("rekey-every-2nd", 1024, 5000, lambda i: TAG_REKEY if (i + 1) % 2 == 0 else TAG_MESSAGE),
+    ("push-tag", 1024, 3000, lambda i: TAG_PUSH if i == 1 else TAG_MESSAGE),
+    ("default-chunk-size", 64 * 1024, 150000, lambda i: TAG_MESSAGE),
+]
+
+vectors = []
+for name, chunk_size, size, tag_for in CASES:
+    plaintext = pattern(size)
+    ciphertext = push_stream(plaintext, chunk_size, tag_for)
+    with open(os.path.join(HERE, name + ".bin"), "wb") as f:
+        f.write(ciphertext)
+    vectors.append({
+        "name": name,
+        "chunk_size": chunk_size,
+        "plaintext_size": size,
+        "plaintext_sha256": hashlib.sha256(plaintext).hexdigest(),
+        "ciphertext_file": name + ".bin",
+    })
+
+with open(os.path.join(HERE, "vectors.json"), "w") as f:
+    json.dump({"key": KEY.hex(), "plaintext": "byte i = (i * 7) & 0xff", "vectors": vectors}, f, indent=2)
+    f.write("\n")
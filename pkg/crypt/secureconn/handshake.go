@@ -0,0 +1,231 @@
+package secureconn
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// --- Handshake ---
+//
+//	client -> server: magic(8) || mode(1) || rekeyAfter(8) || ephemeral(32)
+//	server -> client: magic(8) || mode(1) || rekeyAfter(8) || ephemeral(32) || server confirm(32)
+//	client -> server: client confirm(32)
+//
+// The transcript hash covers both hello messages and both static public keys. The traffic
+// secret is HKDF-Extract(transcript, ee || es || se || ss || PSK), where the static-key terms
+// are present only with static keys. Each side proves it derived the same secret with an
+// HMAC of the transcript, and only then is application data exchanged. rekeyAfter is the
+// sender's own setting, so the receiver knows when each direction moves to its next key.
+
+const (
+	handshakeMagic = "SCONNv1\x00"
+	keySize        = 32
+	helloSize      = len(handshakeMagic) + 1 + 8 + keySize
+
+	modePSK    byte = 1 << 0
+	modeStatic byte = 1 << 1
+
+	labelClientKey     = "streamcrypt secureconn client write key"
+	labelServerKey     = "streamcrypt secureconn server write key"
+	labelClientConfirm = "streamcrypt secureconn client confirm"
+	labelServerConfirm = "streamcrypt secureconn server confirm"
+	labelRekey         = "streamcrypt secureconn rekey"
+)
+
+var ErrHandshake = errors.New("handshake failed: peer authentication failed")
+
+// trafficKeys are the results of a handshake, from one side's point of view.
+type trafficKeys struct {
+	writeKey, readKey               []byte
+	writeRekeyAfter, readRekeyAfter uint64
+}
+
+func (c *Conn) clientHandshake() (*trafficKeys, error) {
+	mode := c.config.mode()
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	clientHello := hello(mode, c.config.rekeyAfter(), ephemeral.PublicKey())
+	if _, err := c.conn.Write(clientHello); err != nil {
+		return nil, err
+	}
+
+	serverHello := make([]byte, helloSize+sha256.Size)
+	if _, err := io.ReadFull(c.conn, serverHello); err != nil {
+		return nil, err
+	}
+	serverConfirm := serverHello[helloSize:]
+	serverHello = serverHello[:helloSize]
+	peerMode, peerRekeyAfter, peerEphemeral, err := parseHello(serverHello)
+	if err != nil {
+		return nil, err
+	}
+	if peerMode != mode {
+		return nil, errors.New("handshake failed: peers use different authentication modes")
+	}
+
+	secret, transcript, err := c.keySchedule(ephemeral, peerEphemeral, clientHello, serverHello)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(secret)
+	if !hmac.Equal(serverConfirm, confirm(secret, labelServerConfirm, transcript)) {
+		return nil, ErrHandshake
+	}
+	if _, err := c.conn.Write(confirm(secret, labelClientConfirm, transcript)); err != nil {
+		return nil, err
+	}
+	return deriveTrafficKeys(secret, labelClientKey, labelServerKey, c.config.rekeyAfter(), peerRekeyAfter)
+}
+
+func (c *Conn) serverHandshake() (*trafficKeys, error) {
+	mode := c.config.mode()
+	clientHello := make([]byte, helloSize)
+	if _, err := io.ReadFull(c.conn, clientHello); err != nil {
+		return nil, err
+	}
+	peerMode, peerRekeyAfter, peerEphemeral, err := parseHello(clientHello)
+	if err != nil {
+		return nil, err
+	}
+	if peerMode != mode {
+		return nil, errors.New("handshake failed: peers use different authentication modes")
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serverHello := hello(mode, c.config.rekeyAfter(), ephemeral.PublicKey())
+
+	secret, transcript, err := c.keySchedule(ephemeral, peerEphemeral, clientHello, serverHello)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(secret)
+	if _, err := c.conn.Write(append(serverHello, confirm(secret, labelServerConfirm, transcript)...)); err != nil {
+		return nil, err
+	}
+
+	clientConfirm := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(c.conn, clientConfirm); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(clientConfirm, confirm(secret, labelClientConfirm, transcript)) {
+		return nil, ErrHandshake
+	}
+	return deriveTrafficKeys(secret, labelServerKey, labelClientKey, c.config.rekeyAfter(), peerRekeyAfter)
+}
+
+// keySchedule returns the handshake secret and transcript hash. The Diffie-Hellman terms and
+// static keys are ordered client first, so both ends compute the same values.
+func (c *Conn) keySchedule(ephemeral *ecdh.PrivateKey, peerEphemeral *ecdh.PublicKey, clientHello, serverHello []byte) ([]byte, []byte, error) {
+	ee, err := ephemeral.ECDH(peerEphemeral)
+	if err != nil {
+		return nil, nil, err
+	}
+	ikm := ee
+	var clientStatic, serverStatic []byte
+	if c.config.mode()&modeStatic != 0 {
+		static, peerStatic := c.config.PrivateKey, c.config.PeerPublicKey
+		// From the client's side: es = DH(ephemeral, server static), se = DH(static, server ephemeral).
+		es, errES := ephemeral.ECDH(peerStatic)
+		se, errSE := static.ECDH(peerEphemeral)
+		ss, errSS := static.ECDH(peerStatic)
+		if err := errors.Join(errES, errSE, errSS); err != nil {
+			return nil, nil, err
+		}
+		clientStatic, serverStatic = static.PublicKey().Bytes(), peerStatic.Bytes()
+		if !c.isClient {
+			es, se = se, es
+			clientStatic, serverStatic = serverStatic, clientStatic
+		}
+		ikm = slices.Concat(ikm, es, se, ss)
+		clear(ee)
+		clear(es)
+		clear(se)
+		clear(ss)
+	}
+	defer clear(ikm)
+
+	transcript := transcriptHash(clientHello, serverHello, clientStatic, serverStatic)
+	var secret []byte
+	err = crypt.UseKey(c.config.SecretKey, c.config.PSK, func(psk []byte) error {
+		// A copy of the PSK goes into the input keying material and is wiped with it.
+		material := slices.Concat(ikm, psk)
+		defer clear(material)
+		secret, err = hkdf.Extract(sha256.New, material, transcript)
+		return err
+	})
+	return secret, transcript, err
+}
+
+func hello(mode byte, rekeyAfter uint64, ephemeral *ecdh.PublicKey) []byte {
+	msg := append([]byte(handshakeMagic), mode)
+	msg = binary.BigEndian.AppendUint64(msg, rekeyAfter)
+	return append(msg, ephemeral.Bytes()...)
+}
+
+func parseHello(msg []byte) (byte, uint64, *ecdh.PublicKey, error) {
+	if !bytes.HasPrefix(msg, []byte(handshakeMagic)) {
+		return 0, 0, nil, errors.New("handshake failed: not a secureconn peer")
+	}
+	msg = msg[len(handshakeMagic):]
+	rekeyAfter := binary.BigEndian.Uint64(msg[1:])
+	if rekeyAfter == 0 {
+		return 0, 0, nil, errors.New("handshake failed: invalid rekey interval")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(msg[9:])
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("handshake failed: %w", err)
+	}
+	return msg[0], rekeyAfter, ephemeral, nil
+}
+
+func transcriptHash(clientHello, serverHello, clientStatic, serverStatic []byte) []byte {
+	h := sha256.New()
+	h.Write(clientHello)
+	h.Write(serverHello)
+	h.Write(clientStatic)
+	h.Write(serverStatic)
+	return h.Sum(nil)
+}
+
+func confirm(secret []byte, label string, transcript []byte) []byte {
+	key, err := hkdf.Expand(sha256.New, secret, label, sha256.Size)
+	if err != nil {
+		panic(err) // unreachable: the length is valid for SHA-256
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(transcript)
+	return mac.Sum(nil)
+}
+
+func deriveTrafficKeys(secret []byte, writeLabel, readLabel string, writeRekeyAfter, readRekeyAfter uint64) (*trafficKeys, error) {
+	writeKey, err := hkdf.Expand(sha256.New, secret, writeLabel, keySize)
+	if err != nil {
+		return nil, err
+	}
+	readKey, err := hkdf.Expand(sha256.New, secret, readLabel, keySize)
+	if err != nil {
+		return nil, err
+	}
+	return &trafficKeys{
+		writeKey:        writeKey,
+		readKey:         readKey,
+		writeRekeyAfter: writeRekeyAfter,
+		readRekeyAfter:  readRekeyAfter,
+	}, nil
+}
@@ -0,0 +1,388 @@
+// Package secureconn turns a net.Conn into an authenticated, encrypted connection built on
+// the chunked AES-256-GCM framing used by the stream crypters.
+//
+// The handshake runs ephemeral X25519 and authenticates both peers with a pre-shared key,
+// static X25519 keys, or both; it never runs unauthenticated. Each direction then has its own
+// key, records are sealed with the chunk nonces of the stream format, and every direction
+// moves to a new key (HKDF of the old one) after a number of records. Close sends a final
+// record, so the peer can tell a clean close from a truncated connection.
+//
+// Records are length(4) || AES-GCM(key, nonce(sequence, final), plaintext), where the top bit
+// of the length marks the final (close) record.
+package secureconn
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- Config ---
+
+const (
+	DefaultRekeyAfter = 1 << 20 // records, i.e. at most 64 GiB per key
+	MinPSKSize        = 16
+
+	recordHeaderSize = 4
+	finalRecordFlag  = 1 << 31
+	closeTimeout     = 5 * time.Second
+)
+
+var (
+	ErrTruncated  = errors.New("connection closed without close notification")
+	errDecryption = errors.New("decryption failed: tampering or corruption detected")
+)
+
+// Config authenticates the peers; both ends must set the same kind of credentials.
+type Config struct {
+	// PSK is a secret shared by both ends, at least MinPSKSize bytes.
+	PSK []byte
+
+	// SecretKey, when set, holds the PSK instead of PSK. Each handshake reads it once and
+	// wipes its own copies; the caller destroys it when no more connections need it.
+	SecretKey *crypt.SecretKey
+
+	// PrivateKey is this end's static X25519 key, and PeerPublicKey the key the peer must prove
+	// it holds. Both are needed to authenticate with static keys.
+	PrivateKey    *ecdh.PrivateKey
+	PeerPublicKey *ecdh.PublicKey
+
+	// RekeyAfter is the number of records this end sends under one key; 0 means DefaultRekeyAfter.
+	RekeyAfter uint64
+}
+
+func (cfg *Config) mode() byte {
+	var mode byte
+	if cfg.SecretKey != nil || len(cfg.PSK) > 0 {
+		mode |= modePSK
+	}
+	if cfg.PrivateKey != nil || cfg.PeerPublicKey != nil {
+		mode |= modeStatic
+	}
+	return mode
+}
+
+func (cfg *Config) pskSize() int {
+	if cfg.SecretKey != nil {
+		return cfg.SecretKey.Len()
+	}
+	return len(cfg.PSK)
+}
+
+func (cfg *Config) rekeyAfter() uint64 {
+	if cfg.RekeyAfter == 0 {
+		return DefaultRekeyAfter
+	}
+	return cfg.RekeyAfter
+}
+
+func (cfg *Config) validate() error {
+	mode := cfg.mode()
+	if mode == 0 {
+		return errors.New("no PSK or static keys configured: refusing an unauthenticated handshake")
+	}
+	if cfg.SecretKey != nil && cfg.SecretKey.Destroyed() {
+		return crypt.ErrKeyDestroyed
+	}
+	if mode&modePSK != 0 && cfg.pskSize() < MinPSKSize {
+		return fmt.Errorf("PSK too short: %d bytes, want at least %d", cfg.pskSize(), MinPSKSize)
+	}
+	if mode&modeStatic != 0 {
+		if cfg.PrivateKey == nil || cfg.PeerPublicKey == nil {
+			return errors.New("static key authentication needs both PrivateKey and PeerPublicKey")
+		}
+		if cfg.PrivateKey.Curve() != ecdh.X25519() || cfg.PeerPublicKey.Curve() != ecdh.X25519() {
+			return errors.New("static keys must be X25519 keys")
+		}
+	}
+	return nil
+}
+
+// --- Conn ---
+
+// Conn is a secured net.Conn. The handshake runs on the first Read or Write, or on Handshake.
+type Conn struct {
+	conn     net.Conn
+	config   *Config
+	isClient bool
+
+	handshakeMu   sync.Mutex
+	handshakeDone bool
+	handshakeErr  error
+	established   atomic.Bool // read without handshakeMu, so Close never waits for a handshake
+
+	in  halfConn
+	out halfConn
+
+	// Progress of a partly read record, kept across Read timeouts.
+	header  [recordHeaderSize]byte
+	headerN int
+	record  []byte
+	recordN int
+	pending []byte
+}
+
+var (
+	_ net.Conn         = &Conn{}
+	_ policy.Describer = &Conn{}
+)
+
+// Client returns the client end of a secured connection over conn.
+func Client(conn net.Conn, config *Config) *Conn {
+	return &Conn{conn: conn, config: config, isClient: true}
+}
+
+// Server returns the server end of a secured connection over conn.
+func Server(conn net.Conn, config *Config) *Conn {
+	return &Conn{conn: conn, config: config}
+}
+
+// halfConn is the state of one direction.
+type halfConn struct {
+	mu         sync.Mutex
+	aead       cipher.AEAD
+	key        []byte
+	seq        uint64
+	rekeyAfter uint64
+	generation uint64 // number of rekeys so far
+	err        error  // sticky: the direction is unusable once set
+}
+
+func (h *halfConn) init(key []byte, rekeyAfter uint64) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	h.aead, h.key, h.seq, h.rekeyAfter = aead, key, 0, rekeyAfter
+	return nil
+}
+
+// advance counts a record and moves to the next key when the interval is used up.
+func (h *halfConn) advance() error {
+	h.seq++
+	if h.seq < h.rekeyAfter {
+		return nil
+	}
+	next, err := hkdf.Expand(sha256.New, h.key, labelRekey, keySize)
+	if err != nil {
+		return err
+	}
+	clear(h.key)
+	h.generation++
+	return h.init(next, h.rekeyAfter)
+}
+
+func (c *Conn) Algorithms() []policy.Algorithm {
+	return []policy.Algorithm{
+		{Kind: policy.KeyAgreement, Name: "x25519"},
+		{Kind: policy.KDF, Name: "hkdf-sha256"},
+		{Kind: policy.MAC, Name: "hmac-sha256", KeyBits: sha256.Size * 8},
+		{Kind: policy.Cipher, Name: "aes-256-gcm", KeyBits: keySize * 8},
+	}
+}
+
+// Handshake runs the handshake if it has not run yet, and returns its result.
+func (c *Conn) Handshake() error {
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+	if c.handshakeDone {
+		return c.handshakeErr
+	}
+	c.handshakeDone = true
+
+	if c.handshakeErr = errors.Join(c.config.validate(), policy.Check(c)); c.handshakeErr != nil {
+		return c.handshakeErr
+	}
+	var keys *trafficKeys
+	if c.isClient {
+		keys, c.handshakeErr = c.clientHandshake()
+	} else {
+		keys, c.handshakeErr = c.serverHandshake()
+	}
+	if c.handshakeErr != nil {
+		return c.handshakeErr
+	}
+	c.handshakeErr = errors.Join(
+		c.out.init(keys.writeKey, keys.writeRekeyAfter),
+		c.in.init(keys.readKey, keys.readRekeyAfter),
+	)
+	c.established.Store(c.handshakeErr == nil)
+	return c.handshakeErr
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	if c.out.err != nil {
+		return 0, c.out.err
+	}
+
+	total := 0
+	for len(p) > 0 {
+		n := min(len(p), chunked.ChunkSize)
+		if err := c.writeRecord(p[:n], false); err != nil {
+			return total, err
+		}
+		p = p[n:]
+		total += n
+	}
+	return total, nil
+}
+
+// CloseWrite sends the close record: the peer reads io.EOF, and this end can still read.
+func (c *Conn) CloseWrite() error {
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	if c.out.err != nil {
+		return nil
+	}
+	if err := c.writeRecord(nil, true); err != nil {
+		return err
+	}
+	c.out.err = net.ErrClosed
+	return nil
+}
+
+// Close sends the close record, unless the handshake never completed, and closes the connection.
+func (c *Conn) Close() error {
+	var closeErr error
+	if c.established.Load() {
+		// Like crypto/tls, do not wait forever for a peer that stopped reading.
+		_ = c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+		closeErr = c.CloseWrite()
+	}
+	return errors.Join(closeErr, c.conn.Close())
+}
+
+// writeRecord seals one record; any failure leaves the stream broken, so it is sticky.
+func (c *Conn) writeRecord(plaintext []byte, final bool) error {
+	nonce := chunked.ChunkNonce(c.out.seq, final)
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(plaintext)+c.out.aead.Overhead())
+	record = c.out.aead.Seal(record, nonce, plaintext, nil)
+	length := uint32(len(record) - recordHeaderSize)
+	if final {
+		length |= finalRecordFlag
+	}
+	binary.BigEndian.PutUint32(record, length)
+
+	if _, err := c.conn.Write(record); err != nil {
+		c.out.err = err
+		return err
+	}
+	if err := c.out.advance(); err != nil {
+		c.out.err = err
+		return err
+	}
+	return nil
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.in.mu.Lock()
+	defer c.in.mu.Unlock()
+
+	for len(c.pending) == 0 {
+		if c.in.err != nil {
+			return 0, c.in.err
+		}
+		if err := c.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readRecord reads and opens the next record. A timeout keeps the progress made so far;
+// every other failure is sticky.
+func (c *Conn) readRecord() error {
+	if err := c.readFull(c.header[:], &c.headerN); err != nil {
+		return c.readFailed(err)
+	}
+	length := binary.BigEndian.Uint32(c.header[:])
+	final := length&finalRecordFlag != 0
+	length &^= finalRecordFlag
+	overhead := uint32(c.in.aead.Overhead())
+	if length < overhead || length > chunked.ChunkSize+overhead || (final && length != overhead) {
+		return c.readFailed(errDecryption)
+	}
+
+	if c.record == nil {
+		c.record = make([]byte, length)
+	}
+	if err := c.readFull(c.record, &c.recordN); err != nil {
+		return c.readFailed(err)
+	}
+	plaintext, err := c.in.aead.Open(c.record[:0], chunked.ChunkNonce(c.in.seq, final), c.record, nil)
+	c.headerN, c.record, c.recordN = 0, nil, 0
+	if err != nil {
+		return c.readFailed(errDecryption)
+	}
+	if err := c.in.advance(); err != nil {
+		return c.readFailed(err)
+	}
+	if final {
+		c.in.err = io.EOF
+		return nil
+	}
+	c.pending = plaintext
+	return nil
+}
+
+// readFull fills buf from the connection, resuming at *progress. Only the close
+// record may end the connection, so io.EOF is always ErrTruncated.
+func (c *Conn) readFull(buf []byte, progress *int) error {
+	for *progress < len(buf) {
+		n, err := c.conn.Read(buf[*progress:])
+		*progress += n
+		if errors.Is(err, io.EOF) {
+			return ErrTruncated
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) readFailed(err error) error {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return err
+	}
+	c.in.err = err
+	return err
+}
+
+func (c *Conn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
@@ -0,0 +1,264 @@
+package secureconn
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/internal/chunked"
+)
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+	return b
+}
+
+func generateKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+// handshakePair runs both handshakes over net.Pipe and returns their errors.
+func handshakePair(t *testing.T, clientConfig, serverConfig *Config) (*Conn, *Conn, error, error) {
+	t.Helper()
+	clientRaw, serverRaw := net.Pipe()
+	t.Cleanup(func() {
+		clientRaw.Close()
+		serverRaw.Close()
+	})
+	client, server := Client(clientRaw, clientConfig), Server(serverRaw, serverConfig)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		err := server.Handshake()
+		if err != nil {
+			serverRaw.Close()
+		}
+		serverErr <- err
+	}()
+	clientErr := client.Handshake()
+	if clientErr != nil {
+		clientRaw.Close()
+	}
+	return client, server, clientErr, <-serverErr
+}
+
+func connectedPair(t *testing.T, clientConfig, serverConfig *Config) (*Conn, *Conn) {
+	t.Helper()
+	client, server, clientErr, serverErr := handshakePair(t, clientConfig, serverConfig)
+	require.NoError(t, clientErr)
+	require.NoError(t, serverErr)
+	return client, server
+}
+
+// exchange sends data both ways at once and half-closes each direction.
+func exchange(t *testing.T, a, b *Conn, fromA, fromB []byte) {
+	t.Helper()
+	done := make(chan error, 2)
+	send := func(c *Conn, data []byte) {
+		if _, err := c.Write(data); err != nil {
+			done <- err
+			return
+		}
+		done <- c.CloseWrite()
+	}
+	go send(a, fromA)
+	go send(b, fromB)
+
+	gotByB, err := io.ReadAll(b)
+	require.NoError(t, err)
+	gotByA, err := io.ReadAll(a)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+	assert.True(t, bytes.Equal(fromA, gotByB))
+	assert.True(t, bytes.Equal(fromB, gotByA))
+}
+
+func TestSecureConn_Authentication(t *testing.T) {
+	psk := randomBytes(t, 32)
+	clientKey, serverKey := generateKey(t), generateKey(t)
+
+	cases := map[string][2]*Config{
+		"psk": {{PSK: psk}, {PSK: psk}},
+		"static keys": {
+			{PrivateKey: clientKey, PeerPublicKey: serverKey.PublicKey()},
+			{PrivateKey: serverKey, PeerPublicKey: clientKey.PublicKey()},
+		},
+		"psk and static keys": {
+			{PSK: psk, PrivateKey: clientKey, PeerPublicKey: serverKey.PublicKey()},
+			{PSK: psk, PrivateKey: serverKey, PeerPublicKey: clientKey.PublicKey()},
+		},
+	}
+	for name, configs := range cases {
+		t.Run(name, func(t *testing.T) {
+			client, server := connectedPair(t, configs[0], configs[1])
+			exchange(t, client, server, randomBytes(t, 3*chunked.ChunkSize+5), randomBytes(t, 1000))
+		})
+	}
+}
+
+func TestSecureConn_RejectsWrongCredentials(t *testing.T) {
+	psk := randomBytes(t, 32)
+	clientKey, serverKey, mallory := generateKey(t), generateKey(t), generateKey(t)
+
+	_, _, clientErr, serverErr := handshakePair(t, &Config{PSK: psk}, &Config{PSK: randomBytes(t, 32)})
+	require.ErrorIs(t, clientErr, ErrHandshake)
+	require.Error(t, serverErr)
+
+	// The server expects clientKey, but the peer holds mallory's key.
+	_, _, clientErr, serverErr = handshakePair(t,
+		&Config{PrivateKey: mallory, PeerPublicKey: serverKey.PublicKey()},
+		&Config{PrivateKey: serverKey, PeerPublicKey: clientKey.PublicKey()},
+	)
+	require.ErrorIs(t, clientErr, ErrHandshake)
+	require.Error(t, serverErr)
+
+	_, _, _, serverErr = handshakePair(t,
+		&Config{PSK: psk},
+		&Config{PrivateKey: serverKey, PeerPublicKey: clientKey.PublicKey()},
+	)
+	require.ErrorContains(t, serverErr, "different authentication modes")
+}
+
+func TestSecureConn_InvalidConfig(t *testing.T) {
+	for config, want := range map[*Config]string{
+		{}:                           "refusing an unauthenticated handshake",
+		{PSK: []byte("short")}:       "PSK too short",
+		{PrivateKey: generateKey(t)}: "needs both PrivateKey and PeerPublicKey",
+		{PeerPublicKey: generateKey(t).PublicKey()}: "needs both PrivateKey and PeerPublicKey",
+	} {
+		clientRaw, serverRaw := net.Pipe()
+		err := Client(clientRaw, config).Handshake()
+		require.ErrorContains(t, err, want)
+		_, err = Client(clientRaw, config).Write([]byte("x"))
+		require.ErrorContains(t, err, want)
+		clientRaw.Close()
+		serverRaw.Close()
+	}
+}
+
+func TestSecureConn_SecretKey(t *testing.T) {
+	psk := randomBytes(t, 32)
+	key := crypt.NewSecretKey(psk)
+	clientKey, serverKey := generateKey(t), generateKey(t)
+
+	// A SecretKey PSK interoperates with a plain one, alone and with static keys.
+	client, server := connectedPair(t, &Config{SecretKey: key}, &Config{PSK: psk})
+	exchange(t, client, server, randomBytes(t, 1000), randomBytes(t, 1000))
+	client, server = connectedPair(t,
+		&Config{SecretKey: key, PrivateKey: clientKey, PeerPublicKey: serverKey.PublicKey()},
+		&Config{PSK: psk, PrivateKey: serverKey, PeerPublicKey: clientKey.PublicKey()},
+	)
+
+	// Established connections keep working once the PSK is destroyed; new handshakes fail.
+	key.Destroy()
+	exchange(t, client, server, randomBytes(t, 1000), randomBytes(t, 1000))
+	clientRaw, serverRaw := net.Pipe()
+	defer clientRaw.Close()
+	defer serverRaw.Close()
+	require.ErrorIs(t, Client(clientRaw, &Config{SecretKey: key}).Handshake(), crypt.ErrKeyDestroyed)
+
+	_, err := Client(clientRaw, &Config{SecretKey: crypt.NewSecretKey([]byte("short"))}).Write([]byte("x"))
+	require.ErrorContains(t, err, "PSK too short")
+}
+
+func TestSecureConn_Rekey(t *testing.T) {
+	psk := randomBytes(t, 32)
+	client, server := connectedPair(t, &Config{PSK: psk, RekeyAfter: 2}, &Config{PSK: psk, RekeyAfter: 3})
+
+	// 10 full records and the close record from the client, 9 and the close record from the server.
+	exchange(t, client, server, randomBytes(t, 10*chunked.ChunkSize), randomBytes(t, 9*chunked.ChunkSize))
+	assert.Equal(t, uint64(5), client.out.generation)
+	assert.Equal(t, uint64(5), server.in.generation)
+	assert.Equal(t, uint64(3), server.out.generation)
+	assert.Equal(t, uint64(3), client.in.generation)
+}
+
+func TestSecureConn_DetectsTamperingAndTruncation(t *testing.T) {
+	psk := randomBytes(t, 32)
+	client, server := connectedPair(t, &Config{PSK: psk}, &Config{PSK: psk})
+
+	// Re-sealing is impossible without the key, so flip a ciphertext byte on the wire.
+	wire := client.conn
+	clientRaw, proxy := net.Pipe()
+	client.conn = clientRaw
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := proxy.Read(buf)
+		buf[n-1] ^= 1
+		_, _ = wire.Write(buf[:n])
+	}()
+	go func() { _, _ = client.Write([]byte("tampered")) }()
+	_, err := server.Read(make([]byte, 16))
+	require.ErrorContains(t, err, "decryption failed")
+	_, err = server.Read(make([]byte, 16))
+	require.ErrorContains(t, err, "decryption failed", "errors are sticky")
+
+	client, server = connectedPair(t, &Config{PSK: psk}, &Config{PSK: psk})
+	require.NoError(t, client.conn.Close(), "close without the close record")
+	_, err = server.Read(make([]byte, 16))
+	require.ErrorIs(t, err, ErrTruncated)
+}
+
+func TestSecureConn_ReadTimeoutKeepsProgress(t *testing.T) {
+	psk := randomBytes(t, 32)
+	client, server := connectedPair(t, &Config{PSK: psk}, &Config{PSK: psk})
+
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(20*time.Millisecond)))
+	_, err := server.Read(make([]byte, 16))
+	var ne net.Error
+	require.True(t, errors.As(err, &ne) && ne.Timeout())
+
+	require.NoError(t, server.SetReadDeadline(time.Time{}))
+	go func() { _, _ = client.Write([]byte("after timeout")) }()
+	got := make([]byte, len("after timeout"))
+	_, err = io.ReadFull(server, got)
+	require.NoError(t, err)
+	assert.Equal(t, "after timeout", string(got))
+}
+
+func TestSecureConn_Loopback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("loopback unavailable: %v", err)
+	}
+	defer ln.Close()
+	psk := randomBytes(t, 32)
+	payload := randomBytes(t, 5*chunked.ChunkSize)
+
+	received := make(chan []byte, 1)
+	go func() {
+		raw, err := ln.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		conn := Server(raw, &Config{PSK: psk})
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+
+	raw, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	conn := Client(raw, &Config{PSK: psk})
+	_, err = conn.Write(payload)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	assert.True(t, bytes.Equal(payload, <-received))
+}
@@ -0,0 +1,370 @@
+// Package tinkstream implements the AES-GCM-HKDF streaming AEAD wire format of Google Tink,
+// so that streams can be exchanged with Tink users without depending on Tink itself.
+//
+// A ciphertext is a header followed by segments:
+//
+//	header  = headerLength(1) || salt(DerivedKeySize) || noncePrefix(7)
+//	segment = AES-GCM(segmentKey, noncePrefix || segmentNr(4, BE) || lastSegment(1), plaintext)
+//
+// The segment key is HKDF(mainKey, salt, associatedData). Every ciphertext segment
+// is SegmentSize bytes, except the first (which is shortened by the header) and the last.
+package tinkstream
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	_ "crypto/sha1"   // registers crypto.SHA1 for HKDF
+	_ "crypto/sha256" // registers crypto.SHA256 for HKDF
+	_ "crypto/sha512" // registers crypto.SHA512 for HKDF
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- Constants ---
+
+const (
+	nonceSize       = 12
+	noncePrefixSize = 7
+	tagSize         = 16
+)
+
+// --- Parameters ---
+
+// Parameters mirror AesGcmHkdfStreamingParams of a Tink key.
+type Parameters struct {
+	HKDFHash       crypto.Hash // SHA1, SHA256 or SHA512
+	DerivedKeySize int         // 16 or 32
+	SegmentSize    int         // ciphertext segment size in bytes
+}
+
+// Parameters of the predefined Tink key templates.
+var (
+	AES128GCMHKDF4KB = Parameters{HKDFHash: crypto.SHA256, DerivedKeySize: 16, SegmentSize: 4096}
+	AES128GCMHKDF1MB = Parameters{HKDFHash: crypto.SHA256, DerivedKeySize: 16, SegmentSize: 1 << 20}
+	AES256GCMHKDF4KB = Parameters{HKDFHash: crypto.SHA256, DerivedKeySize: 32, SegmentSize: 4096}
+	AES256GCMHKDF1MB = Parameters{HKDFHash: crypto.SHA256, DerivedKeySize: 32, SegmentSize: 1 << 20}
+)
+
+func (p Parameters) headerLength() int {
+	return 1 + p.DerivedKeySize + noncePrefixSize
+}
+
+func (p Parameters) validate(mainKey []byte) error {
+	switch p.HKDFHash {
+	case crypto.SHA1, crypto.SHA256, crypto.SHA512:
+	default:
+		return fmt.Errorf("unsupported HKDF hash: %v", p.HKDFHash)
+	}
+	if p.DerivedKeySize != 16 && p.DerivedKeySize != 32 {
+		return fmt.Errorf("invalid derived key size: %d", p.DerivedKeySize)
+	}
+	if len(mainKey) < 16 || len(mainKey) < p.DerivedKeySize {
+		return errors.New("main key too short")
+	}
+	if p.SegmentSize <= p.headerLength()+tagSize {
+		return fmt.Errorf("segment size too small: %d", p.SegmentSize)
+	}
+	return nil
+}
+
+// --- AES-GCM-HKDF Streaming Crypter ---
+
+// AESGCMHKDFCrypter encrypts and decrypts Tink AES-GCM-HKDF streaming AEAD ciphertexts.
+//
+// Key is the raw key_value of an AesGcmHkdfStreamingKey from a Tink keyset. Tink streaming
+// ciphertexts carry no key ID, so the caller picks the key. AssociatedData must match the
+// associated data passed to Tink's NewEncryptingWriter/NewDecryptingReader.
+type AESGCMHKDFCrypter struct {
+	Key            []byte
+	Params         Parameters
+	AssociatedData []byte
+
+	// SecretKey, when set, is used instead of Key.
+	SecretKey *crypt.SecretKey
+}
+
+var (
+	_ crypt.ContextCrypter = &AESGCMHKDFCrypter{}
+	_ crypt.Destroyer      = &AESGCMHKDFCrypter{}
+	_ policy.Describer     = &AESGCMHKDFCrypter{}
+)
+
+// NewAESGCMHKDFCrypter copies key into a crypt.SecretKey, so the caller can wipe key afterwards.
+func NewAESGCMHKDFCrypter(key []byte, params Parameters) crypt.Crypter {
+	return &AESGCMHKDFCrypter{
+		SecretKey: crypt.NewSecretKey(key),
+		Params:    params,
+	}
+}
+
+func (c *AESGCMHKDFCrypter) Destroy() {
+	if c.SecretKey == nil {
+		c.SecretKey = &crypt.SecretKey{}
+	}
+	c.SecretKey.Destroy()
+	clear(c.Key)
+	c.Key = nil
+}
+
+func (c *AESGCMHKDFCrypter) FileExtension() string {
+	return ".tink"
+}
+
+func (c *AESGCMHKDFCrypter) Name() string {
+	return "tink-aes-gcm-hkdf"
+}
+
+// EncryptWithContext uses associatedData in place of AssociatedData. Tink cannot tell a wrong
+// context from corruption, so decrypting under other associated data fails like tampering does.
+func (c *AESGCMHKDFCrypter) EncryptWithContext(w io.Writer, associatedData []byte) (io.WriteCloser, error) {
+	bound := *c
+	bound.AssociatedData = associatedData
+	return bound.Encrypt(w)
+}
+
+func (c *AESGCMHKDFCrypter) DecryptWithContext(r io.Reader, associatedData []byte) (io.Reader, error) {
+	bound := *c
+	bound.AssociatedData = associatedData
+	return bound.Decrypt(r)
+}
+
+func (c *AESGCMHKDFCrypter) validate() error {
+	return crypt.UseKey(c.SecretKey, c.Key, c.Params.validate)
+}
+
+func (c *AESGCMHKDFCrypter) newAEAD(salt []byte) (cipher.AEAD, error) {
+	var block cipher.Block
+	err := crypt.UseKey(c.SecretKey, c.Key, func(mainKey []byte) error {
+		key, err := hkdf.Key(c.Params.HKDFHash.New, mainKey, salt, string(c.AssociatedData), c.Params.DerivedKeySize)
+		if err != nil {
+			return err
+		}
+		defer clear(key)
+		block, err = aes.NewCipher(key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *AESGCMHKDFCrypter) Algorithms() []policy.Algorithm {
+	hkdfName := "hkdf-unknown"
+	switch c.Params.HKDFHash {
+	case crypto.SHA1:
+		hkdfName = "hkdf-sha1"
+	case crypto.SHA256:
+		hkdfName = "hkdf-sha256"
+	case crypto.SHA512:
+		hkdfName = "hkdf-sha512"
+	}
+	keyBits := c.Params.DerivedKeySize * 8
+	return []policy.Algorithm{
+		{Kind: policy.Cipher, Name: fmt.Sprintf("aes-%d-gcm", keyBits), KeyBits: keyBits},
+		{Kind: policy.KDF, Name: hkdfName},
+	}
+}
+
+func (c *AESGCMHKDFCrypter) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, c.Params.headerLength())
+	header[0] = byte(len(header))
+	if _, err := rand.Read(header[1:]); err != nil {
+		return nil, err
+	}
+	salt := header[1 : 1+c.Params.DerivedKeySize]
+	noncePrefix := header[1+c.Params.DerivedKeySize:]
+
+	aead, err := c.newAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	plaintextSize := c.Params.SegmentSize - tagSize
+	return &segmentWriter{
+		aead:        aead,
+		w:           w,
+		noncePrefix: noncePrefix,
+		buf:         make([]byte, 0, plaintextSize),
+		limit:       plaintextSize - len(header),
+		segmentSize: plaintextSize,
+	}, nil
+}
+
+type segmentWriter struct {
+	aead        cipher.AEAD
+	w           io.Writer
+	noncePrefix []byte
+	buf         []byte
+	limit       int // plaintext size of the current segment (the first one is shorter)
+	segmentSize int
+	segmentNum  uint64
+	closed      bool
+}
+
+func (s *segmentWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("write on closed writer")
+	}
+	total := 0
+	for len(p) > 0 {
+		// A full segment is sealed only once more data arrives, because
+		// the final segment must be sealed with the last-segment flag.
+		if len(s.buf) == s.limit {
+			if err := s.flush(false); err != nil {
+				return total, err
+			}
+		}
+		n := min(s.limit-len(s.buf), len(p))
+		s.buf = append(s.buf, p[:n]...)
+		p = p[n:]
+		total += n
+	}
+	return total, nil
+}
+
+func (s *segmentWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+func (s *segmentWriter) flush(last bool) error {
+	nonce, err := segmentNonce(s.noncePrefix, s.segmentNum, last)
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(s.aead.Seal(nil, nonce, s.buf, nil)); err != nil {
+		return err
+	}
+	s.segmentNum++
+	s.buf = s.buf[:0]
+	s.limit = s.segmentSize
+	return nil
+}
+
+func (c *AESGCMHKDFCrypter) Decrypt(r io.Reader) (io.Reader, error) {
+	if err := policy.Check(c); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, c.Params.headerLength())
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if int(header[0]) != len(header) {
+		return nil, errors.New("invalid header length")
+	}
+	salt := header[1 : 1+c.Params.DerivedKeySize]
+	noncePrefix := header[1+c.Params.DerivedKeySize:]
+
+	aead, err := c.newAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	// One extra byte is read past every segment to learn whether it is the last one.
+	return &segmentReader{
+		aead:        aead,
+		r:           r,
+		noncePrefix: noncePrefix,
+		ciphertext:  make([]byte, c.Params.SegmentSize+1),
+		limit:       c.Params.SegmentSize - len(header) + 1,
+	}, nil
+}
+
+type segmentReader struct {
+	aead        cipher.AEAD
+	r           io.Reader
+	noncePrefix []byte
+	ciphertext  []byte
+	pending     int // bytes of the next segment already read as look-ahead
+	limit       int
+	segmentNum  uint64
+	buf         []byte
+	done        bool
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *segmentReader) next() error {
+	n, err := io.ReadFull(s.r, s.ciphertext[s.pending:s.limit])
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	end := s.pending + n
+	last := err != nil
+	if !last {
+		end-- // the look-ahead byte belongs to the next segment
+	}
+
+	nonce, err := segmentNonce(s.noncePrefix, s.segmentNum, last)
+	if err != nil {
+		return err
+	}
+	plaintext, err := s.aead.Open(nil, nonce, s.ciphertext[:end], nil)
+	if err != nil {
+		return errors.New("decryption failed: tampering or corruption detected")
+	}
+
+	if last {
+		s.done = true
+	} else {
+		s.ciphertext[0] = s.ciphertext[end]
+		s.pending = 1
+	}
+	s.segmentNum++
+	s.limit = len(s.ciphertext)
+	s.buf = plaintext
+	return nil
+}
+
+func segmentNonce(prefix []byte, segmentNum uint64, last bool) ([]byte, error) {
+	if segmentNum >= math.MaxUint32 {
+		return nil, errors.New("too many segments")
+	}
+	nonce := make([]byte, nonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], uint32(segmentNum))
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce, nil
+}
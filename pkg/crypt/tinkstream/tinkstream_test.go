@@ -0,0 +1,247 @@
+package tinkstream
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+type tinkVector struct {
+	Name        string `json:"name"`
+	HKDFHash    string `json:"hkdf_hash"`
+	KeySize     int    `json:"derived_key_size"`
+	SegmentSize int    `json:"ciphertext_segment_size"`
+	Key         string `json:"key"`
+	AAD         string `json:"aad"`
+	Plaintext   string `json:"plaintext"`
+	Ciphertext  string `json:"ciphertext"`
+}
+
+func loadVectors(t *testing.T) []tinkVector {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "aes_gcm_hkdf_vectors.json"))
+	require.NoError(t, err)
+
+	var file struct {
+		Vectors []tinkVector `json:"vectors"`
+	}
+	require.NoError(t, json.Unmarshal(data, &file))
+	require.NotEmpty(t, file.Vectors)
+	return file.Vectors
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func crypterFor(t *testing.T, v tinkVector) *AESGCMHKDFCrypter {
+	t.Helper()
+	hashes := map[string]crypto.Hash{"SHA1": crypto.SHA1, "SHA256": crypto.SHA256, "SHA512": crypto.SHA512}
+	h, ok := hashes[v.HKDFHash]
+	require.True(t, ok, "unknown hash %q", v.HKDFHash)
+	return &AESGCMHKDFCrypter{
+		Key:            mustHex(t, v.Key),
+		Params:         Parameters{HKDFHash: h, DerivedKeySize: v.KeySize, SegmentSize: v.SegmentSize},
+		AssociatedData: mustHex(t, v.AAD),
+	}
+}
+
+func TestAESGCMHKDFCrypter_DecryptTinkVectors(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		t.Run(v.Name, func(t *testing.T) {
+			crypter := crypterFor(t, v)
+
+			r, err := crypter.Decrypt(bytes.NewReader(mustHex(t, v.Ciphertext)))
+			require.NoError(t, err)
+			plaintext, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, v.Plaintext, hex.EncodeToString(plaintext))
+		})
+	}
+}
+
+func TestAESGCMHKDFCrypter_EncryptMatchesTinkLayout(t *testing.T) {
+	// Salt and nonce prefix are random, so only the layout can be compared with Tink's output.
+	for _, v := range loadVectors(t) {
+		t.Run(v.Name, func(t *testing.T) {
+			crypter := crypterFor(t, v)
+			plaintext := mustHex(t, v.Plaintext)
+
+			var buf bytes.Buffer
+			w, err := crypter.Encrypt(&buf)
+			require.NoError(t, err)
+			_, err = w.Write(plaintext)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			assert.Equal(t, len(v.Ciphertext)/2, buf.Len())
+			assert.Equal(t, byte(crypter.Params.headerLength()), buf.Bytes()[0])
+
+			r, err := crypter.Decrypt(&buf)
+			require.NoError(t, err)
+			decrypted, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, plaintext, decrypted)
+		})
+	}
+}
+
+func TestAESGCMHKDFCrypter_SmallWrites(t *testing.T) {
+	crypter := NewAESGCMHKDFCrypter(bytes.Repeat([]byte{7}, 32), Parameters{
+		HKDFHash: crypto.SHA256, DerivedKeySize: 16, SegmentSize: 64,
+	})
+	plaintext := bytes.Repeat([]byte("0123456789"), 100)
+
+	var buf bytes.Buffer
+	w, err := crypter.Encrypt(&buf)
+	require.NoError(t, err)
+	for _, b := range plaintext {
+		_, err := w.Write([]byte{b})
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	r, err := crypter.Decrypt(&buf)
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+}
+
+func TestAESGCMHKDFCrypter_Destroy(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	crypter := NewAESGCMHKDFCrypter(key, Parameters{
+		HKDFHash: crypto.SHA256, DerivedKeySize: 16, SegmentSize: 64,
+	}).(*AESGCMHKDFCrypter)
+	clear(key) // the constructor keeps its own copy
+
+	var buf bytes.Buffer
+	w, err := crypter.Encrypt(&buf)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	r, err := crypter.Decrypt(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.NoError(t, err)
+
+	crypter.Destroy()
+	_, err = crypter.Decrypt(bytes.NewReader(buf.Bytes()))
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+
+	withKey := &AESGCMHKDFCrypter{Key: bytes.Repeat([]byte{7}, 32), Params: crypter.Params}
+	withKey.Destroy()
+	_, err = withKey.Encrypt(io.Discard)
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+}
+
+func TestAESGCMHKDFCrypter_WrongAssociatedData(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		if v.AAD == "" {
+			continue
+		}
+		crypter := crypterFor(t, v)
+		crypter.AssociatedData = []byte("some other object")
+
+		r, err := crypter.Decrypt(bytes.NewReader(mustHex(t, v.Ciphertext)))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		require.ErrorContains(t, err, "decryption failed", v.Name)
+	}
+}
+
+func TestAESGCMHKDFCrypter_WithContext(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		if v.AAD == "" {
+			continue
+		}
+		crypter := crypterFor(t, v)
+		aad := crypter.AssociatedData
+		crypter.AssociatedData = nil
+
+		r, err := crypter.DecryptWithContext(bytes.NewReader(mustHex(t, v.Ciphertext)), aad)
+		require.NoError(t, err)
+		decrypted, err := io.ReadAll(r)
+		require.NoError(t, err, v.Name)
+		assert.Equal(t, mustHex(t, v.Plaintext), decrypted)
+		assert.Nil(t, crypter.AssociatedData, "the crypter itself must not change")
+	}
+}
+
+func TestAESGCMHKDFCrypter_RejectsModifiedCiphertext(t *testing.T) {
+	var v tinkVector
+	for _, vec := range loadVectors(t) {
+		if vec.Name == "AES256_GCM_HKDF_SHA512_512B/multi-segment" {
+			v = vec
+		}
+	}
+	require.NotEmpty(t, v.Ciphertext)
+	crypter := crypterFor(t, v)
+	ciphertext := mustHex(t, v.Ciphertext)
+
+	decrypt := func(ct []byte) error {
+		r, err := crypter.Decrypt(bytes.NewReader(ct))
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r)
+		return err
+	}
+
+	t.Run("truncate", func(t *testing.T) {
+		for i := 0; i < len(ciphertext); i += 13 {
+			assert.Error(t, decrypt(ciphertext[:i]), "truncated at %d", i)
+		}
+		// Dropping whole trailing segments must fail too: the new last segment lacks the last-segment flag.
+		firstSegment := v.SegmentSize - crypter.Params.headerLength()
+		assert.Error(t, decrypt(ciphertext[:crypter.Params.headerLength()+firstSegment]))
+	})
+
+	t.Run("append", func(t *testing.T) {
+		assert.Error(t, decrypt(append(append([]byte(nil), ciphertext...), 0)))
+	})
+
+	t.Run("flip bits", func(t *testing.T) {
+		for i := 0; i < len(ciphertext); i += 7 {
+			ct := append([]byte(nil), ciphertext...)
+			ct[i] ^= 1
+			assert.Error(t, decrypt(ct), "flipped byte %d", i)
+		}
+	})
+}
+
+func TestAESGCMHKDFCrypter_InvalidParameters(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	cases := []*AESGCMHKDFCrypter{
+		{Key: key[:8], Params: AES256GCMHKDF4KB},
+		{Key: key[:16], Params: AES256GCMHKDF4KB},
+		{Key: key, Params: Parameters{HKDFHash: crypto.MD5, DerivedKeySize: 32, SegmentSize: 4096}},
+		{Key: key, Params: Parameters{HKDFHash: crypto.SHA256, DerivedKeySize: 24, SegmentSize: 4096}},
+		{Key: key, Params: Parameters{HKDFHash: crypto.SHA256, DerivedKeySize: 32, SegmentSize: 56}},
+	}
+	for i, c := range cases {
+		_, err := c.Encrypt(io.Discard)
+		assert.Error(t, err, "case %d", i)
+		_, err = c.Decrypt(bytes.NewReader(make([]byte, 128)))
+		assert.Error(t, err, "case %d", i)
+	}
+}
+
+func TestAESGCMHKDFCrypter_InvalidHeaderLength(t *testing.T) {
+	crypter := NewAESGCMHKDFCrypter(bytes.Repeat([]byte{1}, 32), AES128GCMHKDF4KB)
+	data := append([]byte{40}, make([]byte, 100)...) // header length of an AES-256 key
+	_, err := crypter.Decrypt(bytes.NewReader(data))
+	require.ErrorContains(t, err, "invalid header length")
+}
@@ -0,0 +1,431 @@
+// Package fec adds Reed–Solomon parity to a byte stream, so damaged or unreadable
+// parts of it can be repaired before the inner format (usually an encrypted stream)
+// authenticates them.
+//
+// The stream is cut into groups of DataShards shards of ShardSize bytes, and every group is
+// followed by ParityShards parity shards. Every shard is stored as a record with a CRC-32C,
+// which tells the reader which shards are damaged; up to ParityShards damaged shards per group
+// are reconstructed. The last group may hold fewer data shards.
+//
+//	header × 3 || group...
+//	header = "FECv1\x00" || data shards(1) || parity shards(1) || shard size(4) || crc32c(4)
+//	record = crc32c(4) || final(1) || data shards in group(1) || data length in group(4) || payload
+//
+// A record's CRC also covers its group number and index, so misplaced records count as damaged.
+package fec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/reedsolomon"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- Constants ---
+
+const (
+	headerMagic      = "FECv1\x00"
+	headerSize       = len(headerMagic) + 1 + 1 + 4 + 4
+	headerCopies     = 3
+	recordHeaderSize = 4 + 1 + 1 + 4
+	maxShardSize     = 16 << 20
+	maxShards        = 256
+	flagFinal        = 1
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Options sets the overhead ratio: ParityShards extra shards for every DataShards shards.
+type Options struct {
+	DataShards   int
+	ParityShards int
+	ShardSize    int
+}
+
+// DefaultOptions add 20% overhead and repair up to 2 damaged shards in every 160 KiB.
+var DefaultOptions = Options{
+	DataShards:   10,
+	ParityShards: 2,
+	ShardSize:    16 * 1024,
+}
+
+func (o Options) validate() error {
+	if o.DataShards < 1 || o.ParityShards < 1 || o.DataShards+o.ParityShards > maxShards {
+		return fmt.Errorf("invalid shard counts: %d data, %d parity", o.DataShards, o.ParityShards)
+	}
+	if o.ShardSize < 1 || o.ShardSize > maxShardSize {
+		return fmt.Errorf("invalid shard size: %d", o.ShardSize)
+	}
+	return nil
+}
+
+func (o Options) marshal() []byte {
+	b := append([]byte(headerMagic), byte(o.DataShards), byte(o.ParityShards))
+	b = binary.BigEndian.AppendUint32(b, uint32(o.ShardSize))
+	return binary.BigEndian.AppendUint32(b, crc32.Checksum(b, castagnoli))
+}
+
+func recordChecksum(group uint64, index int, record []byte) uint32 {
+	var pos [9]byte
+	binary.BigEndian.PutUint64(pos[:8], group)
+	pos[8] = byte(index)
+	crc := crc32.Update(0, castagnoli, pos[:])
+	return crc32.Update(crc, castagnoli, record[4:])
+}
+
+// --- Writer ---
+
+type writer struct {
+	w       io.Writer
+	opts    Options
+	enc     reedsolomon.Encoder
+	buf     []byte
+	group   uint64
+	records []byte
+	closed  bool
+}
+
+// NewWriter returns a writer that adds parity to everything written to it.
+// Close must be called to write the last group.
+func NewWriter(w io.Writer, opts Options) (io.WriteCloser, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	enc, err := reedsolomon.New(opts.DataShards, opts.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	header := opts.marshal()
+	for range headerCopies {
+		if _, err := w.Write(header); err != nil {
+			return nil, err
+		}
+	}
+	return &writer{
+		w:    w,
+		opts: opts,
+		enc:  enc,
+		buf:  make([]byte, 0, opts.DataShards*opts.ShardSize),
+	}, nil
+}
+
+func (f *writer) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		n := copy(f.buf[len(f.buf):cap(f.buf)], p)
+		f.buf = f.buf[:len(f.buf)+n]
+		p = p[n:]
+		total += n
+
+		if len(f.buf) == cap(f.buf) {
+			if err := f.flush(false); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+func (f *writer) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.flush(true)
+}
+
+func (f *writer) flush(final bool) error {
+	dataShards := max(1, (len(f.buf)+f.opts.ShardSize-1)/f.opts.ShardSize)
+	enc := f.enc
+	if dataShards != f.opts.DataShards {
+		var err error
+		if enc, err = reedsolomon.New(dataShards, f.opts.ParityShards); err != nil {
+			return err
+		}
+	}
+
+	recordSize := recordHeaderSize + f.opts.ShardSize
+	count := dataShards + f.opts.ParityShards
+	f.records = append(f.records[:0], make([]byte, count*recordSize)...)
+	shards := make([][]byte, count)
+	for i := range shards {
+		record := f.records[i*recordSize : (i+1)*recordSize]
+		if final {
+			record[4] = flagFinal
+		}
+		record[5] = byte(dataShards)
+		binary.BigEndian.PutUint32(record[6:10], uint32(len(f.buf)))
+		shards[i] = record[recordHeaderSize:]
+		if i < dataShards {
+			copy(shards[i], f.buf[min(len(f.buf), i*f.opts.ShardSize):])
+		}
+	}
+	if err := enc.Encode(shards); err != nil {
+		return err
+	}
+	for i := range shards {
+		record := f.records[i*recordSize : (i+1)*recordSize]
+		binary.BigEndian.PutUint32(record[:4], recordChecksum(f.group, i, record))
+	}
+
+	if _, err := f.w.Write(f.records); err != nil {
+		return err
+	}
+	f.group++
+	f.buf = f.buf[:0]
+	return nil
+}
+
+// --- Reader ---
+
+// Reader repairs and returns the stream written by NewWriter.
+type Reader struct {
+	r        io.Reader
+	opts     Options
+	encoders map[int]reedsolomon.Encoder
+	group    uint64
+	buf      []byte
+	done     bool
+	repaired int
+}
+
+// NewReader reads the stream header; at least one of its copies must be intact.
+func NewReader(r io.Reader) (*Reader, error) {
+	headers := make([]byte, headerCopies*headerSize)
+	if _, err := io.ReadFull(r, headers); err != nil {
+		return nil, err
+	}
+
+	var opts Options
+	var found bool
+	for i := range headerCopies {
+		h := headers[i*headerSize : (i+1)*headerSize]
+		crc := binary.BigEndian.Uint32(h[headerSize-4:])
+		if string(h[:len(headerMagic)]) != headerMagic || crc32.Checksum(h[:headerSize-4], castagnoli) != crc {
+			continue
+		}
+		opts = Options{
+			DataShards:   int(h[len(headerMagic)]),
+			ParityShards: int(h[len(headerMagic)+1]),
+			ShardSize:    int(binary.BigEndian.Uint32(h[len(headerMagic)+2:])),
+		}
+		found = true
+		break
+	}
+	if !found {
+		return nil, errors.New("invalid fec header")
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, opts: opts, encoders: make(map[int]reedsolomon.Encoder)}, nil
+}
+
+// Repaired returns the number of shards reconstructed so far.
+func (f *Reader) Repaired() int {
+	return f.repaired
+}
+
+func (f *Reader) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.done {
+			return 0, io.EOF
+		}
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+// next reads one group. The group size is taken from the first intact record, so damaged
+// records are read as placeholders until one tells how many records the group has.
+func (f *Reader) next() error {
+	recordSize := recordHeaderSize + f.opts.ShardSize
+	var (
+		shards     [][]byte
+		dataShards int
+		dataLen    int
+		final      bool
+		known      bool
+		damaged    int
+		eof        bool
+	)
+	for !known && len(shards) < f.opts.DataShards+f.opts.ParityShards || known && len(shards) < dataShards+f.opts.ParityShards {
+		record := make([]byte, recordSize)
+		if !eof {
+			n, err := io.ReadFull(f.r, record)
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return err
+			}
+			if err != nil {
+				eof = true
+				if n == 0 && len(shards) == 0 {
+					return errors.New("truncated fec stream: missing final group")
+				}
+			}
+		}
+		index := len(shards)
+		if eof || binary.BigEndian.Uint32(record[:4]) != recordChecksum(f.group, index, record) {
+			shards = append(shards, nil)
+			damaged++
+			continue
+		}
+		shards = append(shards, record[recordHeaderSize:])
+		if !known {
+			known = true
+			final = record[4]&flagFinal != 0
+			dataShards = int(record[5])
+			dataLen = int(binary.BigEndian.Uint32(record[6:10]))
+			if dataShards < 1 || dataShards > f.opts.DataShards || dataLen > dataShards*f.opts.ShardSize ||
+				!final && (dataShards != f.opts.DataShards || dataLen != dataShards*f.opts.ShardSize) {
+				return fmt.Errorf("group %d: invalid record header", f.group)
+			}
+		}
+	}
+
+	if !known || damaged > f.opts.ParityShards {
+		return fmt.Errorf("group %d: %d damaged shards, can repair at most %d", f.group, damaged, f.opts.ParityShards)
+	}
+	if damaged > 0 {
+		enc, err := f.encoder(dataShards)
+		if err != nil {
+			return err
+		}
+		if err := enc.ReconstructData(shards); err != nil {
+			return fmt.Errorf("group %d: %w", f.group, err)
+		}
+		f.repaired += damaged
+	}
+
+	data := make([]byte, 0, dataShards*f.opts.ShardSize)
+	for _, s := range shards[:dataShards] {
+		data = append(data, s...)
+	}
+	f.buf = data[:dataLen]
+	f.group++
+
+	if final {
+		f.done = true
+		if !eof {
+			var b [1]byte
+			if n, _ := io.ReadFull(f.r, b[:]); n > 0 {
+				return errors.New("unexpected data after final fec group")
+			}
+		}
+	}
+	return nil
+}
+
+func (f *Reader) encoder(dataShards int) (reedsolomon.Encoder, error) {
+	if enc, ok := f.encoders[dataShards]; ok {
+		return enc, nil
+	}
+	enc, err := reedsolomon.New(dataShards, f.opts.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	f.encoders[dataShards] = enc
+	return enc, nil
+}
+
+// --- Crypter ---
+
+// Crypter adds parity around another crypter's output, so it slots into pipe unchanged.
+type Crypter struct {
+	Inner   crypt.Crypter
+	Options Options
+}
+
+var (
+	_ crypt.Crypter    = &Crypter{}
+	_ policy.Describer = &Crypter{}
+)
+
+// algorithms protect every fec stream, whatever the inner crypter.
+var algorithms = []policy.Algorithm{
+	{Kind: policy.ErasureCode, Name: "reed-solomon"},
+	{Kind: policy.Checksum, Name: "crc32c"},
+}
+
+func Wrap(inner crypt.Crypter, opts Options) crypt.Crypter {
+	return &Crypter{
+		Inner:   inner,
+		Options: opts,
+	}
+}
+
+func (c *Crypter) FileExtension() string {
+	return c.Inner.FileExtension() + ".fec"
+}
+
+func (c *Crypter) Name() string {
+	return c.Inner.Name() + "+reed-solomon"
+}
+
+// Algorithms lists the inner crypter's algorithms, then Reed-Solomon and CRC-32C. An inner
+// crypter that does not describe itself is listed as a cipher named by its type, so a policy
+// that restricts ciphers refuses it.
+func (c *Crypter) Algorithms() []policy.Algorithm {
+	var algs []policy.Algorithm
+	if d, ok := c.Inner.(policy.Describer); ok {
+		algs = append(algs, d.Algorithms()...)
+	} else {
+		algs = append(algs, policy.Algorithm{Kind: policy.Cipher, Name: fmt.Sprintf("%T", c.Inner)})
+	}
+	return append(algs, algorithms...)
+}
+
+// Encrypt and Decrypt check only the parity layer: the inner crypter checks itself, and the
+// algorithms recorded in its stream, when it starts.
+func (c *Crypter) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if err := policy.CheckAlgorithms(algorithms...); err != nil {
+		return nil, err
+	}
+	fw, err := NewWriter(w, c.Options)
+	if err != nil {
+		return nil, err
+	}
+	ew, err := c.Inner.Encrypt(fw)
+	if err != nil {
+		return nil, err
+	}
+	return &stackedWriter{inner: ew, outer: fw}, nil
+}
+
+func (c *Crypter) Decrypt(r io.Reader) (io.Reader, error) {
+	if err := policy.CheckAlgorithms(algorithms...); err != nil {
+		return nil, err
+	}
+	fr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return c.Inner.Decrypt(fr)
+}
+
+// stackedWriter closes the inner crypter's writer before the parity writer below it.
+type stackedWriter struct {
+	inner io.WriteCloser
+	outer io.WriteCloser
+}
+
+func (s *stackedWriter) Write(p []byte) (int, error) {
+	return s.inner.Write(p)
+}
+
+func (s *stackedWriter) Close() error {
+	if err := s.inner.Close(); err != nil {
+		return err
+	}
+	return s.outer.Close()
+}
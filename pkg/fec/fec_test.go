@@ -0,0 +1,206 @@
+package fec
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/secretstream"
+	"github.com/hashmap-kz/streamcrypt/pkg/pipe"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+var testOptions = Options{DataShards: 4, ParityShards: 2, ShardSize: 1000}
+
+func encode(t *testing.T, plain []byte, opts Options) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, opts)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func decode(encoded []byte) ([]byte, int, error) {
+	r, err := NewReader(bytes.NewReader(encoded))
+	if err != nil {
+		return nil, 0, err
+	}
+	got, err := io.ReadAll(r)
+	return got, r.Repaired(), err
+}
+
+func randomBytes(rng *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(rng.Uint32())
+	}
+	return b
+}
+
+const recordSize = recordHeaderSize + 1000
+
+// recordOffset returns the offset of a record in a stream encoded with testOptions.
+func recordOffset(group, index int) int {
+	return headerCopies*headerSize + (group*6+index)*recordSize
+}
+
+func TestFEC_Roundtrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, size := range []int{0, 1, 999, 1000, 3999, 4000, 4001, 12345} {
+		plain := randomBytes(rng, size)
+		got, repaired, err := decode(encode(t, plain, testOptions))
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, plain, got)
+		assert.Zero(t, repaired)
+	}
+}
+
+func TestFEC_SmallLastGroup(t *testing.T) {
+	encoded := encode(t, []byte("tiny"), testOptions)
+	assert.Len(t, encoded, headerCopies*headerSize+3*recordSize, "one data shard and two parity shards")
+}
+
+func TestFEC_RandomCorruption(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	plain := randomBytes(rng, 10*4000+1234)
+	encoded := encode(t, plain, testOptions)
+
+	for trial := 0; trial < 20; trial++ {
+		damaged := bytes.Clone(encoded)
+		for group := 0; group < 11; group++ {
+			records := 6
+			if group == 10 {
+				records = 2 + 2
+			}
+			// Corrupt up to ParityShards random records of every group, at random places.
+			for _, index := range rng.Perm(records)[:rng.IntN(3)] {
+				off := recordOffset(group, index) + rng.IntN(recordSize)
+				damaged[off] ^= byte(1 + rng.IntN(255))
+			}
+		}
+		// And one header copy.
+		damaged[rng.IntN(headerSize)] ^= 0xff
+
+		got, _, err := decode(damaged)
+		require.NoError(t, err, "trial %d", trial)
+		require.True(t, bytes.Equal(plain, got), "trial %d", trial)
+	}
+}
+
+func TestFEC_MissingShards(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	plain := randomBytes(rng, 2*4000+2500)
+	encoded := encode(t, plain, testOptions)
+
+	// Unreadable sectors read back as zeros, and the last parity shard is cut off.
+	damaged := bytes.Clone(encoded)
+	clear(damaged[recordOffset(0, 0):recordOffset(0, 2)])
+	clear(damaged[recordOffset(1, 5) : recordOffset(1, 5)+recordSize])
+	damaged = damaged[:len(damaged)-recordSize]
+
+	got, repaired, err := decode(damaged)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+	assert.Equal(t, 4, repaired)
+}
+
+func TestFEC_TooMuchDamage(t *testing.T) {
+	encoded := encode(t, bytes.Repeat([]byte{1}, 8000), testOptions)
+	for _, index := range []int{0, 3, 5} {
+		encoded[recordOffset(1, index)+20] ^= 1
+	}
+	_, _, err := decode(encoded)
+	require.ErrorContains(t, err, "group 1: 3 damaged shards, can repair at most 2")
+}
+
+func TestFEC_MisplacedRecords(t *testing.T) {
+	plain := bytes.Repeat([]byte("abcd"), 2000)
+	encoded := encode(t, plain, testOptions)
+	// Swapping two records of a group is treated as damage to both, which is repairable.
+	a := bytes.Clone(encoded[recordOffset(0, 1):recordOffset(0, 2)])
+	copy(encoded[recordOffset(0, 1):], encoded[recordOffset(0, 2):recordOffset(0, 3)])
+	copy(encoded[recordOffset(0, 2):], a)
+
+	got, repaired, err := decode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+	assert.Equal(t, 2, repaired)
+}
+
+func TestFEC_InvalidInput(t *testing.T) {
+	_, err := NewWriter(io.Discard, Options{DataShards: 200, ParityShards: 100, ShardSize: 10})
+	require.Error(t, err)
+	_, err = NewWriter(io.Discard, Options{DataShards: 1, ParityShards: 1})
+	require.Error(t, err)
+
+	encoded := encode(t, []byte("data"), testOptions)
+	_, _, err = decode(encoded[:headerCopies*headerSize])
+	require.ErrorContains(t, err, "missing final group")
+	_, _, err = decode(append(bytes.Clone(encoded), 0))
+	require.ErrorContains(t, err, "unexpected data")
+
+	broken := bytes.Clone(encoded)
+	for i := range headerCopies {
+		broken[i*headerSize] ^= 1
+	}
+	_, _, err = decode(broken)
+	require.ErrorContains(t, err, "invalid fec header")
+}
+
+func TestCrypter_WithPipe(t *testing.T) {
+	rng := rand.New(rand.NewPCG(7, 8))
+	plain := randomBytes(rng, 300000)
+	crypter := Wrap(aesgcm.NewChunkedGCMCrypter("fec"), DefaultOptions)
+	assert.Equal(t, ".aes.fec", crypter.FileExtension())
+
+	r, err := pipe.CompressAndEncryptOptional(bytes.NewReader(plain), &codec.ZstdCompressor{}, crypter)
+	require.NoError(t, err)
+	encoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	// Damage one shard in every group: without parity, AES-GCM would reject the stream.
+	groupSize := (DefaultOptions.DataShards + DefaultOptions.ParityShards) * (recordHeaderSize + DefaultOptions.ShardSize)
+	for off := headerCopies*headerSize + 100; off < len(encoded); off += groupSize {
+		encoded[off] ^= 0xff
+	}
+
+	dr, err := pipe.DecryptAndDecompressOptional(bytes.NewReader(encoded), crypter, &codec.ZstdDecompressor{})
+	require.NoError(t, err)
+	got, err := io.ReadAll(dr)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(plain, got))
+}
+
+// undescribed hides the Algorithms method of the crypter it embeds.
+type undescribed struct{ crypt.Crypter }
+
+func TestCrypter_Algorithms(t *testing.T) {
+	t.Cleanup(func() { policy.SetDefault(nil) })
+	inner := secretstream.NewXChaCha20Poly1305Crypter(bytes.Repeat([]byte{1}, 32))
+	c := Wrap(inner, DefaultOptions).(*Crypter)
+	assert.Equal(t, append(inner.(policy.Describer).Algorithms(),
+		policy.Algorithm{Kind: policy.ErasureCode, Name: "reed-solomon"},
+		policy.Algorithm{Kind: policy.Checksum, Name: "crc32c"},
+	), c.Algorithms())
+
+	require.ErrorIs(t, policy.FIPS().Check(c), policy.ErrNotAllowed)
+	err := policy.FIPS().Check(Wrap(undescribed{aesgcm.NewChunkedGCMCrypter("pw")}, DefaultOptions))
+	require.ErrorContains(t, err, "cipher fec.undescribed")
+
+	policy.SetDefault(&policy.Policy{Name: "no-parity", Allowed: map[policy.Kind][]string{policy.ErasureCode: {}}})
+	_, err = c.Encrypt(io.Discard)
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+	_, err = c.Decrypt(bytes.NewReader(nil))
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+}
@@ -0,0 +1,221 @@
+// Package merkle builds a Merkle tree over the chunks of a stream while it is written, and
+// verifies chunks and byte ranges against the tree's root hash, in the spirit of Bao.
+//
+// The tree can cover plaintext or ciphertext: hash whatever is published. Hashes are SHA-256
+// with domain separation:
+//
+//	leaf = SHA-256(0x00 || chunk)
+//	node = SHA-256(0x01 || left || right); a node without a sibling moves up unchanged
+//	root = SHA-256(0x02 || chunk size(4) || length(8) || top node)
+//
+// The outboard file stores the tree next to the data, level by level from the leaves up:
+//
+//	"MRKLv1\x00\x00" || chunk size(4) || length(8) || level 0 || level 1 || ... || top node
+//
+// A verifier trusts only the root; the outboard file is checked against it as it is read.
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// --- Constants ---
+
+const (
+	DefaultChunkSize = 64 * 1024
+	HashSize         = sha256.Size
+
+	outboardMagic      = "MRKLv1\x00\x00"
+	outboardHeaderSize = len(outboardMagic) + 4 + 8
+	maxChunkSize       = 1 << 30
+)
+
+var ErrVerification = errors.New("merkle verification failed: data does not match the root hash")
+
+// --- Hashing ---
+
+func leafHash(chunk []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(chunk)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+func rootHash(chunkSize int, length int64, top []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x02})
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(chunkSize)))
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(length)))
+	h.Write(top)
+	return h.Sum(nil)
+}
+
+// --- Tree Layout ---
+
+// layout describes the shape of a tree; it depends only on the chunk size and length.
+type layout struct {
+	chunkSize int
+	length    int64
+	levels    []int64 // node count per level, leaves first
+	offsets   []int64 // offset of every level in the outboard file
+}
+
+func newLayout(chunkSize int, length int64) (*layout, error) {
+	if chunkSize < 1 || chunkSize > maxChunkSize || length < 0 {
+		return nil, errors.New("invalid merkle tree parameters")
+	}
+	l := &layout{chunkSize: chunkSize, length: length}
+	n := max(1, (length+int64(chunkSize)-1)/int64(chunkSize))
+	offset := int64(outboardHeaderSize)
+	for {
+		l.levels = append(l.levels, n)
+		l.offsets = append(l.offsets, offset)
+		offset += n * HashSize
+		if n == 1 {
+			break
+		}
+		n = (n + 1) / 2
+	}
+	return l, nil
+}
+
+func (l *layout) chunks() int64 {
+	return l.levels[0]
+}
+
+// chunkLen returns the length of chunk i.
+func (l *layout) chunkLen(i int64) int {
+	return int(min(int64(l.chunkSize), l.length-i*int64(l.chunkSize)))
+}
+
+func outboardHeader(chunkSize int, length int64) []byte {
+	b := append([]byte(nil), outboardMagic...)
+	b = binary.BigEndian.AppendUint32(b, uint32(chunkSize))
+	return binary.BigEndian.AppendUint64(b, uint64(length))
+}
+
+// --- Writer ---
+
+// Writer passes data through to an optional destination and hashes it chunk by chunk.
+// It keeps one leaf hash (32 bytes) per chunk in memory until Close.
+type Writer struct {
+	w         io.Writer
+	chunkSize int
+	buf       []byte
+	leaves    [][]byte
+	length    int64
+	tree      [][][]byte
+	root      []byte
+}
+
+// NewWriter returns a Writer that copies everything to w, which may be nil.
+// A chunkSize of zero selects DefaultChunkSize.
+func NewWriter(w io.Writer, chunkSize int) *Writer {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &Writer{
+		w:         w,
+		chunkSize: chunkSize,
+		buf:       make([]byte, 0, chunkSize),
+	}
+}
+
+func (t *Writer) Write(p []byte) (int, error) {
+	if t.root != nil {
+		return 0, errors.New("write after close")
+	}
+	if t.w != nil {
+		n, err := t.w.Write(p)
+		if err != nil {
+			p = p[:n]
+			t.hash(p)
+			return n, err
+		}
+	}
+	t.hash(p)
+	return len(p), nil
+}
+
+func (t *Writer) hash(p []byte) {
+	t.length += int64(len(p))
+	for len(p) > 0 {
+		n := copy(t.buf[len(t.buf):t.chunkSize], p)
+		t.buf = t.buf[:len(t.buf)+n]
+		p = p[n:]
+		if len(t.buf) == t.chunkSize {
+			t.leaves = append(t.leaves, leafHash(t.buf))
+			t.buf = t.buf[:0]
+		}
+	}
+}
+
+// Close hashes the last chunk and builds the tree. It does not close the destination.
+func (t *Writer) Close() error {
+	if t.root != nil {
+		return nil
+	}
+	if len(t.buf) > 0 || len(t.leaves) == 0 {
+		t.leaves = append(t.leaves, leafHash(t.buf))
+	}
+
+	level := t.leaves
+	t.tree = [][][]byte{level}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, nodeHash(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		t.tree = append(t.tree, next)
+		level = next
+	}
+	t.root = rootHash(t.chunkSize, t.length, level[0])
+	return nil
+}
+
+// Root returns the root hash; it is available after Close.
+func (t *Writer) Root() []byte {
+	return t.root
+}
+
+// Length returns the number of bytes written.
+func (t *Writer) Length() int64 {
+	return t.length
+}
+
+// WriteOutboard writes the outboard tree file; it is available after Close.
+func (t *Writer) WriteOutboard(w io.Writer) (int64, error) {
+	if t.root == nil {
+		return 0, errors.New("outboard tree is available after Close")
+	}
+	n, err := w.Write(outboardHeader(t.chunkSize, t.length))
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+	for _, level := range t.tree {
+		for _, node := range level {
+			n, err := w.Write(node)
+			total += int64(n)
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
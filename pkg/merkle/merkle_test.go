@@ -0,0 +1,81 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func build(t *testing.T, data []byte, chunkSize int) (root, outboard []byte) {
+	t.Helper()
+	var out bytes.Buffer
+	w := NewWriter(&out, chunkSize)
+	for len(data) > 0 {
+		n := min(len(data), 777)
+		_, err := w.Write(data[:n])
+		require.NoError(t, err)
+		data = data[n:]
+	}
+	require.NoError(t, w.Close())
+
+	var tree bytes.Buffer
+	n, err := w.WriteOutboard(&tree)
+	require.NoError(t, err)
+	assert.Equal(t, int64(tree.Len()), n)
+	return w.Root(), tree.Bytes()
+}
+
+func TestWriter_PassesDataThrough(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out, 16)
+	_, err := w.Write([]byte("hello merkle tree"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, "hello merkle tree", out.String())
+	assert.Equal(t, int64(17), w.Length())
+
+	_, err = w.Write([]byte("late"))
+	require.Error(t, err)
+}
+
+func TestWriter_RootMatchesManualTree(t *testing.T) {
+	data := []byte("aaaabbbbccccd")
+	root, outboard := build(t, data, 4)
+
+	a, b, c, d := leafHash([]byte("aaaa")), leafHash([]byte("bbbb")), leafHash([]byte("cccc")), leafHash([]byte("d"))
+	top := nodeHash(nodeHash(a, b), nodeHash(c, d))
+	assert.Equal(t, rootHash(4, 13, top), root)
+
+	// Header, 4 leaves, 2 nodes, 1 top node.
+	assert.Len(t, outboard, outboardHeaderSize+7*HashSize)
+
+	// An odd node moves up unchanged.
+	root, _ = build(t, []byte("aaaabbbbcccc"), 4)
+	assert.Equal(t, rootHash(4, 12, nodeHash(nodeHash(a, b), c)), root)
+}
+
+func TestWriter_RootBindsLengthAndChunkSize(t *testing.T) {
+	empty, _ := build(t, nil, 0)
+	assert.Equal(t, rootHash(DefaultChunkSize, 0, leafHash(nil)), empty)
+
+	r1, _ := build(t, bytes.Repeat([]byte{1}, 100), 10)
+	r2, _ := build(t, bytes.Repeat([]byte{1}, 100), 20)
+	r3, _ := build(t, bytes.Repeat([]byte{1}, 101), 10)
+	assert.NotEqual(t, hex.EncodeToString(r1), hex.EncodeToString(r2))
+	assert.NotEqual(t, hex.EncodeToString(r1), hex.EncodeToString(r3))
+}
+
+func TestWriter_WithoutDestination(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 1000)
+	want, _ := build(t, data, 64)
+
+	w := NewWriter(nil, 64)
+	_, err := io.Copy(w, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, want, w.Root())
+}
@@ -0,0 +1,180 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// --- Verifier ---
+
+// Verifier checks chunks against a trusted root hash, reading only the sibling
+// hashes it needs from the outboard tree file.
+type Verifier struct {
+	layout   *layout
+	outboard io.ReaderAt
+	top      []byte
+}
+
+// NewVerifier reads the outboard header and top node and checks them against root.
+func NewVerifier(root []byte, outboard io.ReaderAt) (*Verifier, error) {
+	header := make([]byte, outboardHeaderSize)
+	if _, err := outboard.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("read outboard header: %w", err)
+	}
+	if string(header[:len(outboardMagic)]) != outboardMagic {
+		return nil, errors.New("invalid outboard header")
+	}
+	chunkSize := int(binary.BigEndian.Uint32(header[len(outboardMagic):]))
+	length := int64(binary.BigEndian.Uint64(header[len(outboardMagic)+4:]))
+	if length < 0 {
+		return nil, errors.New("invalid outboard header")
+	}
+	l, err := newLayout(chunkSize, length)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Verifier{layout: l, outboard: outboard}
+	top, err := v.node(len(l.levels)-1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(rootHash(chunkSize, length, top), root) {
+		return nil, ErrVerification
+	}
+	v.top = top
+	return v, nil
+}
+
+// Length returns the length of the data covered by the tree.
+func (v *Verifier) Length() int64 {
+	return v.layout.length
+}
+
+// ChunkSize returns the chunk size of the tree.
+func (v *Verifier) ChunkSize() int {
+	return v.layout.chunkSize
+}
+
+func (v *Verifier) node(level int, index int64) ([]byte, error) {
+	node := make([]byte, HashSize)
+	if _, err := v.outboard.ReadAt(node, v.layout.offsets[level]+index*HashSize); err != nil {
+		return nil, fmt.Errorf("read outboard tree: %w", err)
+	}
+	return node, nil
+}
+
+// VerifyChunk checks chunk number index, which must be complete.
+func (v *Verifier) VerifyChunk(index int64, chunk []byte) error {
+	if index < 0 || index >= v.layout.chunks() {
+		return fmt.Errorf("chunk %d out of range", index)
+	}
+	if len(chunk) != v.layout.chunkLen(index) {
+		return fmt.Errorf("chunk %d: %w", index, ErrVerification)
+	}
+
+	h := leafHash(chunk)
+	i := index
+	for level := 0; level < len(v.layout.levels)-1; level++ {
+		if sibling := i ^ 1; sibling < v.layout.levels[level] {
+			s, err := v.node(level, sibling)
+			if err != nil {
+				return err
+			}
+			if i&1 == 0 {
+				h = nodeHash(h, s)
+			} else {
+				h = nodeHash(s, h)
+			}
+		}
+		i >>= 1
+	}
+	if !bytes.Equal(h, v.top) {
+		return fmt.Errorf("chunk %d: %w", index, ErrVerification)
+	}
+	return nil
+}
+
+// NewRangeReader returns the n bytes at offset off of data, verifying every chunk
+// they touch before returning any of its bytes.
+func (v *Verifier) NewRangeReader(data io.ReaderAt, off, n int64) (io.Reader, error) {
+	if off < 0 || n < 0 || off+n > v.layout.length {
+		return nil, fmt.Errorf("range [%d, %d) out of bounds", off, off+n)
+	}
+	return &rangeReader{v: v, data: data, off: off, end: off + n}, nil
+}
+
+type rangeReader struct {
+	v    *Verifier
+	data io.ReaderAt
+	off  int64
+	end  int64
+	buf  []byte
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.off >= r.end {
+			return 0, io.EOF
+		}
+		chunkSize := int64(r.v.layout.chunkSize)
+		index := r.off / chunkSize
+		chunk := make([]byte, r.v.layout.chunkLen(index))
+		if _, err := r.data.ReadAt(chunk, index*chunkSize); err != nil {
+			return 0, err
+		}
+		if err := r.v.VerifyChunk(index, chunk); err != nil {
+			return 0, err
+		}
+		start := r.off - index*chunkSize
+		stop := min(int64(len(chunk)), r.end-index*chunkSize)
+		r.buf = chunk[start:stop]
+		r.off += stop - start
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// NewReader verifies a whole stream chunk by chunk as it is read, and fails if it is
+// shorter or longer than the tree says.
+func (v *Verifier) NewReader(r io.Reader) io.Reader {
+	return &streamReader{v: v, r: r}
+}
+
+type streamReader struct {
+	v     *Verifier
+	r     io.Reader
+	index int64
+	buf   []byte
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.index == s.v.layout.chunks() {
+			var b [1]byte
+			if n, _ := io.ReadFull(s.r, b[:]); n > 0 {
+				return 0, fmt.Errorf("data longer than %d bytes: %w", s.v.layout.length, ErrVerification)
+			}
+			return 0, io.EOF
+		}
+		chunk := make([]byte, s.v.layout.chunkLen(s.index))
+		if _, err := io.ReadFull(s.r, chunk); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return 0, fmt.Errorf("data shorter than %d bytes: %w", s.v.layout.length, ErrVerification)
+			}
+			return 0, err
+		}
+		if err := s.v.VerifyChunk(s.index, chunk); err != nil {
+			return 0, err
+		}
+		s.index++
+		s.buf = chunk
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,122 @@
+package merkle
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"github.com/hashmap-kz/streamcrypt/pkg/pipe"
+)
+
+func testData(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*31 + i/7)
+	}
+	return b
+}
+
+func TestVerifier_Ranges(t *testing.T) {
+	for _, size := range []int{0, 1, 99, 100, 101, 1000, 1234} {
+		data := testData(size)
+		root, outboard := build(t, data, 100)
+		v, err := NewVerifier(root, bytes.NewReader(outboard))
+		require.NoError(t, err)
+		assert.Equal(t, int64(size), v.Length())
+		assert.Equal(t, 100, v.ChunkSize())
+
+		for _, r := range [][2]int{{0, size}, {0, 0}, {size / 3, size / 2}, {size / 2, size - size/2}} {
+			rr, err := v.NewRangeReader(bytes.NewReader(data), int64(r[0]), int64(r[1]))
+			require.NoError(t, err)
+			got, err := io.ReadAll(rr)
+			require.NoError(t, err, "size %d range %v", size, r)
+			assert.Equal(t, data[r[0]:r[0]+r[1]], got)
+		}
+
+		got, err := io.ReadAll(v.NewReader(bytes.NewReader(data)))
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+	}
+}
+
+func TestVerifier_DetectsTampering(t *testing.T) {
+	data := testData(1000)
+	root, outboard := build(t, data, 100)
+	v, err := NewVerifier(root, bytes.NewReader(outboard))
+	require.NoError(t, err)
+
+	tampered := bytes.Clone(data)
+	tampered[550] ^= 1
+
+	// Only the tampered chunk fails.
+	require.NoError(t, v.VerifyChunk(4, tampered[400:500]))
+	require.ErrorIs(t, v.VerifyChunk(5, tampered[500:600]), ErrVerification)
+	require.ErrorIs(t, v.VerifyChunk(5, data[400:500]), ErrVerification, "chunks cannot be moved")
+
+	rr, err := v.NewRangeReader(bytes.NewReader(tampered), 520, 100)
+	require.NoError(t, err)
+	_, err = io.ReadAll(rr)
+	require.ErrorIs(t, err, ErrVerification)
+
+	_, err = io.ReadAll(v.NewReader(bytes.NewReader(data[:950])))
+	require.ErrorIs(t, err, ErrVerification)
+	_, err = io.ReadAll(v.NewReader(bytes.NewReader(append(bytes.Clone(data), 0))))
+	require.ErrorIs(t, err, ErrVerification)
+
+	_, err = v.NewRangeReader(bytes.NewReader(data), 900, 101)
+	require.Error(t, err)
+}
+
+func TestVerifier_UntrustedOutboard(t *testing.T) {
+	data := testData(1000)
+	root, outboard := build(t, data, 100)
+
+	_, err := NewVerifier(make([]byte, HashSize), bytes.NewReader(outboard))
+	require.ErrorIs(t, err, ErrVerification)
+
+	// A forged length or top node does not match the root.
+	forged := bytes.Clone(outboard)
+	forged[outboardHeaderSize-1]++
+	_, err = NewVerifier(root, bytes.NewReader(forged))
+	require.Error(t, err)
+
+	// A forged sibling hash only breaks the chunks whose proof uses it.
+	forged = bytes.Clone(outboard)
+	forged[outboardHeaderSize+3*HashSize] ^= 1 // leaf 3
+	v, err := NewVerifier(root, bytes.NewReader(forged))
+	require.NoError(t, err)
+	require.ErrorIs(t, v.VerifyChunk(2, data[200:300]), ErrVerification)
+	require.NoError(t, v.VerifyChunk(7, data[700:800]))
+
+	_, err = NewVerifier(root, bytes.NewReader([]byte("short")))
+	require.Error(t, err)
+}
+
+func TestVerifier_OverCiphertext(t *testing.T) {
+	plain := testData(300000)
+	crypter := aesgcm.NewChunkedGCMCrypter("merkle")
+
+	// Hash the ciphertext while it is produced, so anyone can verify it without the password.
+	r, err := pipe.CompressAndEncryptOptional(bytes.NewReader(plain), nil, crypter)
+	require.NoError(t, err)
+	var encrypted bytes.Buffer
+	tree := NewWriter(&encrypted, 0)
+	_, err = io.Copy(tree, r)
+	require.NoError(t, err)
+	require.NoError(t, tree.Close())
+	var outboard bytes.Buffer
+	_, err = tree.WriteOutboard(&outboard)
+	require.NoError(t, err)
+
+	v, err := NewVerifier(tree.Root(), bytes.NewReader(outboard.Bytes()))
+	require.NoError(t, err)
+	dr, err := pipe.DecryptAndDecompressOptional(v.NewReader(bytes.NewReader(encrypted.Bytes())), crypter, nil)
+	require.NoError(t, err)
+	got, err := io.ReadAll(dr)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+}
@@ -1,11 +1,15 @@
 package pipe
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/hashmap-kz/streamcrypt/pkg/codec"
 	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
 )
 
 // --- Pipeline ---
@@ -15,6 +19,22 @@ func CompressAndEncryptOptional(
 	compressor codec.Compressor,
 	crypter crypt.Crypter,
 ) (io.Reader, error) {
+	return CompressAndEncryptWithContext(source, compressor, crypter, nil)
+}
+
+// CompressAndEncryptWithContext is like CompressAndEncryptOptional, but binds the stream to
+// associatedData (e.g. the object path), which must be passed again to decrypt it.
+// The crypter must implement crypt.ContextCrypter unless associatedData is empty.
+func CompressAndEncryptWithContext(
+	source io.Reader,
+	compressor codec.Compressor,
+	crypter crypt.Crypter,
+	associatedData []byte,
+) (io.Reader, error) {
+	encrypt, err := contextEncrypt(crypter, associatedData)
+	if err != nil {
+		return nil, err
+	}
 	pr, pw := io.Pipe()
 
 	go func() {
@@ -27,7 +47,7 @@ func CompressAndEncryptOptional(
 		// Wrap encryption
 		if crypter != nil {
 			var err error
-			encWriter, err = crypter.Encrypt(dst)
+			encWriter, err = encrypt(dst)
 			if err != nil {
 				_ = pw.CloseWithError(err)
 				return
@@ -70,11 +90,29 @@ func DecryptAndDecompressOptional(
 	crypter crypt.Crypter,
 	decompressor codec.Decompressor,
 ) (io.ReadCloser, error) {
-	var err error
+	return DecryptAndDecompressWithContext(reader, crypter, decompressor, nil)
+}
+
+// DecryptAndDecompressWithContext is like DecryptAndDecompressOptional for streams written by
+// CompressAndEncryptWithContext; a different associatedData fails with crypt.ErrContextMismatch.
+func DecryptAndDecompressWithContext(
+	reader io.Reader,
+	crypter crypt.Crypter,
+	decompressor codec.Decompressor,
+	associatedData []byte,
+) (io.ReadCloser, error) {
+	cc, err := contextCrypter(crypter, associatedData)
+	if err != nil {
+		return nil, err
+	}
 
 	// Decrypt
 	if crypter != nil {
-		reader, err = crypter.Decrypt(reader)
+		if cc != nil {
+			reader, err = cc.DecryptWithContext(reader, associatedData)
+		} else {
+			reader, err = crypter.Decrypt(reader)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -91,3 +129,256 @@ func DecryptAndDecompressOptional(
 	// Decompress
 	return decompressor.Decompress(reader)
 }
+
+// --- Policy ---
+
+// CompressAndEncryptWithPolicy is like CompressAndEncryptOptional, but first checks the
+// compressor and the crypter against pol, on top of the process-wide policy they check
+// themselves. Components that do not implement policy.Describer are refused.
+func CompressAndEncryptWithPolicy(
+	source io.Reader,
+	compressor codec.Compressor,
+	crypter crypt.Crypter,
+	pol *policy.Policy,
+) (io.Reader, error) {
+	if err := pol.Check(compressor, crypter); err != nil {
+		return nil, err
+	}
+	return CompressAndEncryptOptional(source, compressor, crypter)
+}
+
+// DecryptAndDecompressWithPolicy is like DecryptAndDecompressOptional, but first checks the
+// crypter and the decompressor against pol. Choices recorded in a stream, such as the password
+// KDF of an aesgcm stream, are only checked against the process-wide policy.
+func DecryptAndDecompressWithPolicy(
+	reader io.Reader,
+	crypter crypt.Crypter,
+	decompressor codec.Decompressor,
+	pol *policy.Policy,
+) (io.ReadCloser, error) {
+	if err := pol.Check(crypter, decompressor); err != nil {
+		return nil, err
+	}
+	return DecryptAndDecompressOptional(reader, crypter, decompressor)
+}
+
+// --- Associated Data ---
+
+// contextCrypter returns the crypter as a crypt.ContextCrypter when associatedData is set.
+func contextCrypter(crypter crypt.Crypter, associatedData []byte) (crypt.ContextCrypter, error) {
+	if len(associatedData) == 0 {
+		return nil, nil
+	}
+	if crypter == nil {
+		return nil, errors.New("associated data requires a crypter")
+	}
+	cc, ok := crypter.(crypt.ContextCrypter)
+	if !ok {
+		return nil, fmt.Errorf("crypter %s does not support associated data", crypter.Name())
+	}
+	return cc, nil
+}
+
+func contextEncrypt(crypter crypt.Crypter, associatedData []byte) (func(io.Writer) (io.WriteCloser, error), error) {
+	cc, err := contextCrypter(crypter, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	if cc == nil {
+		if crypter == nil {
+			return nil, nil
+		}
+		return crypter.Encrypt, nil
+	}
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return cc.EncryptWithContext(w, associatedData)
+	}, nil
+}
+
+// --- Live Streams ---
+
+// LiveOptions configures CompressAndEncryptLive.
+type LiveOptions struct {
+	// FlushInterval, when set, flushes every stage this often while unflushed data is pending.
+	FlushInterval time.Duration
+
+	// AssociatedData is used as in CompressAndEncryptWithContext.
+	AssociatedData []byte
+
+	// Policy is checked as in CompressAndEncryptWithPolicy.
+	Policy *policy.Policy
+}
+
+// LiveReader is the encrypted output of CompressAndEncryptLive.
+type LiveReader struct {
+	*io.PipeReader
+	flush chan struct{}
+}
+
+// Flush asks the pipeline to flush the compressor and the encrypter, so everything read from
+// the source so far reaches the reader. It does not wait, so it is safe to call from the
+// goroutine that reads; repeated requests before the flush happens are merged.
+func (l *LiveReader) Flush() {
+	select {
+	case l.flush <- struct{}{}:
+	default:
+	}
+}
+
+// CompressAndEncryptLive is like CompressAndEncryptWithContext, but for live sources such as
+// log tails: buffered data is flushed through every stage on a timer or on demand instead of
+// waiting for full compression blocks and chunks. The crypter's writer must implement
+// codec.Flusher, e.g. aesgcm.ChunkedGCMCrypter with Flushable set.
+func CompressAndEncryptLive(
+	source io.Reader,
+	compressor codec.Compressor,
+	crypter crypt.Crypter,
+	opts LiveOptions,
+) (*LiveReader, error) {
+	if err := opts.Policy.Check(compressor, crypter); err != nil {
+		return nil, err
+	}
+	encrypt, err := contextEncrypt(crypter, opts.AssociatedData)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	live := &LiveReader{PipeReader: pr, flush: make(chan struct{}, 1)}
+
+	go func() {
+		defer pw.Close()
+
+		var dst io.Writer = pw
+		var flushers []codec.Flusher
+		var closers []io.Closer
+
+		if crypter != nil {
+			encWriter, err := encrypt(dst)
+			if err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+			f, ok := encWriter.(codec.Flusher)
+			if !ok {
+				_ = pw.CloseWithError(fmt.Errorf("crypter %s cannot flush its writer", crypter.Name()))
+				_ = encWriter.Close()
+				return
+			}
+			dst = encWriter
+			flushers = append(flushers, f)
+			closers = append(closers, encWriter)
+		}
+		if compressor != nil {
+			compWriter, err := compressor.NewWriter(dst)
+			if err != nil {
+				// Fail the pipe first, so closing the encrypter cannot end the stream cleanly.
+				_ = pw.CloseWithError(err)
+				for _, c := range closers {
+					_ = c.Close()
+				}
+				return
+			}
+			dst = compWriter
+			// Flush and close from the top of the stack down.
+			flushers = append([]codec.Flusher{compWriter}, flushers...)
+			closers = append([]io.Closer{compWriter}, closers...)
+		}
+
+		// A flush request that finds nothing pending applies to the next write: the data
+		// may already be read from the source, on its way to the stack.
+		var mu sync.Mutex
+		dirty, requested := false, false
+		flushLocked := func() error {
+			dirty, requested = false, false
+			for _, f := range flushers {
+				if err := f.Flush(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		flush := func(onDemand bool) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if !dirty {
+				requested = requested || onDemand
+				return nil
+			}
+			return flushLocked()
+		}
+		write := func(p []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if _, err := dst.Write(p); err != nil {
+				return err
+			}
+			dirty = true
+			if requested {
+				return flushLocked()
+			}
+			return nil
+		}
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var tick <-chan time.Time
+			if opts.FlushInterval > 0 {
+				ticker := time.NewTicker(opts.FlushInterval)
+				defer ticker.Stop()
+				tick = ticker.C
+			}
+			for {
+				var err error
+				select {
+				case <-done:
+					return
+				case <-tick:
+					err = flush(false)
+				case <-live.flush:
+					err = flush(true)
+				}
+				if err != nil {
+					_ = pw.CloseWithError(fmt.Errorf("flush: %w", err))
+					return
+				}
+			}
+		}()
+
+		// Reads happen outside the lock, so a source that blocks does not hold up flushes.
+		err := func() error {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := source.Read(buf)
+				if n > 0 {
+					if werr := write(buf[:n]); werr != nil {
+						return werr
+					}
+				}
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}()
+		close(done)
+		wg.Wait()
+		if err != nil {
+			_ = pw.CloseWithError(fmt.Errorf("copy: %w", err))
+			return
+		}
+
+		for _, c := range closers {
+			if err := c.Close(); err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	return live, nil
+}
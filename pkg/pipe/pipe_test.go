@@ -4,15 +4,23 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
 	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/envelope"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/secretstream"
+	"github.com/hashmap-kz/streamcrypt/pkg/fec"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -365,3 +373,181 @@ func TestCompressEncrypt_Then_DecryptDecompress(t *testing.T) {
 
 	require.Equal(t, original, decoded)
 }
+
+func TestPipe_WithContext(t *testing.T) {
+	plain := bytes.Repeat([]byte("tenant data "), 20000)
+	crypter := aesgcm.NewChunkedGCMCrypter("context")
+	compressor := &codec.ZstdCompressor{}
+
+	r, err := CompressAndEncryptWithContext(bytes.NewReader(plain), compressor, crypter, []byte("tenant-a/backups/a.aes"))
+	require.NoError(t, err)
+	encrypted, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	rc, err := DecryptAndDecompressWithContext(bytes.NewReader(encrypted), crypter, &codec.ZstdDecompressor{}, []byte("tenant-a/backups/a.aes"))
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+
+	// The same object under another path is rejected.
+	_, err = DecryptAndDecompressWithContext(bytes.NewReader(encrypted), crypter, &codec.ZstdDecompressor{}, []byte("tenant-a/backups/b.aes"))
+	require.ErrorIs(t, err, crypt.ErrContextMismatch)
+	_, err = DecryptAndDecompressOptional(bytes.NewReader(encrypted), crypter, &codec.ZstdDecompressor{})
+	require.ErrorIs(t, err, crypt.ErrContextMismatch)
+}
+
+func TestPipe_WithContextUnsupportedCrypter(t *testing.T) {
+	crypter := envelope.NewDecrypter()
+	_, err := CompressAndEncryptWithContext(bytes.NewReader(nil), nil, crypter, []byte("path"))
+	require.ErrorContains(t, err, "does not support associated data")
+
+	_, err = DecryptAndDecompressWithContext(bytes.NewReader(nil), nil, nil, []byte("path"))
+	require.Error(t, err)
+}
+
+func TestCompressAndEncryptLive(t *testing.T) {
+	crypter := &aesgcm.ChunkedGCMCrypter{Password: "live", Flushable: true}
+	lines := []string{"first line\n", "second line\n", "third line\n"}
+
+	for _, compressor := range []codec.Compressor{nil, codec.GzipCompressor{}, codec.ZstdCompressor{}} {
+		for mode, opts := range map[string]LiveOptions{
+			"timer":     {FlushInterval: 10 * time.Millisecond},
+			"on demand": {},
+		} {
+			source, feed := io.Pipe()
+			live, err := CompressAndEncryptLive(source, compressor, crypter, opts)
+			require.NoError(t, err)
+
+			// Each line must come out of the pipeline while the source is still open.
+			received := make(chan struct{})
+			go func() {
+				defer feed.Close()
+				for _, line := range lines {
+					if _, err := feed.Write([]byte(line)); err != nil {
+						return
+					}
+					if opts.FlushInterval == 0 {
+						live.Flush()
+					}
+					<-received
+				}
+			}()
+
+			// Decompressors read their header eagerly, so they are created once data flows.
+			dec, err := DecryptAndDecompressOptional(live, crypter, codec.GetDecompressor(compressor))
+			require.NoError(t, err)
+			for _, line := range lines {
+				got := make([]byte, len(line))
+				_, err = io.ReadFull(dec, got)
+				require.NoError(t, err, "%v %s", compressor, mode)
+				assert.Equal(t, line, string(got))
+				received <- struct{}{}
+			}
+			rest, err := io.ReadAll(dec)
+			require.NoError(t, err)
+			assert.Empty(t, rest)
+			require.NoError(t, dec.Close())
+		}
+	}
+}
+
+func TestCompressAndEncryptLive_RequiresFlushableCrypter(t *testing.T) {
+	live, err := CompressAndEncryptLive(strings.NewReader("data"), nil, &aesgcm.ChunkedGCMCrypter{Password: "live"}, LiveOptions{})
+	require.NoError(t, err)
+	_, err = io.ReadAll(live)
+	require.ErrorContains(t, err, "cannot flush")
+}
+
+type failingCompressor struct{ codec.GzipCompressor }
+
+func (failingCompressor) NewWriter(io.Writer) (codec.WriteFlushCloser, error) {
+	return nil, errors.New("compressor failed")
+}
+
+// closeRecorder is a flushable crypter that reports when its writer is closed.
+type closeRecorder struct {
+	crypt.Crypter
+	closed chan struct{}
+}
+
+func (c *closeRecorder) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	return &recordingWriter{Writer: w, c: c}, nil
+}
+
+type recordingWriter struct {
+	io.Writer
+	c *closeRecorder
+}
+
+func (w *recordingWriter) Flush() error { return nil }
+
+func (w *recordingWriter) Close() error {
+	close(w.c.closed)
+	return nil
+}
+
+func TestCompressAndEncryptLive_CompressorFailureClosesEncrypter(t *testing.T) {
+	crypter := &closeRecorder{closed: make(chan struct{})}
+	live, err := CompressAndEncryptLive(strings.NewReader("data"), failingCompressor{}, crypter, LiveOptions{})
+	require.NoError(t, err)
+	_, err = io.ReadAll(live)
+	require.ErrorContains(t, err, "compressor failed")
+	select {
+	case <-crypter.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the encrypter's writer was not closed")
+	}
+}
+
+func TestPipe_WithPolicy(t *testing.T) {
+	plain := bytes.Repeat([]byte("regulated "), 10000)
+	crypter := &aesgcm.ChunkedGCMCrypter{Password: "fips", KDF: aesgcm.PBKDF2Params{Iterations: aesgcm.MinPBKDF2Iterations}}
+
+	r, err := CompressAndEncryptWithPolicy(bytes.NewReader(plain), codec.GzipCompressor{}, crypter, policy.FIPS())
+	require.NoError(t, err)
+	encrypted, err := io.ReadAll(r)
+	require.NoError(t, err)
+	rc, err := DecryptAndDecompressWithPolicy(bytes.NewReader(encrypted), crypter, codec.GzipDecompressor{}, policy.FIPS())
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+
+	// The policy applies to this pipeline only.
+	key := bytes.Repeat([]byte{1}, 32)
+	_, err = CompressAndEncryptWithPolicy(bytes.NewReader(plain), nil, secretstream.NewXChaCha20Poly1305Crypter(key), policy.FIPS())
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+	require.ErrorContains(t, err, `violates rule "allowed cipher algorithms: aes-256-gcm"`)
+	_, err = CompressAndEncryptOptional(bytes.NewReader(plain), nil, secretstream.NewXChaCha20Poly1305Crypter(key))
+	require.NoError(t, err)
+
+	_, err = DecryptAndDecompressWithPolicy(bytes.NewReader(encrypted), aesgcm.NewChunkedGCMCrypter("fips"), nil, policy.FIPS())
+	require.ErrorContains(t, err, "kdf argon2id")
+	_, err = CompressAndEncryptLive(bytes.NewReader(plain), nil, aesgcm.NewChunkedGCMCrypter("fips"), LiveOptions{Policy: policy.FIPS()})
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+}
+
+func TestPipe_WithPolicyAndParity(t *testing.T) {
+	plain := bytes.Repeat([]byte("regulated and repairable "), 10000)
+	crypter := fec.Wrap(&aesgcm.ChunkedGCMCrypter{Password: "fips", KDF: aesgcm.PBKDF2Params{Iterations: aesgcm.MinPBKDF2Iterations}}, fec.DefaultOptions)
+
+	r, err := CompressAndEncryptWithPolicy(bytes.NewReader(plain), codec.GzipCompressor{}, crypter, policy.FIPS())
+	require.NoError(t, err)
+	encrypted, err := io.ReadAll(r)
+	require.NoError(t, err)
+	rc, err := DecryptAndDecompressWithPolicy(bytes.NewReader(encrypted), crypter, codec.GzipDecompressor{}, policy.FIPS())
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+
+	// The parity layer does not hide the inner crypter from the policy.
+	key := bytes.Repeat([]byte{1}, 32)
+	_, err = CompressAndEncryptWithPolicy(bytes.NewReader(plain), nil, fec.Wrap(secretstream.NewXChaCha20Poly1305Crypter(key), fec.DefaultOptions), policy.FIPS())
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+
+	noParity := &policy.Policy{Name: "no-parity", Allowed: map[policy.Kind][]string{policy.ErasureCode: {}}}
+	_, err = CompressAndEncryptWithPolicy(bytes.NewReader(plain), nil, crypter, noParity)
+	require.ErrorContains(t, err, "erasure code reed-solomon")
+}
@@ -0,0 +1,161 @@
+// Package policy restricts the algorithms crypters and codecs may use, for deployments that
+// must only run approved primitives.
+//
+// Crypters and codecs describe the algorithms they use and check them against the process-wide
+// policy (SetDefault) whenever they start encrypting, decrypting, compressing or decompressing.
+// Choices recorded in a stream, such as the password KDF of an aesgcm stream, are checked when
+// the header is read. Pipelines can enforce a policy of their own on top; see pkg/pipe.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Kind groups algorithms by role.
+type Kind string
+
+const (
+	Cipher       Kind = "cipher"
+	KDF          Kind = "kdf"
+	MAC          Kind = "mac"
+	Digest       Kind = "digest"
+	Checksum     Kind = "checksum"
+	KeyWrap      Kind = "key wrap"
+	KeyAgreement Kind = "key agreement"
+	Compression  Kind = "compression"
+	ErasureCode  Kind = "erasure code"
+)
+
+// Algorithm is one primitive used by a crypter or codec. KeyBits is the symmetric key size of
+// ciphers and MACs, and 0 otherwise.
+type Algorithm struct {
+	Kind    Kind
+	Name    string
+	KeyBits int
+}
+
+// Describer is implemented by crypters, codecs and key-wrapping recipients.
+type Describer interface {
+	Algorithms() []Algorithm
+}
+
+// Policy is an allowlist of algorithms. A Kind missing from Allowed is unrestricted; a Kind
+// mapped to an empty list allows nothing. A nil *Policy allows everything.
+type Policy struct {
+	Name       string
+	Allowed    map[Kind][]string
+	MinKeyBits int // minimum KeyBits of ciphers and MACs
+}
+
+// ErrNotAllowed is wrapped by every *Violation.
+var ErrNotAllowed = errors.New("algorithm not allowed by policy")
+
+// Violation names the rule an algorithm, or an undescribed component, breaks.
+type Violation struct {
+	Policy    string
+	Rule      string
+	Algorithm Algorithm
+}
+
+func (v *Violation) Error() string {
+	if v.Algorithm.Kind == "" {
+		return fmt.Sprintf("policy %q refuses %s: violates rule %q", v.Policy, v.Algorithm.Name, v.Rule)
+	}
+	return fmt.Sprintf("policy %q refuses %s %s: violates rule %q", v.Policy, v.Algorithm.Kind, v.Algorithm.Name, v.Rule)
+}
+
+func (v *Violation) Unwrap() error {
+	return ErrNotAllowed
+}
+
+// FIPS returns a policy that allows only FIPS 140-approved primitives: AES-256-GCM, keys from
+// PBKDF2-HMAC-SHA256 or HKDF-SHA256, HMAC-SHA256, SHA-256 and RSA-OAEP-SHA256 key wrapping.
+// Checksums and compression are not security functions and stay unrestricted.
+func FIPS() *Policy {
+	return &Policy{
+		Name: "fips",
+		Allowed: map[Kind][]string{
+			Cipher:       {"aes-256-gcm"},
+			KDF:          {"pbkdf2-hmac-sha256", "hkdf-sha256"},
+			MAC:          {"hmac-sha256"},
+			Digest:       {"sha256"},
+			KeyWrap:      {"rsa-oaep-sha256"},
+			KeyAgreement: {},
+		},
+		MinKeyBits: 256,
+	}
+}
+
+// CheckAlgorithms returns a *Violation for the first algorithm the policy does not allow.
+func (p *Policy) CheckAlgorithms(algs ...Algorithm) error {
+	if p == nil {
+		return nil
+	}
+	for _, alg := range algs {
+		if allowed, ok := p.Allowed[alg.Kind]; ok && !slices.Contains(allowed, alg.Name) {
+			rule := fmt.Sprintf("allowed %s algorithms: %s", alg.Kind, strings.Join(allowed, ", "))
+			if len(allowed) == 0 {
+				rule = fmt.Sprintf("no %s algorithm allowed", alg.Kind)
+			}
+			return &Violation{Policy: p.Name, Rule: rule, Algorithm: alg}
+		}
+		if (alg.Kind == Cipher || alg.Kind == MAC) && alg.KeyBits < p.MinKeyBits {
+			rule := fmt.Sprintf("minimum key size %d bits (got %d)", p.MinKeyBits, alg.KeyBits)
+			return &Violation{Policy: p.Name, Rule: rule, Algorithm: alg}
+		}
+	}
+	return nil
+}
+
+// Check checks the algorithms of every component. Nil components are skipped; components that
+// do not implement Describer are refused, since the policy cannot vouch for them.
+func (p *Policy) Check(components ...any) error {
+	if p == nil {
+		return nil
+	}
+	for _, c := range components {
+		if c == nil {
+			continue
+		}
+		d, ok := c.(Describer)
+		if !ok {
+			return &Violation{
+				Policy:    p.Name,
+				Rule:      "components must describe their algorithms",
+				Algorithm: Algorithm{Name: fmt.Sprintf("%T", c)},
+			}
+		}
+		if err := p.CheckAlgorithms(d.Algorithms()...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- Process-wide policy ---
+
+var current atomic.Pointer[Policy]
+
+// SetDefault sets the process-wide policy; nil removes it.
+func SetDefault(p *Policy) {
+	current.Store(p)
+}
+
+// Default returns the process-wide policy, or nil if there is none.
+func Default() *Policy {
+	return current.Load()
+}
+
+// Check checks components against the process-wide policy.
+func Check(components ...any) error {
+	return Default().Check(components...)
+}
+
+// CheckAlgorithms checks algorithms against the process-wide policy.
+func CheckAlgorithms(algs ...Algorithm) error {
+	return Default().CheckAlgorithms(algs...)
+}
@@ -0,0 +1,65 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type describer []Algorithm
+
+func (d describer) Algorithms() []Algorithm { return d }
+
+func TestFIPS(t *testing.T) {
+	p := FIPS()
+	require.NoError(t, p.CheckAlgorithms(
+		Algorithm{Kind: Cipher, Name: "aes-256-gcm", KeyBits: 256},
+		Algorithm{Kind: KDF, Name: "pbkdf2-hmac-sha256"},
+		Algorithm{Kind: MAC, Name: "hmac-sha256", KeyBits: 256},
+		Algorithm{Kind: Checksum, Name: "xxh64"},
+		Algorithm{Kind: Compression, Name: "zstd"},
+	))
+
+	err := p.CheckAlgorithms(Algorithm{Kind: KDF, Name: "argon2id"})
+	require.ErrorIs(t, err, ErrNotAllowed)
+	assert.EqualError(t, err, `policy "fips" refuses kdf argon2id: violates rule "allowed kdf algorithms: pbkdf2-hmac-sha256, hkdf-sha256"`)
+
+	var v *Violation
+	require.ErrorAs(t, err, &v)
+	assert.Equal(t, "argon2id", v.Algorithm.Name)
+
+	err = p.CheckAlgorithms(Algorithm{Kind: Cipher, Name: "aes-256-gcm", KeyBits: 128})
+	assert.EqualError(t, err, `policy "fips" refuses cipher aes-256-gcm: violates rule "minimum key size 256 bits (got 128)"`)
+
+	err = p.CheckAlgorithms(Algorithm{Kind: KeyAgreement, Name: "x25519"})
+	assert.ErrorContains(t, err, `violates rule "no key agreement algorithm allowed"`)
+}
+
+func TestPolicy_Check(t *testing.T) {
+	p := FIPS()
+	require.NoError(t, p.Check(nil, describer{{Kind: Digest, Name: "sha256"}}))
+	require.ErrorIs(t, p.Check(describer{{Kind: Digest, Name: "md5"}}), ErrNotAllowed)
+
+	// Components that cannot describe themselves are refused.
+	err := p.Check(struct{}{})
+	require.ErrorIs(t, err, ErrNotAllowed)
+	assert.ErrorContains(t, err, `refuses struct {}: violates rule "components must describe their algorithms"`)
+
+	// Without a policy everything is allowed.
+	var none *Policy
+	require.NoError(t, none.Check(struct{}{}, describer{{Kind: Digest, Name: "md5"}}))
+}
+
+func TestSetDefault(t *testing.T) {
+	t.Cleanup(func() { SetDefault(nil) })
+	require.Nil(t, Default())
+	require.NoError(t, Check(describer{{Kind: KDF, Name: "argon2id"}}))
+
+	SetDefault(FIPS())
+	require.ErrorIs(t, Check(describer{{Kind: KDF, Name: "argon2id"}}), ErrNotAllowed)
+	require.ErrorIs(t, CheckAlgorithms(Algorithm{Kind: MAC, Name: "hmac-sha1", KeyBits: 256}), ErrNotAllowed)
+
+	SetDefault(nil)
+	require.NoError(t, Check(describer{{Kind: KDF, Name: "argon2id"}}))
+}
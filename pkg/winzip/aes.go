@@ -0,0 +1,170 @@
+// Package winzip writes and reads ZIP archives whose entries are encrypted with WinZip AES, the
+// scheme 7-Zip, WinZip and most desktop archive tools open with a password.
+//
+// Each encrypted entry holds its compressed data encrypted with AES in WinZip's counter mode and
+// authenticated with HMAC-SHA1, both keyed by PBKDF2-HMAC-SHA1 (1000 iterations) of the password
+// and a random per-entry salt:
+//
+//	salt(8, 12 or 16) || password verifier(2) || encrypted data || HMAC-SHA1(encrypted data)[:10]
+//
+// The Writer produces AES-256 AE-2 entries, which leave out the CRC of the plaintext. The Reader
+// also accepts AES-128 and AES-192, and AE-1 entries, whose CRC it checks.
+//
+// The format prescribes these primitives, which are weaker than the rest of this module: use
+// the archives to hand data over to people, not to store it.
+package winzip
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1" //nolint:gosec // HMAC-SHA1 and PBKDF2-HMAC-SHA1 are prescribed by the format
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- Constants ---
+
+const (
+	methodStore   = 0
+	methodDeflate = 8
+	methodZstd    = 93
+	methodAES     = 99
+
+	flagEncrypted      = 0x1
+	flagDataDescriptor = 0x8
+	flagUTF8           = 0x800
+
+	aesExtraID     = 0x9901
+	extTimeExtraID = 0x5455
+	aesExtraSize   = 7
+	ae1            = 1
+	ae2            = 2
+	aes256         = 3 // strength byte of AES-256
+	zipVersionAES  = 51
+	verifierSize   = 2
+	authCodeSize   = 10
+	kdfIterations  = 1000
+	writerKeySize  = 32
+	writerSaltSize = writerKeySize / 2
+)
+
+var (
+	ErrPassword       = errors.New("incorrect password for encrypted entry")
+	ErrAuthentication = errors.New("entry authentication failed: tampering or corruption detected")
+)
+
+// --- AES extra field ---
+
+// aesExtra is the 0x9901 extra field of an encrypted entry, which holds the actual compression
+// method: the local and central headers record method 99.
+type aesExtra struct {
+	version  uint16 // ae1 or ae2
+	strength byte   // 1, 2, 3 for AES-128, AES-192, AES-256
+	method   uint16
+}
+
+func (e aesExtra) marshal() []byte {
+	b := binary.LittleEndian.AppendUint16(nil, aesExtraID)
+	b = binary.LittleEndian.AppendUint16(b, aesExtraSize)
+	b = binary.LittleEndian.AppendUint16(b, e.version)
+	b = append(b, 'A', 'E', e.strength)
+	return binary.LittleEndian.AppendUint16(b, e.method)
+}
+
+func parseAESExtra(extra []byte) (aesExtra, error) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if id == aesExtraID {
+			b := extra[:size]
+			if size != aesExtraSize || b[2] != 'A' || b[3] != 'E' {
+				return aesExtra{}, errors.New("invalid AES extra field")
+			}
+			e := aesExtra{
+				version:  binary.LittleEndian.Uint16(b),
+				strength: b[4],
+				method:   binary.LittleEndian.Uint16(b[5:]),
+			}
+			if e.version != ae1 && e.version != ae2 {
+				return aesExtra{}, fmt.Errorf("unsupported AE version: %d", e.version)
+			}
+			if e.strength < 1 || e.strength > aes256 {
+				return aesExtra{}, fmt.Errorf("unsupported AES strength: %d", e.strength)
+			}
+			return e, nil
+		}
+		extra = extra[size:]
+	}
+	return aesExtra{}, errors.New("missing AES extra field")
+}
+
+// keySize returns the AES key size; the salt is half as long.
+func (e aesExtra) keySize() int {
+	return 8 * (int(e.strength) + 1)
+}
+
+func (e aesExtra) algorithms() []policy.Algorithm {
+	return entryAlgorithms(e.keySize())
+}
+
+func entryAlgorithms(keySize int) []policy.Algorithm {
+	return []policy.Algorithm{
+		{Kind: policy.Cipher, Name: fmt.Sprintf("aes-%d-ctr", keySize*8), KeyBits: keySize * 8},
+		{Kind: policy.KDF, Name: "pbkdf2-hmac-sha1"},
+		{Kind: policy.MAC, Name: "hmac-sha1", KeyBits: keySize * 8},
+	}
+}
+
+// --- Keys ---
+
+// deriveKeys returns the derived key material, which the caller clears, split into the AES
+// key, the HMAC key and the password verifier.
+func deriveKeys(password, salt []byte, keySize int) (dk, encKey, macKey, verifier []byte) {
+	dk = pbkdf2.Key(password, salt, kdfIterations, 2*keySize+verifierSize, sha1.New)
+	return dk, dk[:keySize], dk[keySize : 2*keySize], dk[2*keySize:]
+}
+
+// --- Counter mode ---
+
+// ctr is WinZip's counter mode: the counter is a little-endian integer starting at 1, where
+// cipher.NewCTR increments a big-endian one.
+type ctr struct {
+	block     cipher.Block
+	counter   [aes.BlockSize]byte
+	keystream [aes.BlockSize]byte
+	used      int
+}
+
+var _ cipher.Stream = &ctr{}
+
+func newCTR(block cipher.Block) *ctr {
+	return &ctr{block: block, used: aes.BlockSize}
+}
+
+func (c *ctr) XORKeyStream(dst, src []byte) {
+	for len(src) > 0 {
+		if c.used == aes.BlockSize {
+			for i := range c.counter {
+				c.counter[i]++
+				if c.counter[i] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.keystream[:], c.counter[:])
+			c.used = 0
+		}
+		n := subtle.XORBytes(dst, src, c.keystream[c.used:])
+		c.used += n
+		dst, src = dst[n:], src[n:]
+	}
+}
@@ -0,0 +1,59 @@
+package winzip
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCTR_LittleEndianCounter(t *testing.T) {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{0x42}, 32))
+	require.NoError(t, err)
+	src := make([]byte, 3*aes.BlockSize+5)
+
+	// Block i is AES(i) with i as a little-endian integer, starting at 1.
+	want := make([]byte, 0, len(src))
+	for i := 1; len(want) < len(src); i++ {
+		counter := make([]byte, aes.BlockSize)
+		counter[0] = byte(i)
+		ks := make([]byte, aes.BlockSize)
+		block.Encrypt(ks, counter)
+		want = append(want, ks...)
+	}
+	want = want[:len(src)]
+
+	// Split writes continue the keystream.
+	got := make([]byte, len(src))
+	c := newCTR(block)
+	c.XORKeyStream(got[:7], src[:7])
+	c.XORKeyStream(got[7:], src[7:])
+	assert.Equal(t, want, got)
+
+	// The first block agrees with cipher.NewCTR, the second does not.
+	iv := make([]byte, aes.BlockSize)
+	iv[0] = 1
+	std := make([]byte, len(src))
+	cipher.NewCTR(block, iv).XORKeyStream(std, src)
+	assert.Equal(t, want[:aes.BlockSize], std[:aes.BlockSize])
+	assert.NotEqual(t, want[aes.BlockSize:2*aes.BlockSize], std[aes.BlockSize:2*aes.BlockSize])
+}
+
+func TestAESExtra(t *testing.T) {
+	e := aesExtra{version: ae2, strength: aes256, method: methodDeflate}
+	extra := append([]byte{0x55, 0x54, 1, 0, 1}, e.marshal()...)
+	got, err := parseAESExtra(extra)
+	require.NoError(t, err)
+	assert.Equal(t, e, got)
+	assert.Equal(t, 32, got.keySize())
+
+	_, err = parseAESExtra(extra[:5])
+	require.ErrorContains(t, err, "missing AES extra field")
+	_, err = parseAESExtra(aesExtra{version: 3, strength: aes256}.marshal())
+	require.ErrorContains(t, err, "unsupported AE version")
+	_, err = parseAESExtra(aesExtra{version: ae1, strength: 4}.marshal())
+	require.ErrorContains(t, err, "unsupported AES strength")
+}
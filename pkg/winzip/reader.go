@@ -0,0 +1,244 @@
+package winzip
+
+import (
+	"archive/zip"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // see aes.go
+	"crypto/subtle"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- Reader ---
+
+// Reader reads a ZIP archive with WinZip AES encrypted entries. Unencrypted entries are read
+// as well, with stored, deflate or zstd compression.
+type Reader struct {
+	File []*File
+
+	// SecretKey holds the password; Destroy zeroes it.
+	SecretKey *crypt.SecretKey
+}
+
+// File is an archive entry. For encrypted entries, Method is the compression method inside
+// the encryption, not WinZip's method 99.
+type File struct {
+	zip.FileHeader
+	Encrypted bool
+
+	zf     *zip.File
+	aes    aesExtra
+	reader *Reader
+}
+
+var _ policy.Describer = &File{}
+
+// NewReader copies password into a crypt.SecretKey, so the caller can wipe it afterwards.
+func NewReader(r io.ReaderAt, size int64, password []byte) (*Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	zr.RegisterDecompressor(methodZstd, func(r io.Reader) io.ReadCloser {
+		return decompressError(codec.ZstdDecompressor{}.Decompress(r))
+	})
+
+	reader := &Reader{SecretKey: crypt.NewSecretKey(password)}
+	for _, zf := range zr.File {
+		f := &File{FileHeader: zf.FileHeader, zf: zf, reader: reader}
+		if zf.Method == methodAES {
+			f.aes, err = parseAESExtra(zf.Extra)
+			if err != nil {
+				return nil, fmt.Errorf("entry %q: %w", zf.Name, err)
+			}
+			f.Method = f.aes.method
+			f.Encrypted = true
+		}
+		reader.File = append(reader.File, f)
+	}
+	return reader, nil
+}
+
+// Destroy zeroes the password; encrypted entries cannot be opened afterwards.
+func (r *Reader) Destroy() {
+	if r.SecretKey != nil {
+		r.SecretKey.Destroy()
+	}
+}
+
+func (f *File) Algorithms() []policy.Algorithm {
+	var algs []policy.Algorithm
+	if f.Encrypted {
+		algs = f.aes.algorithms()
+	}
+	if d, ok := decompressor(f.Method).(policy.Describer); ok {
+		algs = append(algs, d.Algorithms()...)
+	}
+	return algs
+}
+
+// Open returns the decompressed contents of the entry. Encrypted data is decrypted as it is
+// read and authenticated at its end: a wrong password fails here with ErrPassword, tampering
+// fails the read that reaches the end of the entry with ErrAuthentication.
+func (f *File) Open() (io.ReadCloser, error) {
+	if err := policy.Check(f); err != nil {
+		return nil, err
+	}
+	if !f.Encrypted {
+		return f.zf.Open()
+	}
+	if f.Method != methodStore && decompressor(f.Method) == nil {
+		return nil, fmt.Errorf("entry %q: unsupported compression method: %d", f.Name, f.Method)
+	}
+
+	keySize := f.aes.keySize()
+	saltSize := keySize / 2
+	overhead := uint64(saltSize + verifierSize + authCodeSize)
+	if f.CompressedSize64 < overhead {
+		return nil, fmt.Errorf("entry %q: truncated encrypted data", f.Name)
+	}
+	raw, err := f.zf.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, saltSize+verifierSize)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, err
+	}
+	salt, verifier := header[:saltSize], header[saltSize:]
+
+	o := &opener{raw: raw}
+	err = f.reader.SecretKey.Use(func(password []byte) error {
+		dk, encKey, macKey, v := deriveKeys(password, salt, keySize)
+		defer clear(dk)
+		if subtle.ConstantTimeCompare(v, verifier) != 1 {
+			return ErrPassword
+		}
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return err
+		}
+		o.stream = newCTR(block)
+		o.mac = hmac.New(sha1.New, macKey)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("entry %q: %w", f.Name, err)
+	}
+	o.data = io.LimitReader(raw, int64(f.CompressedSize64-overhead))
+
+	var rc io.ReadCloser = io.NopCloser(o)
+	if f.Method != methodStore {
+		rc, err = decompressor(f.Method).Decompress(o)
+		if err != nil {
+			return nil, err
+		}
+	}
+	e := &entryReader{ReadCloser: rc, file: f, opener: o}
+	if f.aes.version == ae1 {
+		e.crc = crc32.NewIEEE()
+	}
+	return e, nil
+}
+
+func decompressor(method uint16) codec.Decompressor {
+	switch method {
+	case methodDeflate:
+		return codec.DeflateDecompressor{}
+	case methodZstd:
+		return codec.ZstdDecompressor{}
+	default:
+		return nil
+	}
+}
+
+// decompressError adapts a codec.Decompressor to zip.Decompressor, which cannot fail.
+func decompressError(rc io.ReadCloser, err error) io.ReadCloser {
+	if err != nil {
+		return io.NopCloser(&errReader{err: err})
+	}
+	return rc
+}
+
+type errReader struct{ err error }
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// --- Entries ---
+
+// opener decrypts the encrypted data of an entry and checks the authentication code that
+// follows it.
+type opener struct {
+	raw    io.Reader
+	data   io.Reader
+	stream *ctr
+	mac    hash.Hash
+	err    error
+}
+
+func (o *opener) Read(p []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	n, err := o.data.Read(p)
+	o.mac.Write(p[:n])
+	o.stream.XORKeyStream(p[:n], p[:n])
+	if err == io.EOF {
+		authCode := make([]byte, authCodeSize)
+		if _, err := io.ReadFull(o.raw, authCode); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			o.err = err
+		} else if !hmac.Equal(authCode, o.mac.Sum(nil)[:authCodeSize]) {
+			o.err = ErrAuthentication
+		} else {
+			o.err = io.EOF
+		}
+		return n, o.err
+	}
+	return n, err
+}
+
+// entryReader checks the size, and the CRC of AE-1 entries, once the decompressor reports the
+// end of the data. Compressed formats end on their own, so it drains the encrypted data to
+// reach the authentication code.
+type entryReader struct {
+	io.ReadCloser
+	file   *File
+	opener *opener
+	crc    hash.Hash32
+	size   uint64
+}
+
+func (e *entryReader) Read(p []byte) (int, error) {
+	n, err := e.ReadCloser.Read(p)
+	e.size += uint64(n)
+	if e.crc != nil {
+		e.crc.Write(p[:n])
+	}
+	if err == nil {
+		return n, nil
+	}
+	// Tampered data usually fails decompression first; report it as such.
+	if _, authErr := io.Copy(io.Discard, e.opener); authErr != nil {
+		return n, authErr
+	}
+	if err != io.EOF {
+		return n, err
+	}
+	if e.size != e.file.UncompressedSize64 {
+		return n, fmt.Errorf("entry %q: size mismatch: got %d bytes, want %d", e.file.Name, e.size, e.file.UncompressedSize64)
+	}
+	if e.crc != nil && e.crc.Sum32() != e.file.CRC32 {
+		return n, fmt.Errorf("entry %q: %w", e.file.Name, zip.ErrChecksum)
+	}
+	return n, io.EOF
+}
@@ -0,0 +1,156 @@
+package winzip
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+type fixtureFile struct {
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type fixtures struct {
+	Password string                 `json:"password"`
+	Files    map[string]fixtureFile `json:"files"`
+	Archives []struct {
+		File     string   `json:"file"`
+		Password string   `json:"password"` // overrides the common password
+		AE       uint16   `json:"ae"`       // if set, the AE version of every encrypted entry
+		Entries  []string `json:"entries"`
+	} `json:"archives"`
+}
+
+func loadFixtures(t *testing.T) fixtures {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "vectors.json"))
+	require.NoError(t, err)
+	var f fixtures
+	require.NoError(t, json.Unmarshal(data, &f))
+	return f
+}
+
+func openArchive(t *testing.T, data []byte, password string) *Reader {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)), []byte(password))
+	require.NoError(t, err)
+	return r
+}
+
+func readEntry(f *File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// The fixtures were written by libarchive and 7-Zip; see testdata/gen_fixtures.sh.
+func TestReader_Fixtures(t *testing.T) {
+	fx := loadFixtures(t)
+	for _, archive := range fx.Archives {
+		t.Run(archive.File, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", archive.File))
+			require.NoError(t, err)
+			password := fx.Password
+			if archive.Password != "" {
+				password = archive.Password
+			}
+			r := openArchive(t, data, password)
+
+			var names []string
+			for _, f := range r.File {
+				names = append(names, f.Name)
+				got, err := readEntry(f)
+				require.NoError(t, err, f.Name)
+				want, ok := fx.Files[f.Name]
+				if !ok {
+					assert.False(t, f.Encrypted, f.Name)
+					assert.Empty(t, got)
+					continue
+				}
+				assert.True(t, f.Encrypted, f.Name)
+				if archive.AE != 0 {
+					assert.Equal(t, archive.AE, f.aes.version, f.Name)
+				}
+				assert.Len(t, got, want.Size, f.Name)
+				sum := sha256.Sum256(got)
+				assert.Equal(t, want.SHA256, hex.EncodeToString(sum[:]), f.Name)
+			}
+			assert.Equal(t, archive.Entries, names)
+		})
+	}
+}
+
+func TestReader_WrongPassword(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "libarchive-aes256-deflate.zip"))
+	require.NoError(t, err)
+	r := openArchive(t, data, "wrong horse")
+	_, err = r.File[0].Open()
+	require.ErrorIs(t, err, ErrPassword)
+
+	data, err = os.ReadFile(filepath.Join("testdata", "7zip-aes256-store.zip"))
+	require.NoError(t, err)
+	_, err = openArchive(t, data, "correct horse").File[0].Open()
+	require.ErrorIs(t, err, ErrPassword)
+}
+
+func TestReader_Tampering(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "libarchive-aes256-deflate.zip"))
+	require.NoError(t, err)
+	r := openArchive(t, data, "correct horse")
+	report := r.File[3]
+	require.Equal(t, "docs/report.csv", report.Name)
+	offset, err := report.zf.DataOffset()
+	require.NoError(t, err)
+
+	// Flip a bit in the encrypted data, and in the authentication code.
+	for _, pos := range []int64{offset + writerSaltSize + verifierSize + 100, offset + int64(report.CompressedSize64) - 1} {
+		tampered := bytes.Clone(data)
+		tampered[pos] ^= 1
+		_, err := readEntry(openArchive(t, tampered, "correct horse").File[3])
+		require.ErrorIs(t, err, ErrAuthentication)
+	}
+
+	// AE-2 entries carry no CRC, so the authentication code alone catches the damage.
+	data, err = os.ReadFile(filepath.Join("testdata", "7zip-aes256-deflate.zip"))
+	require.NoError(t, err)
+	macbeth := openArchive(t, data, "golang").File[0]
+	require.Equal(t, uint16(ae2), macbeth.aes.version)
+	offset, err = macbeth.zf.DataOffset()
+	require.NoError(t, err)
+	tampered := bytes.Clone(data)
+	tampered[offset+int64(macbeth.CompressedSize64)/2] ^= 1
+	_, err = readEntry(openArchive(t, tampered, "golang").File[0])
+	require.ErrorIs(t, err, ErrAuthentication)
+}
+
+func TestReader_PolicyAndDestroy(t *testing.T) {
+	t.Cleanup(func() { policy.SetDefault(nil) })
+	data, err := os.ReadFile(filepath.Join("testdata", "libarchive-aes128-deflate.zip"))
+	require.NoError(t, err)
+	r := openArchive(t, data, "correct horse")
+
+	policy.SetDefault(policy.FIPS())
+	_, err = r.File[0].Open()
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+	require.ErrorContains(t, err, "cipher aes-128-ctr")
+	policy.SetDefault(nil)
+
+	r.Destroy()
+	_, err = r.File[0].Open()
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+}
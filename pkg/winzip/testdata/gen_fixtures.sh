@@ -0,0 +1,39 @@
+#!/bin/sh
+# Regenerates the WinZip AES fixtures in this directory with libarchive's bsdtar (3.7.7 was
+# used), which writes AE-2 entries for files under 20 bytes and AE-1 entries otherwise.
+# vectors.json lists the expected contents; update it if the sources below change.
+#
+# The 7zip-*.zip fixtures are not regenerated here. They were written by 7-Zip on Windows
+# (made-by version 6.3 on NTFS, NTFS time extra fields, AES-256 AE-2 entries throughout) and come
+# from the testdata of github.com/yeka/zip (MIT license, Copyright (C) 2015 Alex Mullins; see
+# LICENSE.yeka-zip):
+# 7zip-aes256-deflate.zip is macbeth-act1.zip and 7zip-aes256-store.zip is hello-aes.zip, both
+# with the password "golang". vectors.json lists their contents as decoded by that package.
+set -eu
+
+here=$(cd "$(dirname "$0")" && pwd)
+src=$(mktemp -d)
+trap 'rm -rf "$src"' EXIT
+
+mkdir "$src/docs"
+printf 'hi partner\n' > "$src/tiny.txt"
+printf 'Quarterly export for partners.\n' > "$src/readme.txt"
+i=0
+while [ $i -lt 2000 ]; do
+	echo "$i,$((i * 7919 % 1000003)),partner-$((i % 17))"
+	i=$((i + 1))
+done > "$src/docs/report.csv"
+touch -d '2024-03-01 12:00:00 UTC' "$src/tiny.txt" "$src/readme.txt" "$src/docs/report.csv" "$src/docs"
+
+cd "$src"
+rm -f "$here"/libarchive-*.zip
+bsdtar -a -cf "$here/libarchive-aes256-deflate.zip" --options zip:encryption=aes256 \
+	--passphrase 'correct horse' tiny.txt readme.txt docs
+bsdtar -a -cf "$here/libarchive-aes256-store.zip" --options zip:encryption=aes256,zip:compression=store \
+	--passphrase 'correct horse' tiny.txt readme.txt
+bsdtar -a -cf "$here/libarchive-aes128-deflate.zip" --options zip:encryption=aes128 \
+	--passphrase 'correct horse' tiny.txt docs/report.csv
+
+for f in tiny.txt readme.txt docs/report.csv; do
+	printf '%s %s %s\n' "$f" "$(wc -c < "$f" | tr -d ' ')" "$(sha256sum "$f" | cut -d' ' -f1)"
+done
@@ -0,0 +1,255 @@
+package winzip
+
+import (
+	"archive/zip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // see aes.go
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+// --- Writer ---
+
+// Writer streams a ZIP archive of AES-256 AE-2 entries to an io.Writer, without seeking: the
+// sizes follow each entry in a data descriptor. Directories ("name/") are not encrypted.
+type Writer struct {
+	// Compressor compresses entries before encryption: codec.DeflateCompressor, the default,
+	// or codec.ZstdCompressor (ZIP method 93), which fewer tools can extract.
+	Compressor codec.Compressor
+
+	// SecretKey holds the password; Destroy zeroes it.
+	SecretKey *crypt.SecretKey
+
+	zw    *zip.Writer
+	entry *entryWriter
+}
+
+var _ policy.Describer = &Writer{}
+
+// NewWriter copies password into a crypt.SecretKey, so the caller can wipe it afterwards.
+func NewWriter(w io.Writer, password []byte) *Writer {
+	return &Writer{
+		SecretKey: crypt.NewSecretKey(password),
+		zw:        zip.NewWriter(w),
+	}
+}
+
+// Destroy zeroes the password; entries cannot be created afterwards.
+func (w *Writer) Destroy() {
+	if w.SecretKey != nil {
+		w.SecretKey.Destroy()
+	}
+}
+
+func (w *Writer) Algorithms() []policy.Algorithm {
+	algs := entryAlgorithms(writerKeySize)
+	if d, ok := w.compressor().(policy.Describer); ok {
+		algs = append(algs, d.Algorithms()...)
+	}
+	return algs
+}
+
+func (w *Writer) compressor() codec.Compressor {
+	if w.Compressor == nil {
+		return codec.DeflateCompressor{}
+	}
+	return w.Compressor
+}
+
+// Create adds an encrypted entry modified now. The entry must be written before the next call
+// to Create, CreateHeader or Close.
+func (w *Writer) Create(name string) (io.Writer, error) {
+	return w.CreateHeader(&zip.FileHeader{Name: name, Modified: time.Now()})
+}
+
+// CreateHeader adds an encrypted entry described by fh, which is copied. Name, Modified,
+// Comment, Extra and the mode (SetMode) are kept; the method and sizes are set by the writer.
+func (w *Writer) CreateHeader(fh *zip.FileHeader) (io.Writer, error) {
+	if err := w.finishEntry(); err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(fh.Name, "/") {
+		return w.zw.CreateHeader(fh)
+	}
+	if err := policy.Check(w); err != nil {
+		return nil, err
+	}
+	compressor := w.compressor()
+	method, err := zipMethod(compressor)
+	if err != nil {
+		return nil, err
+	}
+
+	h := *fh
+	h.Method = methodAES
+	h.Flags |= flagEncrypted | flagDataDescriptor
+	if !h.NonUTF8 && hasNonASCII(h.Name) && utf8.ValidString(h.Name) {
+		h.Flags |= flagUTF8
+	}
+	h.Extra = append(slices.Clone(fh.Extra), aesExtra{version: ae2, strength: aes256, method: method}.marshal()...)
+	if !h.Modified.IsZero() {
+		h.ModifiedDate, h.ModifiedTime = msDosTime(h.Modified)
+		h.Extra = append(h.Extra, extendedTimestamp(h.Modified)...)
+	}
+	h.CreatorVersion = h.CreatorVersion&0xff00 | zipVersionAES
+	h.ReaderVersion = zipVersionAES
+	h.CRC32, h.CompressedSize64, h.UncompressedSize64 = 0, 0, 0 // AE-2 leaves the CRC out
+
+	salt, err := aesgcm.GenerateRandomNBytes(writerSaltSize)
+	if err != nil {
+		return nil, err
+	}
+	var block cipher.Block
+	var mac hash.Hash
+	var verifier []byte
+	err = w.SecretKey.Use(func(password []byte) error {
+		dk, encKey, macKey, v := deriveKeys(password, salt, writerKeySize)
+		defer clear(dk)
+		block, err = aes.NewCipher(encKey)
+		mac = hmac.New(sha1.New, macKey)
+		verifier = slices.Clone(v)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := w.zw.CreateRaw(&h)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := raw.Write(append(salt, verifier...)); err != nil {
+		return nil, err
+	}
+	s := &sealer{w: raw, stream: newCTR(block), mac: mac}
+	comp, err := compressor.NewWriter(s)
+	if err != nil {
+		return nil, err
+	}
+	w.entry = &entryWriter{header: &h, raw: raw, comp: comp, sealer: s}
+	return w.entry, nil
+}
+
+// Close finishes the last entry and writes the central directory. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if err := w.finishEntry(); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+// finishEntry writes the authentication code of the current entry and records its sizes.
+// archive/zip keeps the header passed to CreateRaw and writes the data descriptor and central
+// directory entry from it on the next CreateRaw or Close, so the sizes are filled in first.
+func (w *Writer) finishEntry() error {
+	e := w.entry
+	if e == nil {
+		return nil
+	}
+	w.entry = nil
+	e.closed = true
+	if err := e.comp.Close(); err != nil {
+		return err
+	}
+	if _, err := e.raw.Write(e.sealer.mac.Sum(nil)[:authCodeSize]); err != nil {
+		return err
+	}
+	h := e.header
+	h.CompressedSize64 = writerSaltSize + verifierSize + e.sealer.n + authCodeSize
+	h.UncompressedSize64 = e.size
+	h.CompressedSize = uint32(min(h.CompressedSize64, math.MaxUint32))
+	h.UncompressedSize = uint32(min(h.UncompressedSize64, math.MaxUint32))
+	return nil
+}
+
+func zipMethod(c codec.Compressor) (uint16, error) {
+	switch c.Name() {
+	case codec.DeflateCompName:
+		return methodDeflate, nil
+	case codec.ZstdCompName:
+		return methodZstd, nil
+	default:
+		return 0, fmt.Errorf("unsupported zip entry compression: %s", c.Name())
+	}
+}
+
+// --- Entries ---
+
+type entryWriter struct {
+	header *zip.FileHeader
+	raw    io.Writer
+	comp   codec.WriteFlushCloser
+	sealer *sealer
+	size   uint64
+	closed bool
+}
+
+func (e *entryWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to finished zip entry")
+	}
+	n, err := e.comp.Write(p)
+	e.size += uint64(n)
+	return n, err
+}
+
+// sealer encrypts and authenticates the compressed data of an entry.
+type sealer struct {
+	w      io.Writer
+	stream cipher.Stream
+	mac    hash.Hash
+	buf    []byte
+	n      uint64
+}
+
+func (s *sealer) Write(p []byte) (int, error) {
+	s.buf = slices.Grow(s.buf[:0], len(p))[:len(p)]
+	s.stream.XORKeyStream(s.buf, p)
+	s.mac.Write(s.buf)
+	n, err := s.w.Write(s.buf)
+	s.n += uint64(n)
+	return n, err
+}
+
+// --- Helpers ---
+
+func hasNonASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+// msDosTime and extendedTimestamp record the modification time as zip.Writer.CreateHeader
+// does; CreateRaw writes headers as given. MS-DOS times have a two-second resolution and no
+// time zone, so the extended timestamp carries the Unix time as well.
+func msDosTime(t time.Time) (date, tm uint16) {
+	date = uint16(t.Day() + int(t.Month())<<5 + max(t.Year()-1980, 0)<<9)
+	tm = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, tm
+}
+
+func extendedTimestamp(t time.Time) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, extTimeExtraID)
+	b = binary.LittleEndian.AppendUint16(b, 5)
+	b = append(b, 1) // modification time only
+	return binary.LittleEndian.AppendUint32(b, uint32(t.Unix()))
+}
@@ -0,0 +1,99 @@
+package winzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/policy"
+)
+
+func TestWriter_Roundtrip(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := map[string][]byte{
+		"tiny.txt":          []byte("hi\n"),
+		"docs/report.csv":   bytes.Repeat([]byte("42,partner-7,exported\n"), 20000),
+		"empty.txt":         nil,
+		"docs/año-2024.txt": []byte("non-ASCII names are flagged as UTF-8"),
+	}
+	order := []string{"tiny.txt", "docs/", "docs/report.csv", "empty.txt", "docs/año-2024.txt"}
+
+	for _, compressor := range []codec.Compressor{nil, codec.ZstdCompressor{}} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, []byte("correct horse"))
+		w.Compressor = compressor
+		for _, name := range order {
+			fh := &zip.FileHeader{Name: name, Modified: modified}
+			fh.SetMode(0o640)
+			ew, err := w.CreateHeader(fh)
+			require.NoError(t, err)
+			_, err = ew.Write(entries[name])
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		r := openArchive(t, buf.Bytes(), "correct horse")
+		require.Len(t, r.File, len(order))
+		for i, f := range r.File {
+			require.Equal(t, order[i], f.Name)
+			got, err := readEntry(f)
+			require.NoError(t, err, f.Name)
+			assert.Equal(t, string(entries[f.Name]), string(got), f.Name)
+			assert.True(t, modified.Equal(f.Modified), f.Name)
+			if f.Name == "docs/" {
+				assert.False(t, f.Encrypted)
+				continue
+			}
+
+			// AE-2 with AES-256, and no CRC.
+			assert.True(t, f.Encrypted)
+			assert.Equal(t, aesExtra{version: ae2, strength: aes256, method: f.Method}, f.aes)
+			assert.Equal(t, uint16(zipVersionAES), f.ReaderVersion)
+			assert.Zero(t, f.CRC32)
+			assert.Equal(t, uint32(0o640), uint32(f.Mode().Perm()))
+			assert.Equal(t, f.Name == "docs/año-2024.txt", f.Flags&flagUTF8 != 0)
+		}
+		if compressor == nil {
+			assert.Equal(t, uint16(methodDeflate), r.File[0].Method)
+		} else {
+			assert.Equal(t, uint16(methodZstd), r.File[0].Method)
+		}
+	}
+}
+
+func TestWriter_EntryLifetime(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, []byte("pw"))
+	first, err := w.Create("first.txt")
+	require.NoError(t, err)
+	_, err = w.Create("second.txt")
+	require.NoError(t, err)
+	_, err = first.Write([]byte("late"))
+	require.ErrorContains(t, err, "write to finished zip entry")
+
+	w.Compressor = codec.GzipCompressor{}
+	_, err = w.Create("third.txt")
+	require.ErrorContains(t, err, "unsupported zip entry compression: gzip")
+}
+
+func TestWriter_PolicyAndDestroy(t *testing.T) {
+	t.Cleanup(func() { policy.SetDefault(nil) })
+	var buf bytes.Buffer
+	w := NewWriter(&buf, []byte("pw"))
+
+	policy.SetDefault(policy.FIPS())
+	_, err := w.Create("a.txt")
+	require.ErrorIs(t, err, policy.ErrNotAllowed)
+	require.ErrorContains(t, err, "cipher aes-256-ctr")
+	policy.SetDefault(nil)
+
+	w.Destroy()
+	_, err = w.Create("a.txt")
+	require.ErrorIs(t, err, crypt.ErrKeyDestroyed)
+}
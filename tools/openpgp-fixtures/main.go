@@ -0,0 +1,81 @@
+//go:build ignore
+
+// Command openpgp-fixtures writes the RFC 9580 fixtures of pkg/crypt/openpgp (v6 SKESK +
+// SEIPD v2, Argon2 S2K) with ProtonMail's go-crypto, since GnuPG cannot write them.
+//
+// The build tag keeps go-crypto out of this module: pkg/crypt/openpgp/testdata/gen_fixtures.sh
+// runs it in a scratch module that requires go-crypto v1.5.2.
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ProtonMail/go-crypto/openpgp/s2k"
+	pgp "github.com/ProtonMail/go-crypto/openpgp/v2"
+)
+
+const password = "correct horse"
+
+func encrypt(name string, mode packet.AEADMode, chunkSizeByte uint8, armored, compressed bool, plain []byte) {
+	config := &packet.Config{
+		DefaultCipher: packet.CipherAES256,
+		AEADConfig:    &packet.AEADConfig{DefaultMode: mode, ChunkSize: 1 << (chunkSizeByte + 6)},
+		S2KConfig: &s2k.Config{
+			S2KMode:      s2k.Argon2S2K,
+			Argon2Config: &s2k.Argon2Config{NumberOfPasses: 1, DegreeOfParallelism: 4, Memory: 8 * 1024},
+		},
+	}
+	if compressed {
+		config.DefaultCompressionAlgo = packet.CompressionZLIB
+	}
+	var out bytes.Buffer
+	var dst io.Writer = &out
+	var aw io.WriteCloser
+	if armored {
+		var err error
+		if aw, err = armor.Encode(&out, "PGP MESSAGE", nil); err != nil {
+			panic(err)
+		}
+		dst = aw
+	}
+	w, err := pgp.SymmetricallyEncrypt(dst, []byte(password), &pgp.FileHints{FileName: "message.txt"}, config)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	if aw != nil {
+		if err := aw.Close(); err != nil {
+			panic(err)
+		}
+	}
+	if err := os.WriteFile(name, out.Bytes(), 0o644); err != nil {
+		panic(err)
+	}
+}
+
+// usage: openpgp-fixtures <message file> <short message file> <output directory>
+func main() {
+	plain, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		panic(err)
+	}
+	short, err := os.ReadFile(os.Args[2])
+	if err != nil {
+		panic(err)
+	}
+	dir := os.Args[3]
+	encrypt(filepath.Join(dir, "gocrypto-ocb.pgp"), packet.AEADModeOCB, 4, false, false, plain)
+	encrypt(filepath.Join(dir, "gocrypto-gcm.pgp"), packet.AEADModeGCM, 4, false, false, plain)
+	encrypt(filepath.Join(dir, "gocrypto-ocb-zlib.asc"), packet.AEADModeOCB, 6, true, true, plain)
+	encrypt(filepath.Join(dir, "gocrypto-short.pgp"), packet.AEADModeOCB, 0, false, false, short)
+}